@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2driver"
+)
+
+// Time to wait for the first report of the device before running a
+// subcommand.
+const deviceReadyTimeout = 30 * time.Second
+
+// Opens the data source for a subcommand and waits until the device reported
+// once, so that its configuration was read.
+func openDevice(conf *config) (mk2driver.Mk2, error) {
+	mk2, err := getMk2Device(conf.Data.Source, conf.Data.Host, conf.Data.Device)
+	if err != nil {
+		return nil, fmt.Errorf("could not open data source: %w", err)
+	}
+	select {
+	case <-mk2.C():
+		return mk2, nil
+	case <-time.After(deviceReadyTimeout):
+		mk2.Close()
+		return nil, fmt.Errorf("device did not report within %v", deviceReadyTimeout)
+	}
+}
+
+// Asks question on out and reports whether it was answered with yes on in.
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
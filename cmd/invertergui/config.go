@@ -3,8 +3,13 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/diebietse/invertergui/ess"
+	"github.com/diebietse/invertergui/mk2core"
+	"github.com/diebietse/invertergui/mk2driver"
 	"github.com/jessevdk/go-flags"
 )
 
@@ -26,13 +31,67 @@ type config struct {
 		Username     string `long:"mqtt.username" env:"MQTT_USERNAME" default:"" description:"Set the MQTT username"`
 		Password     string `long:"mqtt.password" env:"MQTT_PASSWORD" default:"" description:"Set the MQTT password"`
 		PasswordFile string `long:"mqtt.password-file" env:"MQTT_PASSWORD_FILE" default:"" description:"Path to a file containing the MQTT password"`
+		CommandTopic string `long:"mqtt.command_topic" env:"MQTT_COMMAND_TOPIC" default:"" description:"Set the MQTT topic below which commands are received. Commands are disabled when empty."`
+	}
+	Assistant struct {
+		Vars         []string `long:"assistant.vars" env:"ASSISTANT_VARS" env-delim:"," description:"Assistant RAM variable to poll, as name=id or name=assistant:index. Can be repeated."`
+		InRealPower  string   `long:"assistant.in_real_power" env:"ASSISTANT_IN_REAL_POWER" description:"Assistant RAM variable of the real AC input power in W, as id or assistant:index. Used with assistant.out_real_power for the grid power, losses and energy."`
+		OutRealPower string   `long:"assistant.out_real_power" env:"ASSISTANT_OUT_REAL_POWER" description:"Assistant RAM variable of the real AC output power in W, as id or assistant:index. Used with assistant.in_real_power for the grid power, losses and energy."`
+	}
+	ESS struct {
+		Enabled     bool          `long:"ess.enabled" env:"ESS_ENABLED" description:"Enable ESS grid setpoint control."`
+		SetpointVar string        `long:"ess.setpoint_var" env:"ESS_SETPOINT_VAR" default:"ess:0" description:"Assistant RAM variable of the grid setpoint, as id or assistant:index."`
+		Min         float64       `long:"ess.min" env:"ESS_MIN" default:"-1000" description:"Minimum grid setpoint in W."`
+		Max         float64       `long:"ess.max" env:"ESS_MAX" default:"1000" description:"Maximum grid setpoint in W."`
+		Safe        float64       `long:"ess.safe" env:"ESS_SAFE" default:"0" description:"Grid setpoint in W applied when no target is set or the watchdog trips."`
+		Interval    time.Duration `long:"ess.interval" env:"ESS_INTERVAL" default:"5s" description:"Time between grid setpoint writes."`
+		Watchdog    time.Duration `long:"ess.watchdog" env:"ESS_WATCHDOG" default:"60s" description:"Time without target updates after which the safe setpoint is applied."`
+	}
+	Energy struct {
+		File         string        `long:"energy.file" env:"ENERGY_FILE" description:"State file the energy counters are kept in across restarts. The counters start at zero on every start when empty."`
+		SaveInterval time.Duration `long:"energy.save_interval" env:"ENERGY_SAVE_INTERVAL" default:"1m" description:"Time between saves of the energy counters to the state file."`
+	}
+	History struct {
+		Window     time.Duration `long:"history.window" env:"HISTORY_WINDOW" default:"1h" description:"Time for which every update is kept in the history, older updates are kept downsampled for a week."`
+		MaxSamples int           `long:"history.max_samples" env:"HISTORY_MAX_SAMPLES" default:"7200" description:"Limit on the number of updates kept in the history window."`
+	}
+	Store struct {
+		Dir          string        `long:"store.dir" env:"STORE_DIR" description:"Directory in which the history is stored on disk instead of only in memory. The store is disabled when empty."`
+		RawRetention time.Duration `long:"store.raw_retention" env:"STORE_RAW_RETENTION" default:"24h" description:"Time for which every update is stored, older updates are downsampled."`
+		Step         time.Duration `long:"store.step" env:"STORE_STEP" default:"5m" description:"Time covered by each downsampled point, a divisor of an hour."`
+		Retention    time.Duration `long:"store.retention" env:"STORE_RETENTION" default:"8760h" description:"Time for which downsampled points are stored."`
+	}
+	Subscriptions []string `long:"subscription" env:"SUBSCRIPTIONS" env-delim:"," description:"Buffering of the updates sent to a plugin (cli, webui, munin, prometheus, mqtt, history, store), as name=policy[:buffer[:timeout]] with the policy drop_newest, drop_oldest, block or latest_only. Can be repeated."`
+	Filters       []string `long:"filter" env:"FILTERS" env-delim:"," description:"Filter of the updates sent to a plugin, as name:on_change, name:min_interval=duration, name:max_interval=duration or name:deadband.field=value. Can be repeated."`
+	Raw           struct {
+		Token string `long:"raw.token" env:"RAW_TOKEN" description:"Bearer token that authorizes raw protocol commands on /api/raw. The endpoint is disabled when empty."`
+	}
+	Control struct {
+		Token string `long:"control.token" env:"CONTROL_TOKEN" description:"Bearer token that authorizes setting the switches on /api/switch and the ESS setpoint on /api/ess. Control over HTTP is disabled when empty."`
 	}
 	Loglevel string `long:"loglevel" env:"LOGLEVEL" default:"info" description:"The log level to generate logs at. (\"panic\", \"fatal\", \"error\", \"warn\", \"info\", \"debug\", \"trace\")"`
+
+	Settings   settingsCommand `command:"settings" description:"Back up and restore the device settings."`
+	RawCommand rawCommand      `command:"raw" description:"Send a raw command through a running invertergui and decode the reply."`
+	Decode     decodeCommand   `command:"decode" description:"Annotate MK2 frames from hex dumps, debug logs or binary captures read from stdin."`
+	State      stateCommand    `command:"state" description:"Print the latest update of a running invertergui as JSON."`
+
+	// Subcommand to run instead of the web server, nil if none was given.
+	command flags.Commander
 }
 
 func parseConfig() (*config, error) {
 	conf := &config{}
+	conf.Settings.Dump.conf = conf
+	conf.Settings.Restore.conf = conf
+	conf.RawCommand.conf = conf
 	parser := flags.NewParser(conf, flags.Default)
+	parser.SubcommandsOptional = true
+	// Subcommands are run by main once the configuration is complete.
+	parser.CommandHandler = func(command flags.Commander, args []string) error {
+		conf.command = command
+		return nil
+	}
 	if _, err := parser.Parse(); err != nil {
 		return nil, err
 	}
@@ -56,6 +115,166 @@ func resolvePasswordFile(conf *config) error {
 	return nil
 }
 
+// assistantVars returns the assistant variables to poll: those of
+// assistant.vars and the real power variables, which are polled under the
+// names the core derives the real powers from.
+func assistantVars(conf *config) ([]mk2driver.AssistantVar, error) {
+	refs := conf.Assistant.Vars
+	if (conf.Assistant.InRealPower == "") != (conf.Assistant.OutRealPower == "") {
+		return nil, fmt.Errorf("assistant.in_real_power and assistant.out_real_power must be set together")
+	}
+	if conf.Assistant.InRealPower != "" {
+		refs = append(refs[:len(refs):len(refs)],
+			mk2core.InRealPowerVar+"="+conf.Assistant.InRealPower,
+			mk2core.OutRealPowerVar+"="+conf.Assistant.OutRealPower)
+	}
+	return parseAssistantVars(refs)
+}
+
+func parseAssistantVars(refs []string) ([]mk2driver.AssistantVar, error) {
+	vars := make([]mk2driver.AssistantVar, 0, len(refs))
+	names := map[string]bool{}
+	for _, ref := range refs {
+		v, err := mk2driver.ParseAssistantVar(ref)
+		if err != nil {
+			return nil, err
+		}
+		if names[v.Name] {
+			return nil, fmt.Errorf("assistant variable %s configured more than once", v.Name)
+		}
+		names[v.Name] = true
+		vars = append(vars, v)
+	}
+	return vars, nil
+}
+
+// defaultSubscriptions holds the buffering of the plugin subscriptions. Plugins
+// that only show the current state get the latest update, those that count
+// or forward every update get a buffer.
+var defaultSubscriptions = map[string]mk2core.SubscriptionOptions{
+	"cli":        {Policy: mk2core.LatestOnly},
+	"webui":      {Policy: mk2core.LatestOnly},
+	"munin":      {Buffer: 8, Policy: mk2core.DropOldest},
+	"prometheus": {Buffer: 8, Policy: mk2core.DropOldest},
+	"mqtt":       {Buffer: 16, Policy: mk2core.DropOldest},
+	"history":    {Buffer: 16, Policy: mk2core.DropOldest},
+	"store":      {Buffer: 64, Policy: mk2core.DropOldest},
+}
+
+// parseSubscriptions returns the subscription options of every plugin, with
+// the defaults overridden by refs like mqtt=block:16:2s.
+func parseSubscriptions(refs []string) (map[string]mk2core.SubscriptionOptions, error) {
+	subs := map[string]mk2core.SubscriptionOptions{}
+	for name, opts := range defaultSubscriptions {
+		opts.Name = name
+		subs[name] = opts
+	}
+	for _, ref := range refs {
+		name, value, ok := strings.Cut(ref, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid subscription %q, expected name=policy[:buffer[:timeout]]", ref)
+		}
+		opts, ok := subs[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown subscription %q", name)
+		}
+		fields := strings.Split(value, ":")
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("invalid subscription %q, expected name=policy[:buffer[:timeout]]", ref)
+		}
+		opts.Policy, ok = mk2core.DropPolicyNames[fields[0]]
+		if !ok {
+			return nil, fmt.Errorf("unknown drop policy %q for subscription %s", fields[0], name)
+		}
+		opts.Buffer = 0
+		opts.Timeout = 0
+		if len(fields) > 1 {
+			buffer, err := strconv.Atoi(fields[1])
+			if err != nil || buffer < 0 {
+				return nil, fmt.Errorf("invalid buffer size %q for subscription %s", fields[1], name)
+			}
+			opts.Buffer = buffer
+		}
+		if len(fields) > 2 {
+			timeout, err := time.ParseDuration(fields[2])
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid timeout %q for subscription %s", fields[2], name)
+			}
+			opts.Timeout = timeout
+		}
+		subs[name] = opts
+	}
+	return subs, nil
+}
+
+// parseFilters sets the filters of subs from refs like mqtt:on_change or
+// mqtt:deadband.bat_voltage=0.05.
+func parseFilters(subs map[string]mk2core.SubscriptionOptions, refs []string) error {
+	for _, ref := range refs {
+		name, option, ok := strings.Cut(ref, ":")
+		if !ok {
+			return fmt.Errorf("invalid filter %q, expected name:option[=value]", ref)
+		}
+		opts, ok := subs[name]
+		if !ok {
+			return fmt.Errorf("unknown subscription %q", name)
+		}
+		key, value, hasValue := strings.Cut(option, "=")
+		filter := &opts.Filter
+		switch {
+		case key == "on_change" && !hasValue:
+			filter.OnChange = true
+		case key == "min_interval" || key == "max_interval":
+			interval, err := time.ParseDuration(value)
+			if err != nil || interval <= 0 {
+				return fmt.Errorf("invalid %s %q for subscription %s", key, value, name)
+			}
+			if key == "min_interval" {
+				filter.MinInterval = interval
+			} else {
+				filter.MaxInterval = interval
+			}
+		case strings.HasPrefix(key, "deadband.") && hasValue:
+			field := strings.TrimPrefix(key, "deadband.")
+			if _, ok := mk2core.Fields[field]; !ok {
+				return fmt.Errorf("unknown field %q for subscription %s, expected one of %s",
+					field, name, strings.Join(mk2core.FieldNames(), ", "))
+			}
+			deadband, err := strconv.ParseFloat(value, 64)
+			if err != nil || deadband < 0 {
+				return fmt.Errorf("invalid deadband %q for subscription %s", value, name)
+			}
+			deadbands := map[string]float64{field: deadband}
+			for f, d := range filter.Deadbands {
+				if f != field {
+					deadbands[f] = d
+				}
+			}
+			filter.Deadbands = deadbands
+			filter.OnChange = true
+		default:
+			return fmt.Errorf("invalid filter %q, expected on_change, min_interval, max_interval or deadband.<field>", ref)
+		}
+		subs[name] = opts
+	}
+	return nil
+}
+
+func essConfig(conf *config) (ess.Config, error) {
+	setpointVar, err := mk2driver.ParseAssistantVar("setpoint=" + conf.ESS.SetpointVar)
+	if err != nil {
+		return ess.Config{}, err
+	}
+	return ess.Config{
+		SetpointVar: setpointVar,
+		Min:         conf.ESS.Min,
+		Max:         conf.ESS.Max,
+		Safe:        conf.ESS.Safe,
+		Interval:    conf.ESS.Interval,
+		Watchdog:    conf.ESS.Watchdog,
+	}, nil
+}
+
 func readPasswordFile(path string) (string, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
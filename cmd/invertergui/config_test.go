@@ -3,7 +3,12 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2core"
+	"github.com/diebietse/invertergui/mk2driver"
 )
 
 const testInlineSecret = "inline-secret"
@@ -105,3 +110,109 @@ func TestResolvePassword_NoFile(t *testing.T) {
 		t.Errorf("got %q, want %q", conf.MQTT.Password, testInlineSecret)
 	}
 }
+
+func TestParseAssistantVars(t *testing.T) {
+	vars, err := parseAssistantVars([]string{"setpoint=ess:1", "raw=0x90"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []mk2driver.AssistantVar{
+		{Name: "setpoint", AssistantID: mk2driver.AssistantESS, Index: 1},
+		{Name: "raw", ID: 0x90},
+	}
+	if len(vars) != len(want) || vars[0] != want[0] || vars[1] != want[1] {
+		t.Errorf("got %+v, want %+v", vars, want)
+	}
+
+	if _, err := parseAssistantVars([]string{"raw=0x90", "raw=0x91"}); err == nil {
+		t.Error("expected error for duplicate names, got nil")
+	}
+}
+
+func TestAssistantVars(t *testing.T) {
+	conf := &config{}
+	conf.Assistant.Vars = []string{"raw=0x90"}
+	conf.Assistant.InRealPower = "0x91"
+	conf.Assistant.OutRealPower = "ess:3"
+	vars, err := assistantVars(conf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []mk2driver.AssistantVar{
+		{Name: "raw", ID: 0x90},
+		{Name: mk2core.InRealPowerVar, ID: 0x91},
+		{Name: mk2core.OutRealPowerVar, AssistantID: mk2driver.AssistantESS, Index: 3},
+	}
+	if len(vars) != len(want) || vars[0] != want[0] || vars[1] != want[1] || vars[2] != want[2] {
+		t.Errorf("got %+v, want %+v", vars, want)
+	}
+
+	conf.Assistant.OutRealPower = ""
+	if _, err := assistantVars(conf); err == nil {
+		t.Error("expected error for a single real power variable, got nil")
+	}
+}
+
+func TestParseSubscriptions(t *testing.T) {
+	subs, err := parseSubscriptions([]string{"mqtt=block:4:2s", "munin=drop_newest"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]mk2core.SubscriptionOptions{
+		"mqtt":  {Name: "mqtt", Buffer: 4, Policy: mk2core.Block, Timeout: 2 * time.Second},
+		"munin": {Name: "munin", Policy: mk2core.DropNewest},
+		"webui": {Name: "webui", Policy: mk2core.LatestOnly},
+	}
+	for name, opts := range want {
+		if !reflect.DeepEqual(subs[name], opts) {
+			t.Errorf("%s: got %+v, want %+v", name, subs[name], opts)
+		}
+	}
+	if len(subs) != len(defaultSubscriptions) {
+		t.Errorf("got %d subscriptions, want %d", len(subs), len(defaultSubscriptions))
+	}
+
+	for _, ref := range []string{"mqtt", "mqtt=fast", "mqtt=block:x", "mqtt=block:1:x", "mqtt=block:1:1s:1", "other=block"} {
+		if _, err := parseSubscriptions([]string{ref}); err == nil {
+			t.Errorf("expected error for %q, got nil", ref)
+		}
+	}
+}
+
+func TestParseFilters(t *testing.T) {
+	subs, err := parseSubscriptions(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = parseFilters(subs, []string{
+		"mqtt:min_interval=2s",
+		"mqtt:max_interval=1m",
+		"mqtt:deadband.bat_voltage=0.05",
+		"mqtt:deadband.in_voltage=1",
+		"webui:on_change",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := mk2core.Filter{
+		MinInterval: 2 * time.Second,
+		MaxInterval: time.Minute,
+		OnChange:    true,
+		Deadbands:   map[string]float64{"bat_voltage": 0.05, "in_voltage": 1},
+	}
+	if !reflect.DeepEqual(subs["mqtt"].Filter, want) {
+		t.Errorf("got %+v, want %+v", subs["mqtt"].Filter, want)
+	}
+	if !subs["webui"].Filter.OnChange {
+		t.Error("webui filter not set")
+	}
+	if !reflect.DeepEqual(subs["munin"].Filter, mk2core.Filter{}) {
+		t.Errorf("got munin filter %+v, want none", subs["munin"].Filter)
+	}
+
+	for _, ref := range []string{"mqtt", "other:on_change", "mqtt:on_change=1", "mqtt:min_interval=x", "mqtt:deadband.fan=1", "mqtt:deadband.bat_voltage=-1", "mqtt:often"} {
+		if err := parseFilters(subs, []string{ref}); err == nil {
+			t.Errorf("expected error for %q, got nil", ref)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/diebietse/invertergui/mk2driver"
+)
+
+type decodeCommand struct{}
+
+func (c *decodeCommand) Execute(args []string) error {
+	return decodeInput(os.Stdin, os.Stdout)
+}
+
+// Decodes hex dumps, debug logs or a binary capture read from r and writes
+// the annotated frames to w.
+func decodeInput(r io.Reader, w io.Writer) error {
+	input, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	d := &frameDecoder{decoder: mk2driver.NewDecoder(), w: w}
+	if !isText(input) {
+		d.decodeStream(input)
+		return nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	for scanner.Scan() {
+		d.decodeLine(scanner.Text())
+	}
+	d.flush()
+	return scanner.Err()
+}
+
+type frameDecoder struct {
+	decoder *mk2driver.Decoder
+	w       io.Writer
+	// Hex dump bytes that are split into frames once the dump ends.
+	stream []byte
+}
+
+// Decodes a line of text. Driver debug log lines hold a single frame, other
+// lines are read as part of a hex dump of the byte stream.
+func (d *frameDecoder) decodeLine(line string) {
+	if start := strings.Index(line, "[]byte{"); start >= 0 {
+		data, ok := parseByteLiteral(line[start:])
+		if !ok {
+			return
+		}
+		switch {
+		case strings.Contains(line, "sendCommand"):
+			d.flush()
+			// Sent commands are logged with their length byte.
+			if len(data) > 1 {
+				d.print(d.decoder.Decode(mk2driver.DirectionSent, data[1:]))
+			}
+		case strings.Contains(line, "[handleFrame] frame"):
+			d.flush()
+			d.print(d.decoder.Decode(mk2driver.DirectionReceived, data))
+		default:
+			d.stream = append(d.stream, data...)
+		}
+		return
+	}
+	if data, ok := parseHexLine(line); ok {
+		d.stream = append(d.stream, data...)
+	}
+}
+
+func (d *frameDecoder) flush() {
+	if len(d.stream) > 0 {
+		d.decodeStream(d.stream)
+		d.stream = nil
+	}
+}
+
+func (d *frameDecoder) decodeStream(stream []byte) {
+	frames, skipped := mk2driver.SplitFrames(stream)
+	if skipped > 0 {
+		fmt.Fprintf(d.w, "skipped %d bytes outside of frames\n", skipped)
+	}
+	for _, frame := range frames {
+		d.print(d.decoder.Decode(mk2driver.DirectionUnknown, frame))
+	}
+}
+
+func (d *frameDecoder) print(f mk2driver.DecodedFrame) {
+	checksum := "checksum ok"
+	if !f.ChecksumValid {
+		checksum = "checksum invalid"
+	}
+	// Print the frame as on the wire, with its length byte.
+	wire := append([]byte{byte(len(f.Frame) - 1)}, f.Frame...)
+	fmt.Fprintf(d.w, "%s %-10s % x  %s", f.Direction, f.Type, wire, checksum)
+	if f.Command != "" {
+		fmt.Fprintf(d.w, "  %s", f.Command)
+	}
+	fmt.Fprintln(d.w)
+	for _, field := range f.Fields {
+		fmt.Fprintf(d.w, "    %s: %s", field.Name, field.Value)
+		if field.Scaling != "" {
+			fmt.Fprintf(d.w, " (%s)", field.Scaling)
+		}
+		fmt.Fprintln(d.w)
+	}
+	if f.Error != "" {
+		fmt.Fprintf(d.w, "    error: %s\n", f.Error)
+	}
+}
+
+// Reports whether input is text rather than a binary capture.
+func isText(input []byte) bool {
+	for _, b := range input {
+		if b >= unicode.MaxASCII || (b < ' ' && !unicode.IsSpace(rune(b))) {
+			return false
+		}
+	}
+	return true
+}
+
+// Parses a Go byte slice literal like []byte{0x5, 0xff} as logged with %#v.
+func parseByteLiteral(s string) ([]byte, bool) {
+	s = strings.TrimPrefix(s, "[]byte{")
+	end := strings.Index(s, "}")
+	if end < 0 {
+		return nil, false
+	}
+	var data []byte
+	for _, item := range strings.Split(s[:end], ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		b, ok := parseHexByte(strings.TrimPrefix(item, "0x"))
+		if !ok {
+			return nil, false
+		}
+		data = append(data, b)
+	}
+	return data, true
+}
+
+// Parses a line of hex bytes like "05 ff 57", "0x05,0xff" or "05ff57". The
+// offset and ASCII columns of hexdump -C output are ignored. Lines holding
+// anything else are skipped.
+func parseHexLine(line string) ([]byte, bool) {
+	i := strings.Index(line, "|")
+	if i >= 0 {
+		line = line[:i]
+	}
+	tokens := strings.FieldsFunc(line, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ',' || r == ':'
+	})
+	if i >= 0 && len(tokens) > 1 {
+		tokens = tokens[1:]
+	}
+	var data []byte
+	for _, token := range tokens {
+		token = strings.TrimPrefix(strings.ToLower(token), "0x")
+		if len(token) == 1 {
+			token = "0" + token
+		}
+		b, err := hex.DecodeString(token)
+		if err != nil {
+			return nil, false
+		}
+		data = append(data, b...)
+	}
+	return data, len(data) > 0
+}
+
+func parseHexByte(s string) (byte, bool) {
+	if len(s) == 1 {
+		s = "0" + s
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 1 {
+		return 0, false
+	}
+	return b[0], true
+}
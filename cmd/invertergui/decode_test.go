@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const decodeLog = `time="2026-10-19T10:00:00Z" level=debug msg="sendCommand []byte{0x5, 0xff, 0x57, 0x36, 0x4, 0x0, 0x6b}"
+time="2026-10-19T10:00:00Z" level=debug msg="[handleFrame] frame []byte{0xff, 0x57, 0x8e, 0x9c, 0x7f, 0x8f, 0x0, 0x0, 0x6a}"
+time="2026-10-19T10:00:01Z" level=debug msg="[handleFrame] frame []byte{0x20, 0xf3, 0x0, 0xc8, 0x2, 0xc, 0xa1, 0x5, 0x0, 0x0, 0x0, 0x28, 0x0, 0x0, 0x88, 0xb2}"
+05 ff 57 30 0d 00 68
+05 ff 57 85 c8 00 58 06 ff 4c 03 00 00 00 ac
+`
+
+func TestDecodeInput(t *testing.T) {
+	var out bytes.Buffer
+	if err := decodeInput(strings.NewReader(decodeLog), &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		"tx winmon     05 ff 57 36 04 00 6b  checksum ok  read RAM variable info (0x36)\n",
+		"rx winmon     08 ff 57 8e 9c 7f 8f 00 00 6a  checksum ok  RAM variable info (0x8e)\n",
+		"    battery_voltage: 14.410 (scale 0.01, offset 0)\n",
+		"    battery_current: -40.000 (unscaled)\n",
+		"tx winmon     05 ff 57 30 0d 00 68  checksum ok  read RAM variables (0x30)\n    variable: charge_state (0x0d)\n",
+		"    charge_state: 200.000 (unscaled)\n",
+		"rx led        06 ff 4c 03 00 00 00 ac  checksum ok\n    leds: led_absorb=on led_mains=on\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestDecodeBinary(t *testing.T) {
+	var out bytes.Buffer
+	if err := decodeInput(bytes.NewReader([]byte{0x12, 0x02, 0xff, 0x4c, 0xb3, 0x02, 0xff, 0x4c, 0xb4}), &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "skipped 1 bytes outside of frames\n" +
+		"tx led        02 ff 4c b3  checksum ok  request LEDs\n" +
+		"tx led        02 ff 4c b4  checksum invalid\n" +
+		"    error: invalid checksum\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestParseHexLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want []byte
+		ok   bool
+	}{
+		{line: "05 ff 57", want: []byte{0x05, 0xff, 0x57}, ok: true},
+		{line: "0x05, 0xff,0x57", want: []byte{0x05, 0xff, 0x57}, ok: true},
+		{line: "05ff57", want: []byte{0x05, 0xff, 0x57}, ok: true},
+		{line: "00000010  02 ff 4c b3                                       |..L.|", want: []byte{0x02, 0xff, 0x4c, 0xb3}, ok: true},
+		{line: "level=info msg=started"},
+		{line: ""},
+	}
+	for _, tt := range tests {
+		got, ok := parseHexLine(tt.line)
+		if ok != tt.ok || !bytes.Equal(got, tt.want) {
+			t.Errorf("parseHexLine(%q) = %x, %v, want %x, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2core"
+)
+
+// saveEnergy saves the energy counters every interval and once more when ctx
+// is cancelled.
+func saveEnergy(ctx context.Context, energy *mk2core.EnergyIntegrator, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := energy.Save(); err != nil {
+				log.Errorf("Could not save energy counters: %v", err)
+			}
+		case <-ctx.Done():
+			if err := energy.Save(); err != nil {
+				log.Errorf("Could not save energy counters: %v", err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2core"
+	"github.com/diebietse/invertergui/mk2driver"
+)
+
+func TestSaveEnergy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "energy.json")
+	energy, err := mk2core.NewEnergyIntegrator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, at := range []time.Time{now, now.Add(time.Second)} {
+		energy.Process(&mk2driver.Mk2Info{
+			Valid:       true,
+			Derived:     mk2driver.DerivedValues{GridPower: 3600, RealPower: true},
+			Timestamp:   at,
+			SampleTimes: mk2driver.SampleTimes{AC: at},
+		})
+	}
+
+	// The counters are saved when the context is cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	saveEnergy(ctx, energy, time.Hour)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := mk2core.NewEnergyIntegrator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Total() != energy.Total() {
+		t.Errorf("got %+v, want %+v", restored.Total(), energy.Total())
+	}
+}
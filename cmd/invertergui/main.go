@@ -31,12 +31,19 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/diebietse/invertergui/ess"
+	"github.com/diebietse/invertergui/history"
 	"github.com/diebietse/invertergui/mk2core"
 	"github.com/diebietse/invertergui/mk2driver"
 	"github.com/diebietse/invertergui/plugins/cli"
@@ -52,6 +59,9 @@ import (
 
 var log = logrus.WithField("ctx", "inverter-gui")
 
+// Time given to open HTTP requests to complete on shutdown.
+const shutdownTimeout = 5 * time.Second
+
 func main() {
 	conf, err := parseConfig()
 	if err != nil {
@@ -64,30 +74,136 @@ func main() {
 	}
 	logrus.SetLevel(logLevel)
 
+	if conf.command != nil {
+		if err := conf.command.Execute(nil); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	mk2, err := getMk2Device(conf.Data.Source, conf.Data.Host, conf.Data.Device)
 	if err != nil {
 		log.Fatalf("Could not open data source: %v", err)
 	}
-	defer mk2.Close()
 
-	core := mk2core.NewCore(mk2)
+	vars, err := assistantVars(conf)
+	if err != nil {
+		log.Fatalf("Invalid assistant variables: %v", err)
+	}
+	if len(vars) > 0 {
+		monitor, ok := mk2.(mk2driver.AssistantMonitor)
+		if !ok {
+			log.Fatalf("Data source %s can not read assistant variables", conf.Data.Source)
+		}
+		monitor.MonitorAssistantVars(vars)
+	}
+
+	var source mk2driver.Mk2 = mk2
+	var essControl *ess.Controller
+	if conf.ESS.Enabled {
+		essConf, err := essConfig(conf)
+		if err != nil {
+			log.Fatalf("Invalid ESS configuration: %v", err)
+		}
+		writer, ok := mk2.(mk2driver.AssistantWriter)
+		if !ok {
+			log.Fatalf("Data source %s can not write assistant variables", conf.Data.Source)
+		}
+		essControl, err = ess.New(mk2, writer, essConf)
+		if err != nil {
+			log.Fatalf("Invalid ESS configuration: %v", err)
+		}
+		source = essControl
+	}
+	defer source.Close()
+
+	subs, err := parseSubscriptions(conf.Subscriptions)
+	if err != nil {
+		log.Fatalf("Invalid subscription configuration: %v", err)
+	}
+	if err := parseFilters(subs, conf.Filters); err != nil {
+		log.Fatalf("Invalid filter configuration: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if conf.Energy.SaveInterval <= 0 {
+		log.Fatalf("Invalid energy save interval: %v", conf.Energy.SaveInterval)
+	}
+	energy, err := mk2core.NewEnergyIntegrator(conf.Energy.File)
+	if err != nil {
+		log.Fatalf("Could not restore energy counters: %v", err)
+	}
+	saved := make(chan struct{})
+	go func() {
+		defer close(saved)
+		saveEnergy(ctx, energy, conf.Energy.SaveInterval)
+	}()
+	core := mk2core.NewCoreContext(ctx, source, energy)
+	defer core.Close()
+	http.Handle("/api/status", webui.NewStatusHandler(core))
+	http.Handle("/api/latest", webui.NewLatestHandler(core))
+	http.Handle("/api/energy", webui.NewEnergyHandler(energy))
 
 	if conf.Cli.Enabled {
-		cli.NewCli(core.NewSubscription())
+		cli.NewCli(core.Subscribe(ctx, subs["cli"]))
 	}
 
 	// Webgui
-	gui := webui.NewWebGui(core.NewSubscription())
+	gui := webui.NewWebGui(core.Subscribe(ctx, subs["webui"]))
 	http.Handle("/", static.New())
 	http.Handle("/ws", http.HandlerFunc(gui.ServeHub))
+	switches, _ := mk2.(mk2driver.SwitchController)
+	if switches != nil && conf.Control.Token != "" {
+		http.Handle("/api/switch", webui.NewSwitchHandler(switches, conf.Control.Token))
+	}
+	if essControl != nil {
+		http.Handle("/api/ess", webui.NewESSHandler(essControl, conf.Control.Token))
+	}
+	if conf.Raw.Token != "" {
+		transactor, ok := mk2.(mk2driver.RawTransactor)
+		if !ok {
+			log.Fatalf("Data source %s can not send raw commands", conf.Data.Source)
+		}
+		http.Handle("/api/raw", webui.NewRawHandler(transactor, conf.Raw.Token))
+	}
+
+	// History
+	var store *history.Store
+	if conf.Store.Dir != "" {
+		store, err = history.OpenStore(core.Subscribe(ctx, subs["store"]), history.StoreConfig{
+			Dir:          conf.Store.Dir,
+			RawRetention: conf.Store.RawRetention,
+			Step:         conf.Store.Step,
+			Retention:    conf.Store.Retention,
+		})
+		if err != nil {
+			log.Fatalf("Could not open store: %v", err)
+		}
+		http.Handle("/api/history", webui.NewHistoryHandler(store))
+	} else {
+		hist, err := history.New(core.Subscribe(ctx, subs["history"]), history.Config{
+			RawWindow:     conf.History.Window,
+			MaxRawSamples: conf.History.MaxSamples,
+		})
+		if err != nil {
+			log.Fatalf("Invalid history configuration: %v", err)
+		}
+		http.Handle("/api/history", webui.NewHistoryHandler(hist))
+	}
 
 	// Munin
-	mu := munin.NewMunin(core.NewSubscription())
+	mu := munin.NewMunin(core.Subscribe(ctx, subs["munin"]))
 	http.Handle("/munin", http.HandlerFunc(mu.ServeMuninHTTP))
 	http.Handle("/muninconfig", http.HandlerFunc(mu.ServeMuninConfigHTTP))
 
 	// Prometheus
-	prometheus.NewPrometheus(core.NewSubscription())
+	prometheus.NewPrometheus(core.Subscribe(ctx, subs["prometheus"]))
+	prometheus.NewSubscriptionStats(core)
+	prometheus.NewEnergy(energy)
+	if stats, ok := mk2.(mk2driver.StatsSource); ok {
+		prometheus.NewDriverStats(stats)
+	}
 	http.Handle("/metrics", promhttp.Handler())
 
 	// MQTT
@@ -98,16 +214,39 @@ func main() {
 			ClientID: conf.MQTT.ClientID,
 			Username: conf.MQTT.Username,
 			Password: conf.MQTT.Password,
+
+			CommandTopic: conf.MQTT.CommandTopic,
+		}
+		controls := mqttclient.Controls{Switches: switches}
+		if essControl != nil {
+			controls.ESS = essControl
 		}
-		if err := mqttclient.New(core.NewSubscription(), mqttConf); err != nil {
+		if err := mqttclient.New(core.Subscribe(ctx, subs["mqtt"]), controls, mqttConf); err != nil {
 			log.Fatalf("Could not setup MQTT client: %v", err)
 		}
 	}
 	log.Infof("Invertergui web server starting on: %v", conf.Address)
 
-	if err := http.ListenAndServe(conf.Address, nil); err != nil {
+	server := &http.Server{Addr: conf.Address}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		log.Info("Shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Errorf("Could not shut down web server: %v", err)
+		}
+	}()
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal(err)
 	}
+	<-stopped
+	<-saved
+	if store != nil {
+		<-store.Done()
+	}
 }
 
 func getMk2Device(source, ip, dev string) (mk2driver.Mk2, error) {
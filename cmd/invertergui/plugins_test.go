@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/diebietse/invertergui/history"
+	"github.com/diebietse/invertergui/mk2core"
+	"github.com/diebietse/invertergui/mk2driver"
+	"github.com/diebietse/invertergui/plugins/cli"
+	"github.com/diebietse/invertergui/plugins/mqttclient"
+	"github.com/diebietse/invertergui/plugins/munin"
+	"github.com/diebietse/invertergui/plugins/prometheus"
+	"github.com/diebietse/invertergui/plugins/webui"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gorilla/websocket"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeMQTTClient counts the published messages. Only the methods used by
+// mqttclient.Publish are implemented.
+type fakeMQTTClient struct {
+	mqtt.Client
+	lock      sync.Mutex
+	published int
+}
+
+func (f *fakeMQTTClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.published++
+	return sentToken{}
+}
+
+func (f *fakeMQTTClient) Disconnect(quiesce uint) {}
+
+type sentToken struct {
+	mqtt.Token
+}
+
+func (sentToken) Wait() bool {
+	return true
+}
+
+func (sentToken) Error() error {
+	return nil
+}
+
+// Runs all plugins against a fast mock source while clients use their HTTP
+// endpoints, run with -race.
+func TestPluginsRace(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(os.Stderr)
+	// The plugins register their metrics with the default registry.
+	registry := prom.NewRegistry()
+	defaultRegisterer := prom.DefaultRegisterer
+	prom.DefaultRegisterer = registry
+	defer func() { prom.DefaultRegisterer = defaultRegisterer }()
+
+	energy, err := mk2core.NewEnergyIntegrator("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	core := mk2core.NewCore(mk2driver.NewMk2MockInterval(100*time.Microsecond), energy)
+	defer core.Close()
+	subs, err := parseSubscriptions(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	cli.NewCli(core.Subscribe(ctx, subs["cli"]))
+	gui := webui.NewWebGui(core.Subscribe(ctx, subs["webui"]))
+	mu := munin.NewMunin(core.Subscribe(ctx, subs["munin"]))
+	prometheus.NewPrometheus(core.Subscribe(ctx, subs["prometheus"]))
+	prometheus.NewSubscriptionStats(core)
+	prometheus.NewEnergy(energy)
+	hist, err := history.New(core.Subscribe(ctx, subs["history"]), history.Config{RawWindow: time.Minute, MaxRawSamples: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := history.OpenStore(core.Subscribe(ctx, subs["store"]), history.StoreConfig{
+		Dir:          t.TempDir(),
+		RawRetention: time.Hour,
+		Step:         time.Minute,
+		Retention:    time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &fakeMQTTClient{}
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		mqttclient.Publish(core.Subscribe(ctx, subs["mqtt"]), client, "invertergui/updates")
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/ws", http.HandlerFunc(gui.ServeHub))
+	mux.Handle("/munin", http.HandlerFunc(mu.ServeMuninHTTP))
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.Handle("/api/latest", webui.NewLatestHandler(core))
+	mux.Handle("/api/status", webui.NewStatusHandler(core))
+	mux.Handle("/api/energy", webui.NewEnergyHandler(energy))
+	mux.Handle("/api/history", webui.NewHistoryHandler(hist))
+	mux.Handle("/api/store", webui.NewHistoryHandler(store))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var clients sync.WaitGroup
+	stop := time.After(300 * time.Millisecond)
+	done := make(chan struct{})
+	for _, path := range []string{"/munin", "/metrics", "/api/latest", "/api/status", "/api/energy", "/api/history?field=bat_voltage&field=grid_power_va", "/api/store?field=bat_voltage"} {
+		clients.Add(1)
+		go func(path string) {
+			defer clients.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				resp, err := http.Get(server.URL + path)
+				if err != nil {
+					t.Errorf("GET %s: %v", path, err)
+					return
+				}
+				_, _ = io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		}(path)
+	}
+	clients.Add(1)
+	go func() {
+		defer clients.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+			if err != nil {
+				t.Errorf("websocket: %v", err)
+				return
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			for i := 0; i < 2; i++ {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					t.Errorf("websocket: %v", err)
+					break
+				}
+			}
+			conn.Close()
+		}
+	}()
+
+	<-stop
+	close(done)
+	clients.Wait()
+	core.Close()
+	<-published
+	<-store.Done()
+	gui.Stop()
+
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	if client.published == 0 {
+		t.Error("no updates published to MQTT")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2driver"
+)
+
+// Time to wait for the reply of the web server, which waits up to 10 seconds
+// for the device.
+const rawRequestTimeout = 15 * time.Second
+
+type rawCommand struct {
+	URL  string `long:"url" default:"http://localhost:8080" description:"Address of the running invertergui web server."`
+	Args struct {
+		Command []string `positional-arg-name:"hex" required:"1" description:"Command bytes after the 0xff frame header, e.g. 57 30 0d 00."`
+	} `positional-args:"yes" required:"yes"`
+
+	conf *config
+}
+
+type rawReply struct {
+	Command string `json:"command"`
+	Reply   string `json:"reply"`
+}
+
+func (c *rawCommand) Execute(args []string) error {
+	if c.conf.Raw.Token == "" {
+		return errors.New("raw.token must be set to the token of the web server")
+	}
+	body, err := json.Marshal(map[string]string{"command": strings.Join(c.Args.Command, " ")})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(c.URL, "/")+"/api/raw", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.conf.Raw.Token)
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: rawRequestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("raw command failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var reply rawReply
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("invalid reply: %w", err)
+	}
+	cmd, err := hex.DecodeString(reply.Command)
+	if err != nil {
+		return fmt.Errorf("invalid reply: %w", err)
+	}
+	frame, err := hex.DecodeString(reply.Reply)
+	if err != nil {
+		return fmt.Errorf("invalid reply: %w", err)
+	}
+	printRawTransaction(os.Stdout, cmd, frame)
+	return nil
+}
+
+// Writes the annotated command and reply frames to w. The command is decoded
+// first, as replies are decoded according to the command they answer.
+func printRawTransaction(w io.Writer, cmd, reply []byte) {
+	d := &frameDecoder{decoder: mk2driver.NewDecoder(), w: w}
+	d.print(d.decoder.Decode(mk2driver.DirectionSent, mk2driver.CommandFrame(cmd)))
+	d.print(d.decoder.Decode(mk2driver.DirectionReceived, reply))
+}
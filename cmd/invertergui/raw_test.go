@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/diebietse/invertergui/plugins/webui"
+)
+
+type fakeTransactor struct {
+	cmd []byte
+}
+
+func (f *fakeTransactor) RawTransaction(cmd []byte) ([]byte, error) {
+	f.cmd = cmd
+	return []byte{0xff, 0x57, 0x85, 0x10, 0x00, 0x10}, nil
+}
+
+func TestPrintRawTransaction(t *testing.T) {
+	var b strings.Builder
+	printRawTransaction(&b, []byte{0x57, 0x30, 0x0d, 0x00}, []byte{0xff, 0x57, 0x85, 0x10, 0x00, 0x10})
+	want := strings.Join([]string{
+		"tx winmon     05 ff 57 30 0d 00 68  checksum ok  read RAM variables (0x30)",
+		"    variable: charge_state (0x0d)",
+		"rx winmon     05 ff 57 85 10 00 10  checksum ok  RAM variables (0x85)",
+		"    charge_state: 16.000 (unscaled)",
+		"",
+	}, "\n")
+	if got := b.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	b.Reset()
+	printRawTransaction(&b, []byte{0x57, 0x30, 0x0d, 0x00}, []byte{0xff, 0x57, 0x85, 0x10, 0x00, 0x11})
+	if got := b.String(); !strings.Contains(got, "error: invalid checksum") {
+		t.Errorf("invalid checksum not reported:\n%s", got)
+	}
+}
+
+func TestRawCommandNeedsToken(t *testing.T) {
+	conf := &config{}
+	cmd := &rawCommand{URL: "http://localhost:0", conf: conf}
+	cmd.Args.Command = []string{"57", "30", "0d", "00"}
+	if err := cmd.Execute(nil); err == nil {
+		t.Fatal("expected error without raw.token, got nil")
+	}
+}
+
+func TestRawCommand(t *testing.T) {
+	transactor := &fakeTransactor{}
+	server := httptest.NewServer(webui.NewRawHandler(transactor, "secret"))
+	defer server.Close()
+
+	conf := &config{}
+	conf.Raw.Token = "secret"
+	cmd := &rawCommand{URL: server.URL, conf: conf}
+	cmd.Args.Command = []string{"57", "300d00"}
+	if err := cmd.Execute(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(transactor.cmd) != "\x57\x30\x0d\x00" {
+		t.Errorf("got command %x, want 57300d00", transactor.cmd)
+	}
+
+	conf.Raw.Token = "guess"
+	if err := cmd.Execute(nil); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("got error %v, want unauthorized", err)
+	}
+}
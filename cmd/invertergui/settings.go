@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2driver"
+)
+
+// Version of the settings file format written by settings dump.
+const settingsFileVersion = 1
+
+type settingsCommand struct {
+	Dump    settingsDumpCommand    `command:"dump" description:"Read all settings from the device and write them to a file."`
+	Restore settingsRestoreCommand `command:"restore" description:"Write the settings of a dump file to the device."`
+}
+
+type settingsDumpCommand struct {
+	File string `long:"file" short:"f" default:"-" description:"File to write the settings to, - for stdout."`
+
+	conf *config
+}
+
+type settingsRestoreCommand struct {
+	File string `long:"file" short:"f" required:"true" description:"Settings file written by settings dump."`
+	Yes  bool   `long:"yes" short:"y" description:"Apply the changes without asking for confirmation."`
+
+	conf *config
+}
+
+// settingsFile is the settings dump format.
+type settingsFile struct {
+	Version  int            `json:"version"`
+	Created  time.Time      `json:"created"`
+	Device   settingsDevice `json:"device"`
+	Settings []settingEntry `json:"settings"`
+}
+
+type settingsDevice struct {
+	ProductCode      uint32 `json:"product_code"`
+	FirmwareVersion  uint32 `json:"firmware_version"`
+	InterfaceVersion uint32 `json:"interface_version"`
+}
+
+// settingEntry is a raw setting value with the metadata the device reported
+// for it.
+type settingEntry struct {
+	ID          uint16 `json:"id"`
+	Value       uint16 `json:"value"`
+	Scale       int16  `json:"scale"`
+	Offset      int16  `json:"offset"`
+	Default     uint16 `json:"default"`
+	Min         uint16 `json:"min"`
+	Max         uint16 `json:"max"`
+	AccessLevel byte   `json:"access_level"`
+}
+
+// settingChange is a setting that differs between the device and a file.
+type settingChange struct {
+	Info    mk2driver.SettingInfo
+	Current uint16
+	Value   uint16
+}
+
+func (c settingChange) String() string {
+	return fmt.Sprintf("setting %d: %d -> %d", c.Info.ID, c.Current, c.Value)
+}
+
+func (c *settingsDumpCommand) Execute(args []string) error {
+	mk2, err := openDevice(c.conf)
+	if err != nil {
+		return err
+	}
+	defer mk2.Close()
+	ctl, ok := mk2.(mk2driver.SettingsController)
+	if !ok {
+		return fmt.Errorf("data source %s can not read settings", c.conf.Data.Source)
+	}
+
+	file := settingsFile{
+		Version: settingsFileVersion,
+		Created: time.Now().UTC(),
+	}
+	if source, ok := mk2.(mk2driver.DeviceInfoSource); ok {
+		info := source.DeviceInfo()
+		file.Device = settingsDevice{
+			ProductCode:      info.ProductCode,
+			FirmwareVersion:  info.FirmwareVersion,
+			InterfaceVersion: info.InterfaceVersion,
+		}
+	}
+	file.Settings, err = dumpSettings(ctl)
+	if err != nil {
+		return err
+	}
+	log.Infof("Read %d settings", len(file.Settings))
+
+	out := io.Writer(os.Stdout)
+	if c.File != "-" {
+		f, err := os.Create(c.File)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(file)
+}
+
+func (c *settingsRestoreCommand) Execute(args []string) error {
+	file, err := readSettingsFile(c.File)
+	if err != nil {
+		return err
+	}
+	mk2, err := openDevice(c.conf)
+	if err != nil {
+		return err
+	}
+	defer mk2.Close()
+	ctl, ok := mk2.(mk2driver.SettingsController)
+	if !ok {
+		return fmt.Errorf("data source %s can not write settings", c.conf.Data.Source)
+	}
+	if source, ok := mk2.(mk2driver.DeviceInfoSource); ok {
+		info := source.DeviceInfo()
+		if file.Device.FirmwareVersion != 0 && info.FirmwareVersion != file.Device.FirmwareVersion {
+			log.Warnf("Settings file is from firmware %d, the device runs %d", file.Device.FirmwareVersion, info.FirmwareVersion)
+		}
+	}
+
+	changes, err := diffSettings(ctl, file.Settings)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Println("The device settings match the file")
+		return nil
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	if !c.Yes && !confirm(os.Stdin, os.Stdout, fmt.Sprintf("Write %d settings?", len(changes))) {
+		return errors.New("restore cancelled")
+	}
+	return applySettings(ctl, changes)
+}
+
+func readSettingsFile(path string) (*settingsFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := &settingsFile{}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("invalid settings file %s: %w", path, err)
+	}
+	if file.Version != settingsFileVersion {
+		return nil, fmt.Errorf("unsupported settings file version %d, expected %d", file.Version, settingsFileVersion)
+	}
+	return file, nil
+}
+
+// Reads the settings from ID 0 up to the first one the device does not
+// support.
+func dumpSettings(ctl mk2driver.SettingsController) ([]settingEntry, error) {
+	var settings []settingEntry
+	for id := 0; id <= math.MaxUint16; id++ {
+		info, err := ctl.ReadSettingInfo(uint16(id))
+		if errors.Is(err, mk2driver.ErrNotSupported) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read setting %d info: %w", id, err)
+		}
+		value, err := ctl.ReadSetting(uint16(id))
+		if err != nil {
+			return nil, fmt.Errorf("could not read setting %d: %w", id, err)
+		}
+		log.Debugf("Setting %d: %d", id, value)
+		settings = append(settings, settingEntry{
+			ID:          info.ID,
+			Value:       value,
+			Scale:       info.Scale,
+			Offset:      info.Offset,
+			Default:     info.Default,
+			Min:         info.Min,
+			Max:         info.Max,
+			AccessLevel: info.AccessLevel,
+		})
+	}
+	return settings, nil
+}
+
+// Returns the settings that differ between the device and settings. An error
+// is returned, before anything is written, if a value is outside the minimum
+// and maximum the device reports for the setting.
+func diffSettings(ctl mk2driver.SettingsController, settings []settingEntry) ([]settingChange, error) {
+	var changes []settingChange
+	var outOfRange []string
+	for _, s := range settings {
+		info, err := ctl.ReadSettingInfo(s.ID)
+		if err != nil {
+			return nil, fmt.Errorf("could not read setting %d info: %w", s.ID, err)
+		}
+		current, err := ctl.ReadSetting(s.ID)
+		if err != nil {
+			return nil, fmt.Errorf("could not read setting %d: %w", s.ID, err)
+		}
+		if current == s.Value {
+			continue
+		}
+		if !info.InRange(s.Value) {
+			outOfRange = append(outOfRange, fmt.Sprintf("setting %d value %d outside %d to %d", s.ID, s.Value, info.Min, info.Max))
+			continue
+		}
+		changes = append(changes, settingChange{Info: info, Current: current, Value: s.Value})
+	}
+	if len(outOfRange) > 0 {
+		return nil, fmt.Errorf("%w: %s", mk2driver.ErrOutOfRange, strings.Join(outOfRange, ", "))
+	}
+	return changes, nil
+}
+
+func applySettings(ctl mk2driver.SettingsController, changes []settingChange) error {
+	for _, change := range changes {
+		if err := ctl.WriteSetting(change.Info.ID, change.Value); err != nil {
+			return fmt.Errorf("could not write setting %d: %w", change.Info.ID, err)
+		}
+		log.Infof("Wrote %v", change)
+	}
+	return nil
+}
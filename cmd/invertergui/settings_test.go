@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/diebietse/invertergui/mk2driver"
+)
+
+type fakeSettings struct {
+	infos   []mk2driver.SettingInfo
+	values  []uint16
+	written map[uint16]uint16
+}
+
+func newFakeSettings(values ...uint16) *fakeSettings {
+	f := &fakeSettings{values: values, written: map[uint16]uint16{}}
+	for id := range values {
+		f.infos = append(f.infos, mk2driver.SettingInfo{ID: uint16(id), Scale: 1, Min: 0, Max: 100})
+	}
+	return f
+}
+
+func (f *fakeSettings) ReadSettingInfo(id uint16) (mk2driver.SettingInfo, error) {
+	if int(id) >= len(f.infos) {
+		return mk2driver.SettingInfo{}, mk2driver.ErrNotSupported
+	}
+	return f.infos[id], nil
+}
+
+func (f *fakeSettings) ReadSetting(id uint16) (uint16, error) {
+	if int(id) >= len(f.values) {
+		return 0, mk2driver.ErrNotSupported
+	}
+	return f.values[id], nil
+}
+
+func (f *fakeSettings) WriteSetting(id uint16, value uint16) error {
+	f.written[id] = value
+	f.values[id] = value
+	return nil
+}
+
+func TestDumpSettings(t *testing.T) {
+	settings, err := dumpSettings(newFakeSettings(10, 20, 30))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(settings) != 3 {
+		t.Fatalf("got %d settings, want 3", len(settings))
+	}
+	for i, s := range settings {
+		if int(s.ID) != i || s.Value != uint16(10*(i+1)) || s.Max != 100 {
+			t.Errorf("unexpected setting %+v", s)
+		}
+	}
+}
+
+func TestDiffAndApplySettings(t *testing.T) {
+	device := newFakeSettings(10, 20, 30)
+	file := []settingEntry{{ID: 0, Value: 10}, {ID: 1, Value: 25}, {ID: 2, Value: 35}}
+
+	changes, err := diffSettings(device, file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(changes) != 2 || changes[0].String() != "setting 1: 20 -> 25" || changes[1].String() != "setting 2: 30 -> 35" {
+		t.Fatalf("unexpected changes %v", changes)
+	}
+	if err := applySettings(device, changes); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(device.written) != 2 || device.written[1] != 25 || device.written[2] != 35 {
+		t.Errorf("unexpected writes %v", device.written)
+	}
+}
+
+func TestDiffSettingsOutOfRange(t *testing.T) {
+	device := newFakeSettings(10, 20)
+	_, err := diffSettings(device, []settingEntry{{ID: 0, Value: 50}, {ID: 1, Value: 101}})
+	if !errors.Is(err, mk2driver.ErrOutOfRange) {
+		t.Fatalf("got error %v, want ErrOutOfRange", err)
+	}
+	if !strings.Contains(err.Error(), "setting 1 value 101") {
+		t.Errorf("error %q does not name the setting", err)
+	}
+	if len(device.written) != 0 {
+		t.Errorf("unexpected writes %v", device.written)
+	}
+}
+
+func TestReadSettingsFileVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+	if err := os.WriteFile(path, []byte(`{"version": 2, "settings": []}`), 0o600); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+	if _, err := readSettingsFile(path); err == nil {
+		t.Fatal("expected error for unsupported version, got nil")
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	tests := []struct {
+		answer string
+		want   bool
+	}{
+		{"y\n", true},
+		{"Yes\n", true},
+		{"n\n", false},
+		{"\n", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		if got := confirm(strings.NewReader(tt.answer), &out, "Write?"); got != tt.want {
+			t.Errorf("confirm(%q) = %v, want %v", tt.answer, got, tt.want)
+		}
+		if out.String() != "Write? [y/N] " {
+			t.Errorf("unexpected prompt %q", out.String())
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Time to wait for the web server, which replies without waiting for the
+// device.
+const stateRequestTimeout = 5 * time.Second
+
+type stateCommand struct {
+	URL string `long:"url" default:"http://localhost:8080" description:"Address of the running invertergui web server."`
+}
+
+func (c *stateCommand) Execute(args []string) error {
+	state, err := fetchState(c.URL)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(state)
+	return err
+}
+
+// Returns the latest update of the web server at url as indented JSON.
+func fetchState(url string) ([]byte, error) {
+	client := &http.Client{Timeout: stateRequestTimeout}
+	resp, err := client.Get(strings.TrimRight(url, "/") + "/api/latest")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("state request failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var state bytes.Buffer
+	if err := json.Indent(&state, body, "", "  "); err != nil {
+		return nil, fmt.Errorf("invalid reply: %w", err)
+	}
+	return state.Bytes(), nil
+}
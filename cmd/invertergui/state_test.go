@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2driver"
+	"github.com/diebietse/invertergui/plugins/webui"
+)
+
+type fakeLatest struct {
+	info *mk2driver.Mk2Info
+}
+
+func (f *fakeLatest) Latest() (*mk2driver.Mk2Info, time.Duration) {
+	return f.info, time.Second
+}
+
+func TestFetchState(t *testing.T) {
+	source := &fakeLatest{}
+	server := httptest.NewServer(webui.NewLatestHandler(source))
+	defer server.Close()
+
+	if _, err := fetchState(server.URL); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("got error %v, want service unavailable", err)
+	}
+
+	source.info = &mk2driver.Mk2Info{Valid: true, BatVoltage: 12.5}
+	state, err := fetchState(server.URL + "/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"\n  \"BatVoltage\": 12.5,\n", "\n  \"Age\": 1\n"} {
+		if !strings.Contains(string(state), want) {
+			t.Errorf("%q missing from:\n%s", want, state)
+		}
+	}
+}
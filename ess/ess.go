@@ -0,0 +1,194 @@
+// Package ess controls the grid power setpoint of the ESS assistant when it
+// runs without a GX device.
+package ess
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2driver"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logrus.WithField("ctx", "inverter-gui-ess")
+
+// Config sets the limits of the setpoint control.
+type Config struct {
+	// Assistant RAM variable holding the grid power setpoint.
+	SetpointVar mk2driver.AssistantVar
+	// Limits of the target setpoint in W.
+	Min float64
+	Max float64
+	// Setpoint applied before a target was set and when the watchdog trips.
+	Safe float64
+	// Time between setpoint writes, which limits the rate at which target
+	// changes reach the device.
+	Interval time.Duration
+	// Time after the last target update after which the safe setpoint is
+	// applied.
+	Watchdog time.Duration
+}
+
+func (c Config) validate() error {
+	if c.Min > c.Max {
+		return fmt.Errorf("minimum setpoint %.0f W above maximum %.0f W", c.Min, c.Max)
+	}
+	if c.Min < math.MinInt16 || c.Max > math.MaxInt16 {
+		return fmt.Errorf("setpoint limits must be within %d to %d W", math.MinInt16, math.MaxInt16)
+	}
+	if c.Safe < c.Min || c.Safe > c.Max {
+		return fmt.Errorf("safe setpoint %.0f W outside %.0f to %.0f W", c.Safe, c.Min, c.Max)
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("setpoint interval must be positive")
+	}
+	if c.Watchdog < c.Interval {
+		return fmt.Errorf("watchdog %v shorter than the setpoint interval %v", c.Watchdog, c.Interval)
+	}
+	return nil
+}
+
+// Controller writes the target setpoint to the device every Interval and
+// reports its state in Mk2Info.ESS of the updates passed through it.
+type Controller struct {
+	mk2driver.Mk2
+	writer mk2driver.AssistantWriter
+	config Config
+	c      chan *mk2driver.Mk2Info
+	stop   chan struct{}
+	wg     sync.WaitGroup
+
+	lock      sync.Mutex
+	state     mk2driver.ESSState
+	hasTarget bool
+	updated   time.Time
+}
+
+// New starts controlling the setpoint through writer and passes the updates
+// of source on with the control state added.
+func New(source mk2driver.Mk2, writer mk2driver.AssistantWriter, config Config) (*Controller, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	c := &Controller{
+		Mk2:    source,
+		writer: writer,
+		config: config,
+		c:      make(chan *mk2driver.Mk2Info),
+		stop:   make(chan struct{}),
+		state: mk2driver.ESSState{
+			Enabled: true,
+			Target:  config.Safe,
+		},
+	}
+	c.wg.Add(2)
+	go c.forward()
+	go c.run()
+	return c, nil
+}
+
+func (c *Controller) C() chan *mk2driver.Mk2Info {
+	return c.c
+}
+
+// Close stops the control and closes the source.
+func (c *Controller) Close() {
+	close(c.stop)
+	c.wg.Wait()
+	c.Mk2.Close()
+}
+
+// State returns the current control state.
+func (c *Controller) State() mk2driver.ESSState {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.state
+}
+
+// SetTarget sets the setpoint written from the next interval on and resets
+// the watchdog. It returns ErrOutOfRange if target is outside the limits.
+func (c *Controller) SetTarget(target float64) (mk2driver.ESSState, error) {
+	if math.IsNaN(target) || target < c.config.Min || target > c.config.Max {
+		return c.State(), fmt.Errorf("%w: setpoint %v W outside %.0f to %.0f W",
+			mk2driver.ErrOutOfRange, target, c.config.Min, c.config.Max)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.state.Target = target
+	c.hasTarget = true
+	c.updated = time.Now()
+	if c.state.WatchdogTripped {
+		log.Info("Setpoint target updated, leaving safe setpoint")
+		c.state.WatchdogTripped = false
+	}
+	return c.state, nil
+}
+
+func (c *Controller) forward() {
+	defer c.wg.Done()
+	for {
+		select {
+		case e, ok := <-c.Mk2.C():
+			if !ok {
+				return
+			}
+			e.ESS = c.State()
+			select {
+			case c.c <- e:
+			case <-c.stop:
+				return
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *Controller) run() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.config.Interval)
+	defer ticker.Stop()
+	c.update(time.Now())
+	for {
+		select {
+		case now := <-ticker.C:
+			c.update(now)
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Writes the setpoint for time now, the safe setpoint if the target was not
+// set or not updated within the watchdog time.
+func (c *Controller) update(now time.Time) {
+	c.lock.Lock()
+	setpoint := c.state.Target
+	if !c.hasTarget {
+		setpoint = c.config.Safe
+	} else if now.Sub(c.updated) > c.config.Watchdog {
+		if !c.state.WatchdogTripped {
+			log.Warnf("Setpoint target not updated for %v, applying safe setpoint %.0f W", c.config.Watchdog, c.config.Safe)
+			c.state.WatchdogTripped = true
+		}
+		setpoint = c.config.Safe
+	}
+	c.lock.Unlock()
+
+	// The device takes whole watts, the applied setpoint is the written one.
+	written := int16(math.Round(setpoint))
+	err := c.writer.WriteAssistantVar(c.config.SetpointVar, written)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err != nil {
+		log.Errorf("Could not write setpoint: %v", err)
+		c.state.Error = err.Error()
+		return
+	}
+	c.state.Applied = float64(written)
+	c.state.Timestamp = now
+	c.state.Error = ""
+}
@@ -0,0 +1,121 @@
+package ess
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2driver"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeWriter struct {
+	lock   sync.Mutex
+	values []int16
+	err    error
+}
+
+func (f *fakeWriter) WriteAssistantVar(_ mk2driver.AssistantVar, value int16) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.values = append(f.values, value)
+	return f.err
+}
+
+var testConfig = Config{
+	SetpointVar: mk2driver.AssistantVar{Name: "setpoint", AssistantID: mk2driver.AssistantESS},
+	Min:         -2000,
+	Max:         1000,
+	Safe:        50,
+	Interval:    time.Hour,
+	Watchdog:    2 * time.Hour,
+}
+
+// Returns a controller that is not running, updates are done by the tests.
+func newTestController(writer *fakeWriter) *Controller {
+	return &Controller{
+		writer: writer,
+		config: testConfig,
+		state:  mk2driver.ESSState{Enabled: true, Target: testConfig.Safe},
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	assert.NoError(t, testConfig.validate())
+
+	invalid := map[string]func(c *Config){
+		"min above max":     func(c *Config) { c.Min = 2000 },
+		"safe out of range": func(c *Config) { c.Safe = -3000 },
+		"beyond int16":      func(c *Config) { c.Max = 40000 },
+		"no interval":       func(c *Config) { c.Interval = 0 },
+		"short watchdog":    func(c *Config) { c.Watchdog = time.Minute },
+	}
+	for name, change := range invalid {
+		t.Run(name, func(t *testing.T) {
+			c := testConfig
+			change(&c)
+			assert.Error(t, c.validate())
+		})
+	}
+}
+
+func TestSetTarget(t *testing.T) {
+	writer := &fakeWriter{}
+	c := newTestController(writer)
+
+	start := time.Now()
+	c.update(start)
+	assert.Equal(t, []int16{50}, writer.values, "Safe setpoint not applied before a target was set")
+
+	_, err := c.SetTarget(1500)
+	assert.True(t, errors.Is(err, mk2driver.ErrOutOfRange), "Unexpected error %v", err)
+	state, err := c.SetTarget(-1200.4)
+	assert.NoError(t, err)
+	assert.Equal(t, -1200.4, state.Target)
+
+	c.update(time.Now())
+	assert.Equal(t, []int16{50, -1200}, writer.values)
+	state = c.State()
+	assert.Equal(t, -1200.0, state.Applied, "Applied setpoint not the written one")
+	assert.False(t, state.WatchdogTripped)
+}
+
+func TestWatchdog(t *testing.T) {
+	writer := &fakeWriter{}
+	c := newTestController(writer)
+	_, err := c.SetTarget(-1000)
+	assert.NoError(t, err)
+
+	c.update(time.Now().Add(testConfig.Watchdog + time.Second))
+	assert.Equal(t, []int16{50}, writer.values)
+	state := c.State()
+	assert.True(t, state.WatchdogTripped)
+	assert.Equal(t, testConfig.Safe, state.Applied)
+
+	_, err = c.SetTarget(-1000)
+	assert.NoError(t, err)
+	assert.False(t, c.State().WatchdogTripped)
+	c.update(time.Now())
+	assert.Equal(t, []int16{50, -1000}, writer.values)
+}
+
+func TestWriteError(t *testing.T) {
+	writer := &fakeWriter{err: mk2driver.ErrTimeout}
+	c := newTestController(writer)
+	c.update(time.Now())
+	state := c.State()
+	assert.NotEmpty(t, state.Error)
+	assert.True(t, state.Timestamp.IsZero())
+}
+
+func TestForward(t *testing.T) {
+	source := mk2driver.NewMk2Mock()
+	c, err := New(source, &fakeWriter{}, testConfig)
+	assert.NoError(t, err)
+	defer c.Close()
+
+	info := <-c.C()
+	assert.True(t, info.ESS.Enabled)
+	assert.Equal(t, testConfig.Safe, info.ESS.Target)
+}
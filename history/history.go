@@ -0,0 +1,328 @@
+// Package history keeps the values of the updates, recent ones in memory and
+// older ones in an on-disk store, so that they can be queried by field and
+// time range.
+package history
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2core"
+	"github.com/diebietse/invertergui/mk2driver"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logrus.WithField("ctx", "inverter-gui-history")
+
+// Fields maps the names of the fields kept in the history to functions
+// returning their values: the numeric fields of mk2core.Fields and the derived
+// powers. Fields return NaN for updates without a value, which are skipped.
+// The grid power and losses are kept in W when the real powers are known and
+// in VA otherwise, so that a field never mixes units.
+var Fields = map[string]func(*mk2driver.Mk2Info) float64{
+	"in_power":      func(i *mk2driver.Mk2Info) float64 { return i.Derived.InPower },
+	"out_power":     func(i *mk2driver.Mk2Info) float64 { return i.Derived.OutPower },
+	"bat_power":     func(i *mk2driver.Mk2Info) float64 { return i.Derived.BatPower },
+	"grid_power_va": func(i *mk2driver.Mk2Info) float64 { return apparentPower(i, i.Derived.GridPower) },
+	"grid_power_w":  func(i *mk2driver.Mk2Info) float64 { return realPower(i, i.Derived.GridPower) },
+	"losses_va":     func(i *mk2driver.Mk2Info) float64 { return apparentPower(i, i.Derived.Losses) },
+	"losses_w":      func(i *mk2driver.Mk2Info) float64 { return realPower(i, i.Derived.Losses) },
+}
+
+// apparentPower returns a derived power that is in VA unless the real powers
+// are known, NaN otherwise.
+func apparentPower(i *mk2driver.Mk2Info, power float64) float64 {
+	if i.Derived.RealPower {
+		return math.NaN()
+	}
+	return power
+}
+
+// realPower returns a derived power that is in W when the real powers are
+// known, NaN otherwise.
+func realPower(i *mk2driver.Mk2Info, power float64) float64 {
+	if !i.Derived.RealPower {
+		return math.NaN()
+	}
+	return power
+}
+
+func init() {
+	for name, field := range mk2core.Fields {
+		Fields[name] = field
+	}
+}
+
+// FieldNames returns the names of all Fields sorted.
+func FieldNames() []string {
+	names := make([]string, 0, len(Fields))
+	for name := range Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ErrUnknownField is returned when querying a field that is not in Fields.
+var ErrUnknownField = errors.New("unknown field")
+
+// Point is the value of a field at a time. Downsampled points hold the
+// minimum, average and maximum of the Count samples from Time up to the next
+// point, raw points a single sample.
+type Point struct {
+	Time  time.Time `json:"time"`
+	Min   float64   `json:"min"`
+	Avg   float64   `json:"avg"`
+	Max   float64   `json:"max"`
+	Count int       `json:"count"`
+}
+
+// Series holds the points of a field in a time range.
+type Series struct {
+	Field string
+	// Time covered by each point, zero for raw samples.
+	Step   time.Duration
+	Points []Point
+}
+
+// Querier is implemented by histories that can be queried.
+type Querier interface {
+	// Query returns the points of field between from and to, at the finest
+	// resolution that covers from.
+	Query(field string, from, to time.Time) (Series, error)
+}
+
+// Config sets how many raw samples are kept.
+type Config struct {
+	// Time for which every sample is kept.
+	RawWindow time.Duration
+	// Limit on the number of raw samples, which bounds the memory used at
+	// high update rates.
+	MaxRawSamples int
+}
+
+func (c Config) validate() error {
+	if c.RawWindow <= 0 {
+		return fmt.Errorf("raw window must be positive")
+	}
+	if c.MaxRawSamples <= 0 {
+		return fmt.Errorf("raw sample limit must be positive")
+	}
+	return nil
+}
+
+// Tier is a downsampled resolution of the history.
+type Tier struct {
+	// Time covered by each point.
+	Step time.Duration
+	// Time for which points are kept.
+	Span time.Duration
+}
+
+// Tiers are the downsampled resolutions, finest first: minutes for the last
+// day and ten minutes for the last week.
+var Tiers = []Tier{
+	{Step: time.Minute, Span: 24 * time.Hour},
+	{Step: 10 * time.Minute, Span: 7 * 24 * time.Hour},
+}
+
+// History keeps the values of the valid updates of a source: every sample for
+// the raw window and the downsampled Tiers beyond it. Its memory use is bounded
+// by the raw sample limit and the number of points of the tiers.
+type History struct {
+	mk2driver.Mk2
+	config Config
+	// Field names in the order of the sample values.
+	names []string
+
+	lock   sync.Mutex
+	raw    []sample
+	tiers  []*tier
+	latest time.Time
+}
+
+// sample holds the values of all fields at a time, in the order of names.
+type sample struct {
+	time   time.Time
+	values []float64
+}
+
+// New starts keeping the history of the updates of source until it closes its
+// channel.
+func New(source mk2driver.Mk2, config Config) (*History, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	h := &History{
+		Mk2:    source,
+		config: config,
+		names:  FieldNames(),
+	}
+	for _, t := range Tiers {
+		h.tiers = append(h.tiers, &tier{Tier: t})
+	}
+	go h.run()
+	return h, nil
+}
+
+func (h *History) run() {
+	for e := range h.C() {
+		if e.Valid {
+			h.Add(e)
+		}
+	}
+}
+
+// Add keeps the values of e at its timestamp. Updates that are not newer than
+// the previous one are ignored.
+func (h *History) Add(e *mk2driver.Mk2Info) {
+	values := make([]float64, len(h.names))
+	for i, name := range h.names {
+		values[i] = Fields[name](e)
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !e.Timestamp.After(h.latest) {
+		return
+	}
+	h.latest = e.Timestamp
+	h.raw = append(h.raw, sample{time: e.Timestamp, values: values})
+	// Dropping from the front lets append move the kept samples to a new
+	// array once the old one is full, so the array stays bounded.
+	drop := 0
+	for drop < len(h.raw) && (h.raw[drop].time.Before(h.latest.Add(-h.config.RawWindow)) || len(h.raw)-drop > h.config.MaxRawSamples) {
+		drop++
+	}
+	h.raw = h.raw[drop:]
+	for _, t := range h.tiers {
+		t.add(e.Timestamp, values)
+	}
+}
+
+// Query returns the points of field between from and to. Raw samples are
+// returned when they cover from, otherwise the points of the first tier that
+// does.
+func (h *History) Query(field string, from, to time.Time) (Series, error) {
+	index := sort.SearchStrings(h.names, field)
+	if index == len(h.names) || h.names[index] != field {
+		return Series{}, fmt.Errorf("%w %q", ErrUnknownField, field)
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	series := Series{Field: field, Points: []Point{}}
+	if h.rawCovers(from) {
+		for _, s := range h.raw {
+			if s.time.Before(from) || s.time.After(to) {
+				continue
+			}
+			v := s.values[index]
+			if math.IsNaN(v) {
+				continue
+			}
+			series.Points = append(series.Points, Point{Time: s.time, Min: v, Avg: v, Max: v, Count: 1})
+		}
+		return series, nil
+	}
+	t := h.tiers[len(h.tiers)-1]
+	for _, candidate := range h.tiers {
+		if !from.Before(h.latest.Add(-candidate.Span)) {
+			t = candidate
+			break
+		}
+	}
+	series.Step = t.Step
+	series.Points = t.points(index, from, to)
+	return series, nil
+}
+
+// rawCovers reports whether the raw samples reach back to from, under lock.
+func (h *History) rawCovers(from time.Time) bool {
+	if from.Before(h.latest.Add(-h.config.RawWindow)) {
+		return false
+	}
+	return len(h.raw) < h.config.MaxRawSamples || !from.Before(h.raw[0].time)
+}
+
+// tier keeps the downsampled points of a Tier.
+type tier struct {
+	Tier
+	buckets []bucket
+}
+
+// bucket aggregates the samples of every field during a step.
+type bucket struct {
+	start time.Time
+	// Number of samples, and of the values of every field that were not NaN.
+	count    int
+	counts   []int
+	min, max []float64
+	sum      []float64
+}
+
+func (t *tier) add(at time.Time, values []float64) {
+	start := at.Truncate(t.Step)
+	if n := len(t.buckets); n == 0 || !t.buckets[n-1].start.Equal(start) {
+		t.buckets = append(t.buckets, newBucket(start, len(values)))
+	}
+	t.buckets[len(t.buckets)-1].add(values)
+	drop := 0
+	for drop < len(t.buckets) && !t.buckets[drop].start.After(start.Add(-t.Span)) {
+		drop++
+	}
+	t.buckets = t.buckets[drop:]
+}
+
+// points returns the points of the field at index of the buckets overlapping
+// from to to.
+func (t *tier) points(index int, from, to time.Time) []Point {
+	points := []Point{}
+	for _, b := range t.buckets {
+		if !b.start.Add(t.Step).After(from) || b.start.After(to) || b.counts[index] == 0 {
+			continue
+		}
+		points = append(points, b.point(index))
+	}
+	return points
+}
+
+func newBucket(start time.Time, fields int) bucket {
+	b := bucket{
+		start:  start,
+		counts: make([]int, fields),
+		min:    make([]float64, fields),
+		max:    make([]float64, fields),
+		sum:    make([]float64, fields),
+	}
+	for i := range b.min {
+		b.min[i], b.max[i] = math.Inf(1), math.Inf(-1)
+	}
+	return b
+}
+
+func (b *bucket) add(values []float64) {
+	b.count++
+	for i, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		b.counts[i]++
+		b.min[i] = math.Min(b.min[i], v)
+		b.max[i] = math.Max(b.max[i], v)
+		b.sum[i] += v
+	}
+}
+
+// point returns the point of the field at index, which must have values.
+func (b *bucket) point(index int) Point {
+	return Point{
+		Time:  b.start,
+		Min:   b.min[index],
+		Avg:   b.sum[index] / float64(b.counts[index]),
+		Max:   b.max[index],
+		Count: b.counts[index],
+	}
+}
@@ -0,0 +1,157 @@
+package history
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2driver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSource struct {
+	c chan *mk2driver.Mk2Info
+}
+
+func (f *fakeSource) C() chan *mk2driver.Mk2Info {
+	return f.c
+}
+
+func (f *fakeSource) Close() {}
+
+var start = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// Returns a history fed directly through Add.
+func newHistory(t *testing.T, config Config) *History {
+	t.Helper()
+	source := &fakeSource{c: make(chan *mk2driver.Mk2Info)}
+	h, err := New(source, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { close(source.c) })
+	return h
+}
+
+func update(at time.Time, voltage float64) *mk2driver.Mk2Info {
+	return &mk2driver.Mk2Info{Valid: true, BatVoltage: voltage, Timestamp: at}
+}
+
+func TestHistoryRaw(t *testing.T) {
+	h := newHistory(t, Config{RawWindow: time.Minute, MaxRawSamples: 1000})
+	for s := 0; s < 120; s++ {
+		h.Add(update(start.Add(time.Duration(s)*time.Second), float64(s)))
+	}
+	// Not newer than the previous update.
+	h.Add(update(start.Add(time.Second), 1000))
+
+	series, err := h.Query("bat_voltage", start.Add(100*time.Second), start.Add(102*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, "bat_voltage", series.Field)
+	assert.Equal(t, time.Duration(0), series.Step)
+	assert.Equal(t, []Point{
+		{Time: start.Add(100 * time.Second), Min: 100, Avg: 100, Max: 100, Count: 1},
+		{Time: start.Add(101 * time.Second), Min: 101, Avg: 101, Max: 101, Count: 1},
+		{Time: start.Add(102 * time.Second), Min: 102, Avg: 102, Max: 102, Count: 1},
+	}, series.Points)
+
+	// Beyond the raw window the minute tier is used.
+	series, err = h.Query("bat_voltage", start, start.Add(2*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, series.Step)
+	assert.Equal(t, []Point{
+		{Time: start, Min: 0, Avg: 29.5, Max: 59, Count: 60},
+		{Time: start.Add(time.Minute), Min: 60, Avg: 89.5, Max: 119, Count: 60},
+	}, series.Points)
+
+	_, err = h.Query("nope", start, start)
+	assert.True(t, errors.Is(err, ErrUnknownField))
+}
+
+func TestHistoryLimits(t *testing.T) {
+	h := newHistory(t, Config{RawWindow: time.Hour, MaxRawSamples: 10})
+	for s := 0; s < 8*24*3600; s += 30 {
+		h.Add(update(start.Add(time.Duration(s)*time.Second), 1))
+	}
+	latest := start.Add(8*24*time.Hour - 30*time.Second)
+
+	h.lock.Lock()
+	assert.Len(t, h.raw, 10)
+	for i, tier := range h.tiers {
+		assert.LessOrEqual(t, len(tier.buckets), int(Tiers[i].Span/Tiers[i].Step)+1)
+	}
+	h.lock.Unlock()
+
+	// The raw samples do not cover the window once the limit is reached.
+	series, err := h.Query("bat_voltage", latest.Add(-time.Minute), latest)
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), series.Step)
+	assert.Len(t, series.Points, 3)
+	series, err = h.Query("bat_voltage", latest.Add(-time.Hour), latest)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, series.Step)
+	assert.Len(t, series.Points, 61)
+
+	series, err = h.Query("bat_voltage", latest.Add(-2*24*time.Hour), latest)
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, series.Step)
+	assert.Equal(t, 2*24*6+1, len(series.Points))
+	assert.Equal(t, 20, series.Points[0].Count)
+
+	// Older than the week tier.
+	series, err = h.Query("bat_voltage", start, start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, series.Points)
+}
+
+func TestHistoryFields(t *testing.T) {
+	h := newHistory(t, Config{RawWindow: time.Minute, MaxRawSamples: 10})
+	h.Add(&mk2driver.Mk2Info{Valid: true, Timestamp: start, Derived: mk2driver.DerivedValues{GridPower: -300}})
+	// The grid power is in W once the real powers are known.
+	h.Add(&mk2driver.Mk2Info{Valid: true, Timestamp: start.Add(time.Second), Derived: mk2driver.DerivedValues{GridPower: -250, RealPower: true}})
+	h.Add(&mk2driver.Mk2Info{Valid: true, Timestamp: start.Add(2 * time.Second), Derived: mk2driver.DerivedValues{GridPower: -200, RealPower: true}})
+
+	series, err := h.Query("grid_power_va", start, start.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, series.Points, 1)
+	assert.Equal(t, -300.0, series.Points[0].Avg)
+	series, err = h.Query("grid_power_w", start, start.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, series.Points, 2)
+	assert.Equal(t, -250.0, series.Points[0].Avg)
+
+	// Downsampled points only aggregate the values in their unit.
+	series, err = h.Query("grid_power_w", start.Add(-time.Hour), start.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, series.Step)
+	assert.Equal(t, []Point{{Time: start, Min: -250, Avg: -225, Max: -200, Count: 2}}, series.Points)
+	series, err = h.Query("losses_w", start.Add(-time.Hour), start.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, series.Points, 1)
+	assert.Equal(t, 2, series.Points[0].Count)
+
+	assert.Contains(t, FieldNames(), "bat_voltage")
+	assert.Contains(t, FieldNames(), "losses_va")
+}
+
+func TestHistorySource(t *testing.T) {
+	source := &fakeSource{c: make(chan *mk2driver.Mk2Info)}
+	h, err := New(source, Config{RawWindow: time.Minute, MaxRawSamples: 10})
+	require.NoError(t, err)
+	defer close(source.c)
+
+	invalid := update(start, 1)
+	invalid.Valid = false
+	source.c <- invalid
+	source.c <- update(start.Add(time.Second), 2)
+	require.Eventually(t, func() bool {
+		series, err := h.Query("bat_voltage", start, start.Add(time.Minute))
+		return err == nil && len(series.Points) == 1 && series.Points[0].Avg == 2
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestConfig(t *testing.T) {
+	_, err := New(&fakeSource{}, Config{MaxRawSamples: 10})
+	assert.Error(t, err)
+	_, err = New(&fakeSource{}, Config{RawWindow: time.Minute})
+	assert.Error(t, err)
+}
@@ -0,0 +1,244 @@
+package history
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// A segment file starts with a header naming its fields:
+//
+//	magic "IGSG", version, kind, field count (uint16), then for every field
+//	its name length (uint8) and name
+//
+// followed by the appended records:
+//
+//	payload length (uint32), CRC-32 of the payload (uint32), payload
+//
+// The payload of a raw record is its time in Unix nanoseconds (int64) and the
+// value of every field, that of a downsampled record its time, the number of
+// samples (uint32) and the minimum, average and maximum of every field. All
+// numbers are little endian, values float64.
+const (
+	segmentMagic   = "IGSG"
+	segmentVersion = 1
+
+	kindRaw  byte = 0
+	kindDown byte = 1
+
+	recordHeaderSize = 8
+)
+
+var errInvalidHeader = errors.New("invalid segment header")
+
+// record is a raw sample or a downsampled point of all fields. The values of
+// a raw record are all in avg, those of fields missing from its segment NaN.
+type record struct {
+	time          time.Time
+	count         int
+	min, avg, max []float64
+}
+
+func encodeHeader(kind byte, names []string) []byte {
+	data := append([]byte(segmentMagic), segmentVersion, kind)
+	data = binary.LittleEndian.AppendUint16(data, uint16(len(names)))
+	for _, name := range names {
+		data = append(data, byte(len(name)))
+		data = append(data, name...)
+	}
+	return data
+}
+
+func payloadSize(kind byte, fields int) int {
+	if kind == kindRaw {
+		return 8 + 8*fields
+	}
+	return 8 + 4 + 3*8*fields
+}
+
+func encodeRecord(kind byte, r record) []byte {
+	payload := make([]byte, 0, payloadSize(kind, len(r.avg)))
+	payload = binary.LittleEndian.AppendUint64(payload, uint64(r.time.UnixNano()))
+	if kind == kindRaw {
+		payload = appendFloats(payload, r.avg)
+	} else {
+		payload = binary.LittleEndian.AppendUint32(payload, uint32(r.count))
+		payload = appendFloats(payload, r.min)
+		payload = appendFloats(payload, r.avg)
+		payload = appendFloats(payload, r.max)
+	}
+	data := make([]byte, 0, recordHeaderSize+len(payload))
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(payload)))
+	data = binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(payload))
+	return append(data, payload...)
+}
+
+func appendFloats(data []byte, values []float64) []byte {
+	for _, v := range values {
+		data = binary.LittleEndian.AppendUint64(data, math.Float64bits(v))
+	}
+	return data
+}
+
+// readSegment calls fn with every intact record of the segment file at path,
+// with the values in the order of names. It returns the size of the intact
+// part of the file, which is shorter than the file when its last record was
+// torn by a crash.
+func readSegment(path string, kind byte, names []string, fn func(record)) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	fileNames, offset, err := decodeHeader(data, kind)
+	if err != nil {
+		return 0, err
+	}
+	positions := fieldPositions(fileNames, names)
+	size := payloadSize(kind, len(fileNames))
+	for len(data)-offset >= recordHeaderSize+size {
+		length := binary.LittleEndian.Uint32(data[offset:])
+		sum := binary.LittleEndian.Uint32(data[offset+4:])
+		payload := data[offset+recordHeaderSize : offset+recordHeaderSize+size]
+		if int(length) != size || crc32.ChecksumIEEE(payload) != sum {
+			break
+		}
+		fn(decodeRecord(kind, payload, positions, len(names)))
+		offset += recordHeaderSize + size
+	}
+	return int64(offset), nil
+}
+
+// readSegmentRange calls fn with the intact records from from to to of the
+// first size bytes of the segment file at path. The records are of the same
+// size and in time order, so the first and last one in range are found by a
+// binary search instead of reading the whole file.
+func readSegmentRange(path string, kind byte, names []string, size int64, from, to time.Time, fn func(record)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	head := make([]byte, 8)
+	if _, err := f.ReadAt(head, 0); err != nil {
+		return errInvalidHeader
+	}
+	// Field names are at most 255 bytes long.
+	head = make([]byte, min(size, 8+256*int64(binary.LittleEndian.Uint16(head[6:]))))
+	if _, err := f.ReadAt(head, 0); err != nil {
+		return errInvalidHeader
+	}
+	fileNames, offset, err := decodeHeader(head, kind)
+	if err != nil {
+		return err
+	}
+	payload := payloadSize(kind, len(fileNames))
+	recordSize := int64(recordHeaderSize + payload)
+	count := int((size - int64(offset)) / recordSize)
+
+	var readErr error
+	timeAt := func(i int) time.Time {
+		var data [8]byte
+		if _, err := f.ReadAt(data[:], int64(offset)+int64(i)*recordSize+recordHeaderSize); err != nil {
+			readErr = err
+		}
+		return time.Unix(0, int64(binary.LittleEndian.Uint64(data[:])))
+	}
+	first := sort.Search(count, func(i int) bool { return !timeAt(i).Before(from) })
+	last := sort.Search(count, func(i int) bool { return timeAt(i).After(to) })
+	if readErr != nil {
+		return readErr
+	}
+	if first >= last {
+		return nil
+	}
+	data := make([]byte, int64(last-first)*recordSize)
+	if _, err := f.ReadAt(data, int64(offset)+int64(first)*recordSize); err != nil {
+		return err
+	}
+	positions := fieldPositions(fileNames, names)
+	for ; len(data) > 0; data = data[recordSize:] {
+		length := binary.LittleEndian.Uint32(data)
+		sum := binary.LittleEndian.Uint32(data[4:])
+		record := data[recordHeaderSize:recordSize]
+		if int(length) != payload || crc32.ChecksumIEEE(record) != sum {
+			break
+		}
+		fn(decodeRecord(kind, record, positions, len(names)))
+	}
+	return nil
+}
+
+// fieldPositions returns the position of every field of a file in names, -1
+// for removed fields.
+func fieldPositions(fileNames, names []string) []int {
+	positions := make([]int, len(fileNames))
+	for i, name := range fileNames {
+		positions[i] = -1
+		for j, current := range names {
+			if name == current {
+				positions[i] = j
+			}
+		}
+	}
+	return positions
+}
+
+func decodeHeader(data []byte, kind byte) ([]string, int, error) {
+	if len(data) < 8 || string(data[:4]) != segmentMagic || data[4] != segmentVersion || data[5] != kind {
+		return nil, 0, errInvalidHeader
+	}
+	count := int(binary.LittleEndian.Uint16(data[6:]))
+	offset := 8
+	names := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		if offset >= len(data) || offset+1+int(data[offset]) > len(data) {
+			return nil, 0, errInvalidHeader
+		}
+		length := int(data[offset])
+		names = append(names, string(data[offset+1:offset+1+length]))
+		offset += 1 + length
+	}
+	return names, offset, nil
+}
+
+func decodeRecord(kind byte, payload []byte, positions []int, fields int) record {
+	r := record{
+		time:  time.Unix(0, int64(binary.LittleEndian.Uint64(payload))),
+		count: 1,
+		avg:   nanValues(fields),
+	}
+	payload = payload[8:]
+	if kind == kindRaw {
+		decodeFloats(payload, positions, r.avg)
+		r.min, r.max = r.avg, r.avg
+		return r
+	}
+	r.count = int(binary.LittleEndian.Uint32(payload))
+	payload = payload[4:]
+	r.min, r.max = nanValues(fields), nanValues(fields)
+	n := 8 * len(positions)
+	decodeFloats(payload, positions, r.min)
+	decodeFloats(payload[n:], positions, r.avg)
+	decodeFloats(payload[2*n:], positions, r.max)
+	return r
+}
+
+func decodeFloats(data []byte, positions []int, values []float64) {
+	for i, position := range positions {
+		if position >= 0 {
+			values[position] = math.Float64frombits(binary.LittleEndian.Uint64(data[8*i:]))
+		}
+	}
+}
+
+func nanValues(n int) []float64 {
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = math.NaN()
+	}
+	return values
+}
@@ -0,0 +1,536 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2driver"
+)
+
+// Time covered by the segment files of the raw and downsampled updates.
+const (
+	rawSegmentSpan  = time.Hour
+	downSegmentSpan = 7 * 24 * time.Hour
+)
+
+// Longest time range for which raw updates are returned, longer ranges are
+// returned downsampled.
+const maxRawQuery = 6 * time.Hour
+
+// Name of the index of the segment files in the store directory.
+const indexFile = "index.json"
+
+// StoreConfig sets where and for how long the store keeps the updates.
+type StoreConfig struct {
+	// Directory holding the segment files.
+	Dir string
+	// Time for which every update is kept. Older updates are downsampled.
+	RawRetention time.Duration
+	// Time covered by each downsampled point, a divisor of an hour.
+	Step time.Duration
+	// Time for which downsampled points are kept.
+	Retention time.Duration
+}
+
+func (c StoreConfig) validate() error {
+	if c.Dir == "" {
+		return fmt.Errorf("store directory required")
+	}
+	if c.RawRetention <= 0 {
+		return fmt.Errorf("raw retention must be positive")
+	}
+	if c.Step <= 0 || rawSegmentSpan%c.Step != 0 {
+		return fmt.Errorf("step %v must divide %v", c.Step, rawSegmentSpan)
+	}
+	if c.Retention < c.RawRetention {
+		return fmt.Errorf("retention %v shorter than the raw retention %v", c.Retention, c.RawRetention)
+	}
+	return nil
+}
+
+// Store keeps the values of the valid updates of a source on disk, in append
+// only segment files of an hour of raw updates or a week of downsampled points.
+// Raw updates older than the raw retention are downsampled to points of Step,
+// which are deleted after the retention. A segment torn by a crash is cut back
+// to its last intact update when the store is opened.
+type Store struct {
+	mk2driver.Mk2
+	config StoreConfig
+	// Field names in the order of the record values.
+	names []string
+	done  chan struct{}
+
+	lock sync.Mutex
+	// Held for reading while queries read segment files without lock, and
+	// for writing while segment files are deleted.
+	files  sync.RWMutex
+	raw    *level
+	down   *level
+	latest time.Time
+}
+
+// level is the sequence of segment files of the raw or downsampled records.
+type level struct {
+	prefix   string
+	kind     byte
+	span     time.Duration
+	segments []*segment
+	// File of the last segment while it is appended to.
+	active *os.File
+}
+
+// segment describes a segment file in the index.
+type segment struct {
+	File    string    `json:"file"`
+	First   time.Time `json:"first"`
+	Last    time.Time `json:"last"`
+	Records int       `json:"records"`
+	Size    int64     `json:"size"`
+}
+
+type storeIndex struct {
+	Raw  []*segment `json:"raw"`
+	Down []*segment `json:"down"`
+}
+
+// OpenStore opens the store in config.Dir, recovering the segment files left
+// by a previous run, and starts storing the updates of source until it closes
+// its channel.
+func OpenStore(source mk2driver.Mk2, config StoreConfig) (*Store, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Store{
+		Mk2:    source,
+		config: config,
+		names:  FieldNames(),
+		done:   make(chan struct{}),
+		raw:    &level{prefix: "raw", kind: kindRaw, span: rawSegmentSpan},
+		down:   &level{prefix: "down", kind: kindDown, span: downSegmentSpan},
+	}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+	if err := s.maintain(); err != nil {
+		return nil, err
+	}
+	go s.run()
+	return s, nil
+}
+
+// Done is closed once the source closed its channel and the segment files
+// were synced and closed.
+func (s *Store) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Store) run() {
+	defer close(s.done)
+	for e := range s.C() {
+		if e.Valid {
+			s.Add(e)
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, l := range []*level{s.raw, s.down} {
+		if err := s.seal(l); err != nil {
+			log.Errorf("Could not close segment: %v", err)
+		}
+	}
+	if err := s.writeIndex(); err != nil {
+		log.Errorf("Could not write store index: %v", err)
+	}
+}
+
+// Add stores the values of e at its timestamp. Updates that are not newer than
+// the previous one are ignored.
+func (s *Store) Add(e *mk2driver.Mk2Info) {
+	values := make([]float64, len(s.names))
+	for i, name := range s.names {
+		values[i] = Fields[name](e)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !e.Timestamp.After(s.latest) {
+		return
+	}
+	s.latest = e.Timestamp
+	rotated, err := s.append(s.raw, record{time: e.Timestamp, count: 1, min: values, avg: values, max: values})
+	if err != nil {
+		log.Errorf("Could not store update: %v", err)
+		return
+	}
+	if rotated {
+		if err := s.maintain(); err != nil {
+			log.Errorf("Could not downsample stored updates: %v", err)
+		}
+	}
+}
+
+// append adds r to the last segment of l, starting a new segment when r is
+// outside the span of the last one. It reports whether a segment was sealed.
+func (s *Store) append(l *level, r record) (bool, error) {
+	rotated := false
+	if l.active != nil {
+		current := l.segments[len(l.segments)-1]
+		if !r.time.Truncate(l.span).Equal(current.First.Truncate(l.span)) {
+			if err := s.seal(l); err != nil {
+				return false, err
+			}
+			rotated = true
+		}
+	}
+	if l.active == nil {
+		if err := s.create(l, r.time); err != nil {
+			return rotated, err
+		}
+	}
+	current := l.segments[len(l.segments)-1]
+	data := encodeRecord(l.kind, r)
+	if _, err := l.active.Write(data); err != nil {
+		return rotated, err
+	}
+	current.Last = r.time
+	current.Records++
+	current.Size += int64(len(data))
+	return rotated, nil
+}
+
+// create starts a new segment of l for records from first on.
+func (s *Store) create(l *level, first time.Time) error {
+	name := fmt.Sprintf("%s-%020d.seg", l.prefix, first.UnixNano())
+	f, err := os.OpenFile(filepath.Join(s.config.Dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	header := encodeHeader(l.kind, s.names)
+	if _, err := f.Write(header); err != nil {
+		f.Close()
+		return err
+	}
+	l.active = f
+	l.segments = append(l.segments, &segment{File: name, First: first, Last: first, Size: int64(len(header))})
+	return nil
+}
+
+// seal syncs and closes the last segment of l, if it is appended to.
+func (s *Store) seal(l *level) error {
+	if l.active == nil {
+		return nil
+	}
+	f := l.active
+	l.active = nil
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// maintain downsamples the raw segments older than the raw retention and
+// deletes the downsampled segments older than the retention.
+func (s *Store) maintain() error {
+	// Segments are downsampled together, but not apart from a newer segment
+	// that shares their last point.
+	old := 0
+	for old < len(s.raw.segments) && s.sealed(s.raw, old) && s.raw.segments[old].Last.Before(s.latest.Add(-s.config.RawRetention)) {
+		old++
+	}
+	for old > 0 && old < len(s.raw.segments) &&
+		!s.raw.segments[old].First.Truncate(s.config.Step).After(s.raw.segments[old-1].Last.Truncate(s.config.Step)) {
+		old--
+	}
+	if old > 0 {
+		if err := s.downsample(s.raw.segments[:old]); err != nil {
+			return err
+		}
+		for ; old > 0; old-- {
+			if err := s.remove(s.raw); err != nil {
+				return err
+			}
+		}
+	}
+	for len(s.down.segments) > 0 && s.sealed(s.down, 0) && s.down.segments[0].Last.Before(s.latest.Add(-s.config.Retention)) {
+		if err := s.remove(s.down); err != nil {
+			return err
+		}
+	}
+	return s.writeIndex()
+}
+
+// sealed reports whether the segment at index of l is no longer appended to.
+func (s *Store) sealed(l *level, index int) bool {
+	return l.active == nil || index < len(l.segments)-1
+}
+
+// remove deletes the first segment of l.
+func (s *Store) remove(l *level) error {
+	s.files.Lock()
+	defer s.files.Unlock()
+	if err := os.Remove(filepath.Join(s.config.Dir, l.segments[0].File)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	l.segments = l.segments[1:]
+	return nil
+}
+
+// downsample appends the points of the raw updates of segments to the
+// downsampled segments. Points that were already appended before a crash are
+// skipped.
+func (s *Store) downsample(segments []*segment) error {
+	var after time.Time
+	if n := len(s.down.segments); n > 0 {
+		after = s.down.segments[n-1].Last
+	}
+	var buckets []bucket
+	err := s.readSegments(segments, kindRaw, func(r record) {
+		start := r.time.Truncate(s.config.Step)
+		if !start.After(after) {
+			return
+		}
+		if n := len(buckets); n == 0 || !buckets[n-1].start.Equal(start) {
+			buckets = append(buckets, newBucket(start, len(s.names)))
+		}
+		buckets[len(buckets)-1].add(r.avg)
+	})
+	if err != nil {
+		return err
+	}
+	for _, b := range buckets {
+		if _, err := s.append(s.down, bucketRecord(b)); err != nil {
+			return err
+		}
+	}
+	if s.down.active != nil {
+		return s.down.active.Sync()
+	}
+	return nil
+}
+
+// bucketRecord returns the downsampled record of b, with NaN values for the
+// fields without values.
+func bucketRecord(b bucket) record {
+	r := record{time: b.start, count: b.count, min: b.min, max: b.max, avg: make([]float64, len(b.sum))}
+	for i, sum := range b.sum {
+		if b.counts[i] == 0 {
+			r.min[i], r.avg[i], r.max[i] = math.NaN(), math.NaN(), math.NaN()
+			continue
+		}
+		r.avg[i] = sum / float64(b.counts[i])
+	}
+	return r
+}
+
+// recover rebuilds the segment lists from the segment files, trusting the
+// index for the files whose size did not change since it was written.
+// Segments with a torn last record are cut back to their intact records.
+func (s *Store) recover() error {
+	indexed := map[string]*segment{}
+	if data, err := os.ReadFile(filepath.Join(s.config.Dir, indexFile)); err == nil {
+		var index storeIndex
+		if err := json.Unmarshal(data, &index); err != nil {
+			log.Warnf("Ignoring invalid store index: %v", err)
+		}
+		for _, seg := range append(index.Raw, index.Down...) {
+			indexed[seg.File] = seg
+		}
+	}
+	entries, err := os.ReadDir(s.config.Dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		var l *level
+		switch {
+		case strings.HasPrefix(name, s.raw.prefix+"-") && strings.HasSuffix(name, ".seg"):
+			l = s.raw
+		case strings.HasPrefix(name, s.down.prefix+"-") && strings.HasSuffix(name, ".seg"):
+			l = s.down
+		default:
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if seg, ok := indexed[name]; ok && seg.Size == info.Size() {
+			l.segments = append(l.segments, seg)
+			continue
+		}
+		seg, err := s.scan(l, name, info.Size())
+		if err != nil {
+			return err
+		}
+		if seg != nil {
+			l.segments = append(l.segments, seg)
+		}
+	}
+	for _, l := range []*level{s.raw, s.down} {
+		sort.Slice(l.segments, func(a, b int) bool { return l.segments[a].First.Before(l.segments[b].First) })
+		if n := len(l.segments); n > 0 && l.segments[n-1].Last.After(s.latest) {
+			s.latest = l.segments[n-1].Last
+		}
+	}
+	return nil
+}
+
+// scan reads the segment file name of l and truncates it after its last
+// intact record. Files without intact records are deleted and nil returned.
+func (s *Store) scan(l *level, name string, size int64) (*segment, error) {
+	path := filepath.Join(s.config.Dir, name)
+	seg := &segment{File: name}
+	intact, err := readSegment(path, l.kind, s.names, func(r record) {
+		if seg.Records == 0 {
+			seg.First = r.time
+		}
+		seg.Last = r.time
+		seg.Records++
+	})
+	if err != nil && !errors.Is(err, errInvalidHeader) {
+		return nil, err
+	}
+	if seg.Records == 0 {
+		log.Warnf("Removing segment %s without intact records", name)
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	if intact < size {
+		log.Warnf("Recovered segment %s, dropped %d bytes of a torn record", name, size-intact)
+		if err := os.Truncate(path, intact); err != nil {
+			return nil, err
+		}
+	}
+	seg.Size = intact
+	return seg, nil
+}
+
+// writeIndex replaces the index with the current segment lists.
+func (s *Store) writeIndex() error {
+	data, err := json.Marshal(storeIndex{Raw: s.raw.segments, Down: s.down.segments})
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.config.Dir, indexFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Query returns the points of field between from and to. Raw updates are
+// returned when they reach back to from, or nothing was downsampled yet, and
+// the range is at most 6 hours,
+// otherwise points of Step, including those of the raw updates that were not
+// downsampled yet.
+func (s *Store) Query(field string, from, to time.Time) (Series, error) {
+	index := sort.SearchStrings(s.names, field)
+	if index == len(s.names) || s.names[index] != field {
+		return Series{}, fmt.Errorf("%w %q", ErrUnknownField, field)
+	}
+	series := Series{Field: field, Points: []Point{}}
+	start := from.Truncate(s.config.Step)
+	var raw, down []segment
+	s.lock.Lock()
+	rawCovers := len(s.raw.segments) > 0 && (len(s.down.segments) == 0 || !from.Before(s.raw.segments[0].First))
+	rawOnly := rawCovers && to.Sub(from) <= maxRawQuery
+	if rawOnly {
+		raw = overlapping(s.raw, from, to)
+	} else {
+		raw = overlapping(s.raw, start, to)
+		down = overlapping(s.down, start, to)
+	}
+	// The files are read without blocking updates. Segment files are only
+	// appended to, so the copied segments describe the part that was
+	// written, and they are not deleted until the query is done.
+	s.files.RLock()
+	defer s.files.RUnlock()
+	s.lock.Unlock()
+
+	if rawOnly {
+		err := s.read(raw, kindRaw, from, to, func(r record) {
+			v := r.avg[index]
+			if r.time.Before(from) || r.time.After(to) || math.IsNaN(v) {
+				return
+			}
+			series.Points = append(series.Points, Point{Time: r.time, Min: v, Avg: v, Max: v, Count: 1})
+		})
+		return series, err
+	}
+
+	series.Step = s.config.Step
+	err := s.read(down, kindDown, start, to, func(r record) {
+		if r.time.Before(start) || r.time.After(to) || math.IsNaN(r.avg[index]) {
+			return
+		}
+		series.Points = append(series.Points, Point{Time: r.time, Min: r.min[index], Avg: r.avg[index], Max: r.max[index], Count: r.count})
+	})
+	if err != nil {
+		return series, err
+	}
+	var b *bucket
+	flush := func() {
+		if b != nil && b.counts[0] > 0 {
+			series.Points = append(series.Points, b.point(0))
+		}
+	}
+	err = s.read(raw, kindRaw, start, to, func(r record) {
+		if r.time.Before(start) || r.time.After(to) {
+			return
+		}
+		bucketStart := r.time.Truncate(s.config.Step)
+		if b == nil || !b.start.Equal(bucketStart) {
+			flush()
+			next := newBucket(bucketStart, 1)
+			b = &next
+		}
+		b.add(r.avg[index : index+1])
+	})
+	flush()
+	return series, err
+}
+
+// overlapping returns copies of the segments of l that overlap from to to.
+func overlapping(l *level, from, to time.Time) []segment {
+	var segments []segment
+	for _, seg := range l.segments {
+		if !seg.Last.Before(from) && !seg.First.After(to) {
+			segments = append(segments, *seg)
+		}
+	}
+	return segments
+}
+
+// read calls fn with the records from from to to of segments.
+func (s *Store) read(segments []segment, kind byte, from, to time.Time, fn func(record)) error {
+	for _, seg := range segments {
+		if err := readSegmentRange(filepath.Join(s.config.Dir, seg.File), kind, s.names, seg.Size, from, to, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) readSegments(segments []*segment, kind byte, fn func(record)) error {
+	for _, seg := range segments {
+		if _, err := readSegment(filepath.Join(s.config.Dir, seg.File), kind, s.names, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
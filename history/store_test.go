@@ -0,0 +1,261 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2driver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var storeConfig = StoreConfig{
+	RawRetention: 2 * time.Hour,
+	Step:         10 * time.Minute,
+	Retention:    3 * 24 * time.Hour,
+}
+
+// Opens a store in dir fed directly through Add, the returned function closes
+// it.
+func openStore(t *testing.T, dir string) (*Store, func()) {
+	t.Helper()
+	config := storeConfig
+	config.Dir = dir
+	source := &fakeSource{c: make(chan *mk2driver.Mk2Info)}
+	s, err := OpenStore(source, config)
+	require.NoError(t, err)
+	return s, func() {
+		close(source.c)
+		<-s.Done()
+	}
+}
+
+func segmentFiles(t *testing.T, dir, pattern string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, pattern))
+	require.NoError(t, err)
+	return files
+}
+
+func TestStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, closeStore := openStore(t, dir)
+	for i := 0; i < 90; i++ {
+		s.Add(update(start.Add(time.Duration(i)*time.Minute), float64(i)))
+	}
+	closeStore()
+	// The updates span two hours.
+	assert.Len(t, segmentFiles(t, dir, "raw-*.seg"), 2)
+
+	s, closeStore = openStore(t, dir)
+	defer closeStore()
+	// Not newer than the stored updates.
+	s.Add(update(start, 1000))
+	series, err := s.Query("bat_voltage", start.Add(58*time.Minute), start.Add(61*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), series.Step)
+	assert.Equal(t, []Point{
+		{Time: start.Add(58 * time.Minute).Local(), Min: 58, Avg: 58, Max: 58, Count: 1},
+		{Time: start.Add(59 * time.Minute).Local(), Min: 59, Avg: 59, Max: 59, Count: 1},
+		{Time: start.Add(60 * time.Minute).Local(), Min: 60, Avg: 60, Max: 60, Count: 1},
+		{Time: start.Add(61 * time.Minute).Local(), Min: 61, Avg: 61, Max: 61, Count: 1},
+	}, series.Points)
+
+	// Nothing was downsampled yet.
+	series, err = s.Query("bat_voltage", start.Add(-time.Hour), start.Add(19*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), series.Step)
+	assert.Len(t, series.Points, 20)
+	// Long ranges are aggregated.
+	series, err = s.Query("bat_voltage", start.Add(-24*time.Hour), start.Add(19*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, series.Step)
+	require.Len(t, series.Points, 2)
+	assert.Equal(t, Point{Time: start.Add(10 * time.Minute).Local(), Min: 10, Avg: 14.5, Max: 19, Count: 10}, series.Points[1])
+
+	s.Add(update(start.Add(90*time.Minute), 90))
+	series, err = s.Query("bat_voltage", start.Add(89*time.Minute), start.Add(90*time.Minute))
+	require.NoError(t, err)
+	assert.Len(t, series.Points, 2)
+
+	_, err = s.Query("nope", start, start)
+	assert.ErrorIs(t, err, ErrUnknownField)
+}
+
+func TestStoreTornSegment(t *testing.T) {
+	dir := t.TempDir()
+	s, closeStore := openStore(t, dir)
+	for i := 0; i < 10; i++ {
+		s.Add(update(start.Add(time.Duration(i)*time.Second), float64(i)))
+	}
+	closeStore()
+
+	// A crash in the middle of appending the last update.
+	files := segmentFiles(t, dir, "raw-*.seg")
+	require.Len(t, files, 1)
+	info, err := os.Stat(files[0])
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(files[0], info.Size()-5))
+	// A segment with a torn header is removed.
+	torn := filepath.Join(dir, "raw-09999999999999999999.seg")
+	require.NoError(t, os.WriteFile(torn, []byte("IGS"), 0o644))
+
+	s, closeStore = openStore(t, dir)
+	defer closeStore()
+	series, err := s.Query("bat_voltage", start, start.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, series.Points, 9)
+	assert.Equal(t, 8.0, series.Points[8].Avg)
+	_, err = os.Stat(torn)
+	assert.True(t, os.IsNotExist(err))
+	info, err = os.Stat(files[0])
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), (info.Size()-int64(len(encodeHeader(kindRaw, FieldNames()))))%int64(recordHeaderSize+payloadSize(kindRaw, len(FieldNames()))))
+
+	// Updates are stored after the recovered ones.
+	s.Add(update(start.Add(9*time.Second), 9))
+	series, err = s.Query("bat_voltage", start, start.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Len(t, series.Points, 10)
+}
+
+func TestStoreDownsampling(t *testing.T) {
+	dir := t.TempDir()
+	s, closeStore := openStore(t, dir)
+	defer closeStore()
+	for i := 0; i < 5*24*60; i++ {
+		s.Add(update(start.Add(time.Duration(i)*time.Minute), float64(i%10)))
+	}
+	latest := start.Add(5*24*time.Hour - time.Minute)
+
+	// Raw updates are kept for the raw retention and the current segment.
+	assert.LessOrEqual(t, len(segmentFiles(t, dir, "raw-*.seg")), 4)
+	assert.NotEmpty(t, segmentFiles(t, dir, "down-*.seg"))
+
+	series, err := s.Query("bat_voltage", latest.Add(-24*time.Hour), latest)
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, series.Step)
+	assert.Len(t, series.Points, 24*6+1)
+	for _, p := range series.Points[:len(series.Points)-1] {
+		assert.Equal(t, Point{Time: p.Time, Min: 0, Avg: 4.5, Max: 9, Count: 10}, p)
+	}
+	// Consecutive points without gaps or duplicates across the downsampled
+	// and raw updates.
+	for i := 1; i < len(series.Points); i++ {
+		assert.Equal(t, 10*time.Minute, series.Points[i].Time.Sub(series.Points[i-1].Time))
+	}
+
+	// Beyond the retention.
+	series, err = s.Query("bat_voltage", start, start.Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, series.Points)
+}
+
+func TestStoreDownsamplingUnits(t *testing.T) {
+	s, closeStore := openStore(t, t.TempDir())
+	defer closeStore()
+	// The real powers are only known for every other update.
+	for i := 0; i < 4*60; i++ {
+		e := update(start.Add(time.Duration(i)*time.Minute), 25)
+		e.Derived = mk2driver.DerivedValues{GridPower: 500}
+		if i%2 == 0 {
+			e.Derived = mk2driver.DerivedValues{GridPower: 100, RealPower: true}
+		}
+		s.Add(e)
+	}
+	assert.NotEmpty(t, segmentFiles(t, s.config.Dir, "down-*.seg"))
+
+	series, err := s.Query("grid_power_w", start.Add(-24*time.Hour), start.Add(4*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, series.Step)
+	require.Len(t, series.Points, 4*6)
+	for _, p := range series.Points {
+		assert.Equal(t, []float64{100, 100, 100}, []float64{p.Min, p.Avg, p.Max}, "Point at %v", p.Time)
+	}
+}
+
+func TestStoreFieldChanges(t *testing.T) {
+	dir := t.TempDir()
+	// A segment written with a field that was removed since.
+	names := []string{"bat_voltage", "removed"}
+	data := encodeHeader(kindRaw, names)
+	data = append(data, encodeRecord(kindRaw, record{time: start, avg: []float64{25, 1}})...)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "raw-00000000000000000001.seg"), data, 0o644))
+
+	s, closeStore := openStore(t, dir)
+	defer closeStore()
+	series, err := s.Query("bat_voltage", start, start)
+	require.NoError(t, err)
+	require.Len(t, series.Points, 1)
+	assert.Equal(t, 25.0, series.Points[0].Avg)
+	series, err = s.Query("grid_power_w", start, start)
+	require.NoError(t, err)
+	assert.Empty(t, series.Points)
+}
+
+func TestStoreConfig(t *testing.T) {
+	for _, config := range []StoreConfig{
+		{RawRetention: time.Hour, Step: time.Minute, Retention: time.Hour},
+		{Dir: "x", Step: time.Minute, Retention: time.Hour},
+		{Dir: "x", RawRetention: time.Hour, Step: 7 * time.Minute, Retention: time.Hour},
+		{Dir: "x", RawRetention: time.Hour, Step: time.Minute, Retention: time.Minute},
+	} {
+		_, err := OpenStore(&fakeSource{}, config)
+		assert.Error(t, err, "%+v", config)
+	}
+}
+
+func TestReadSegmentRange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raw-00000000000000000001.seg")
+	names := []string{"bat_voltage"}
+	data := encodeHeader(kindRaw, names)
+	for i := 0; i < 100; i++ {
+		data = append(data, encodeRecord(kindRaw, record{time: start.Add(time.Duration(i) * time.Second), avg: []float64{float64(i)}})...)
+	}
+	size := int64(len(data))
+	// A record that is being appended after the size was copied.
+	data = append(data, encodeRecord(kindRaw, record{time: start.Add(100 * time.Second), avg: []float64{100}})[:10]...)
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	read := func(from, to time.Time) []float64 {
+		var values []float64
+		err := readSegmentRange(path, kindRaw, names, size, from, to, func(r record) {
+			values = append(values, r.avg[0])
+		})
+		require.NoError(t, err)
+		return values
+	}
+	assert.Equal(t, []float64{10, 11, 12}, read(start.Add(10*time.Second), start.Add(12*time.Second)))
+	assert.Equal(t, []float64{0, 1}, read(start.Add(-time.Hour), start.Add(time.Second+time.Millisecond)))
+	assert.Equal(t, []float64{98, 99}, read(start.Add(98*time.Second), start.Add(time.Hour)))
+	assert.Empty(t, read(start.Add(time.Hour), start.Add(2*time.Hour)))
+}
+
+func TestStoreConcurrentQuery(t *testing.T) {
+	s, closeStore := openStore(t, t.TempDir())
+	defer closeStore()
+	const updates = 3 * 24 * 60
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < updates; i++ {
+			s.Add(update(start.Add(time.Duration(i)*time.Minute), float64(i%10)))
+		}
+	}()
+	// Queries read segments while they are appended to, downsampled and
+	// deleted.
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		series, err := s.Query("bat_voltage", start, start.Add(updates*time.Minute))
+		require.NoError(t, err)
+		for i := 1; i < len(series.Points); i++ {
+			require.True(t, series.Points[i].Time.After(series.Points[i-1].Time), "Points out of order at %v", series.Points[i].Time)
+		}
+	}
+}
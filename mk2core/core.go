@@ -1,57 +1,182 @@
 package mk2core
 
 import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
 	"github.com/diebietse/invertergui/mk2driver"
 )
 
+// Core distributes the updates of a source to subscriptions, each receiving its
+// own copy of every update. It stops when its context is cancelled, Close is
+// called or the source closes its channel, closing all subscriptions.
 type Core struct {
 	mk2driver.Mk2
-	plugins  map[*subscription]bool
-	register chan *subscription
+	// Only changed by run, under lock so that the statistics and latest
+	// update can be read.
+	lock       sync.Mutex
+	plugins    map[*subscription]bool
+	latest     *mk2driver.Mk2Info
+	received   time.Time
+	register   chan *subscription
+	unregister chan *subscription
+	processors []Processor
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewCore returns a Core distributing the updates of m until it is closed.
+// Updates are passed through Derive and then the processors in order.
+func NewCore(m mk2driver.Mk2, processors ...Processor) *Core {
+	return NewCoreContext(context.Background(), m, processors...)
 }
 
-func NewCore(m mk2driver.Mk2) *Core {
+// NewCoreContext returns a Core like NewCore that also stops when ctx is
+// cancelled.
+func NewCoreContext(ctx context.Context, m mk2driver.Mk2, processors ...Processor) *Core {
+	ctx, cancel := context.WithCancel(ctx)
 	core := &Core{
-		Mk2:      m,
-		register: make(chan *subscription, 255),
-		plugins:  map[*subscription]bool{},
+		Mk2:        m,
+		processors: append([]Processor{ProcessorFunc(Derive)}, processors...),
+		register:   make(chan *subscription),
+		unregister: make(chan *subscription),
+		plugins:    map[*subscription]bool{},
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
 	}
 	go core.run()
 	return core
 }
 
+// NewSubscription returns an unbuffered subscription to the updates of the
+// core that drops the updates it is not ready to receive. Closing it
+// unsubscribes. Its channel is closed once it is unsubscribed or the core
+// stopped.
 func (c *Core) NewSubscription() mk2driver.Mk2 {
-	sub := &subscription{
-		send: make(chan *mk2driver.Mk2Info),
+	return c.Subscribe(context.Background(), SubscriptionOptions{})
+}
+
+// NewSubscriptionContext returns a subscription like NewSubscription that is
+// also closed when ctx is cancelled.
+func (c *Core) NewSubscriptionContext(ctx context.Context) mk2driver.Mk2 {
+	return c.Subscribe(ctx, SubscriptionOptions{})
+}
+
+// SubscriptionStats returns the statistics of the current subscriptions
+// sorted by name.
+func (c *Core) SubscriptionStats() []SubscriptionStats {
+	c.lock.Lock()
+	stats := make([]SubscriptionStats, 0, len(c.plugins))
+	for plugin := range c.plugins {
+		stats = append(stats, plugin.stats())
+	}
+	c.lock.Unlock()
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// LatestSource is implemented by the core and its subscriptions to get the
+// current state without waiting for the next update.
+type LatestSource interface {
+	Latest() (*mk2driver.Mk2Info, time.Duration)
+}
+
+// Latest returns a copy of the most recent valid update and the time since the
+// core received it, or nil if no valid update was received yet. The copy is
+// owned by the caller.
+func (c *Core) Latest() (*mk2driver.Mk2Info, time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.latest == nil {
+		return nil, 0
 	}
-	c.register <- sub
-	return sub
+	return c.latest.Copy(), time.Since(c.received)
+}
+
+// Keeps a copy of e, which subscribers may still change.
+func (c *Core) setLatest(e *mk2driver.Mk2Info) {
+	latest := e.Copy()
+	c.lock.Lock()
+	c.latest = latest
+	c.received = time.Now()
+	c.lock.Unlock()
+}
+
+// Close stops the core and closes all subscriptions. It does not close the
+// source.
+func (c *Core) Close() {
+	c.cancel()
+	<-c.done
 }
 
 func (c *Core) run() {
+	defer close(c.done)
+	defer c.closeAll()
 	for {
 		select {
 		case r := <-c.register:
+			c.lock.Lock()
 			c.plugins[r] = true
-		case e := <-c.C():
-			for plugin := range c.plugins {
-				select {
-				case plugin.send <- e:
-				default:
-				}
+			c.lock.Unlock()
+		case r := <-c.unregister:
+			if c.plugins[r] {
+				c.lock.Lock()
+				delete(c.plugins, r)
+				c.lock.Unlock()
+				close(r.send)
+			}
+		case e, ok := <-c.C():
+			if !ok {
+				return
+			}
+			for _, p := range c.processors {
+				p.Process(e)
+			}
+			if e.Valid {
+				c.setLatest(e)
 			}
+			c.deliver(e)
+		case <-c.ctx.Done():
+			return
 		}
 	}
 }
 
-type subscription struct {
-	send chan *mk2driver.Mk2Info
-}
-
-func (s *subscription) C() chan *mk2driver.Mk2Info {
-	return s.send
+// Delivers a copy of e to every subscription whose filter passes it, so that
+// subscribers own their updates and cannot race each other. Blocking
+// subscriptions wait concurrently so that a slow one only delays the next
+// update, not the other subscriptions.
+func (c *Core) deliver(e *mk2driver.Mk2Info) {
+	var blocking sync.WaitGroup
+	for plugin := range c.plugins {
+		update, ok := plugin.filter.update(e)
+		if !ok {
+			plugin.filtered.Add(1)
+			continue
+		}
+		if plugin.opts.Policy != Block {
+			plugin.deliver(update)
+			continue
+		}
+		blocking.Add(1)
+		go func(plugin *subscription) {
+			defer blocking.Done()
+			plugin.deliver(update)
+		}(plugin)
+	}
+	blocking.Wait()
 }
 
-func (s *subscription) Close() {
-	close(s.send)
+func (c *Core) closeAll() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for plugin := range c.plugins {
+		close(plugin.send)
+		delete(c.plugins, plugin)
+	}
 }
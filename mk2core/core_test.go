@@ -0,0 +1,259 @@
+package mk2core
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2driver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSource struct {
+	c chan *mk2driver.Mk2Info
+}
+
+func newFakeSource() *fakeSource {
+	return &fakeSource{c: make(chan *mk2driver.Mk2Info)}
+}
+
+func (f *fakeSource) C() chan *mk2driver.Mk2Info {
+	return f.c
+}
+
+func (f *fakeSource) Close() {}
+
+// Sends updates until one was received by sub.
+func receive(t *testing.T, source *fakeSource, sub mk2driver.Mk2) *mk2driver.Mk2Info {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	info := &mk2driver.Mk2Info{Valid: true}
+	for {
+		select {
+		case source.c <- info:
+		case e := <-sub.C():
+			return e
+		case <-timeout:
+			t.Fatal("No update received")
+		}
+	}
+}
+
+// Waits until the channel of sub is closed.
+func waitClosed(t *testing.T, sub mk2driver.Mk2) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-sub.C():
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("Subscription not closed")
+		}
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	source := newFakeSource()
+	core := NewCore(source)
+	defer core.Close()
+
+	sub := core.NewSubscription()
+	e := receive(t, source, sub)
+	assert.True(t, e.Valid)
+}
+
+func TestSubscriptionClose(t *testing.T) {
+	source := newFakeSource()
+	core := NewCore(source)
+	defer core.Close()
+
+	closed := core.NewSubscription()
+	open := core.NewSubscription()
+	closed.Close()
+	closed.Close()
+	waitClosed(t, closed)
+
+	// The core keeps delivering to the remaining subscription.
+	receive(t, source, open)
+	receive(t, source, open)
+}
+
+func TestCoreClose(t *testing.T) {
+	source := newFakeSource()
+	core := NewCore(source)
+
+	subs := []mk2driver.Mk2{core.NewSubscription(), core.NewSubscription()}
+	core.Close()
+	for _, sub := range subs {
+		waitClosed(t, sub)
+		sub.Close()
+	}
+	core.Close()
+
+	late := core.NewSubscription()
+	waitClosed(t, late)
+	late.Close()
+}
+
+func TestCoreContext(t *testing.T) {
+	source := newFakeSource()
+	ctx, cancel := context.WithCancel(context.Background())
+	core := NewCoreContext(ctx, source)
+
+	sub := core.NewSubscription()
+	cancel()
+	waitClosed(t, sub)
+	core.Close()
+}
+
+func TestCoreSourceClosed(t *testing.T) {
+	source := newFakeSource()
+	core := NewCore(source)
+
+	sub := core.NewSubscription()
+	close(source.c)
+	waitClosed(t, sub)
+	core.Close()
+}
+
+func TestSubscriptionContext(t *testing.T) {
+	source := newFakeSource()
+	core := NewCore(source)
+	defer core.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := core.NewSubscriptionContext(ctx)
+	other := core.NewSubscription()
+	receive(t, source, sub)
+
+	cancel()
+	waitClosed(t, sub)
+	receive(t, source, other)
+}
+
+// Subscribes and unsubscribes from many goroutines while updates flow and the
+// core is closed, run with -race.
+func TestConcurrentSubscriptions(t *testing.T) {
+	source := newFakeSource()
+	core := NewCore(source)
+
+	stop := make(chan struct{})
+	var sources sync.WaitGroup
+	sources.Add(1)
+	go func() {
+		defer sources.Done()
+		for {
+			select {
+			case source.c <- &mk2driver.Mk2Info{Valid: true, BatVoltage: 12}:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var subscribers sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		subscribers.Add(1)
+		go func() {
+			defer subscribers.Done()
+			for j := 0; j < 50; j++ {
+				sub := core.NewSubscription()
+				select {
+				case e, ok := <-sub.C():
+					if ok {
+						assert.Equal(t, 12.0, e.BatVoltage)
+					}
+				case <-time.After(time.Millisecond):
+				}
+				sub.Close()
+			}
+		}()
+	}
+	readers := make([]mk2driver.Mk2, 5)
+	for i := range readers {
+		readers[i] = core.NewSubscription()
+		subscribers.Add(1)
+		go func(sub mk2driver.Mk2) {
+			defer subscribers.Done()
+			for range sub.C() {
+			}
+		}(readers[i])
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	core.Close()
+	subscribers.Wait()
+	close(stop)
+	sources.Wait()
+	require.Len(t, core.plugins, 0)
+}
+
+func TestLatest(t *testing.T) {
+	source := newFakeSource()
+	core := NewCore(source)
+	defer core.Close()
+
+	info, age := core.Latest()
+	assert.Nil(t, info)
+	assert.Zero(t, age)
+
+	sub := core.NewSubscription()
+	source.c <- &mk2driver.Mk2Info{Valid: true, BatVoltage: 12, LEDs: map[mk2driver.Led]mk2driver.LEDstate{mk2driver.LedMain: mk2driver.LedOn}}
+	// Invalid updates do not replace the latest one.
+	source.c <- &mk2driver.Mk2Info{BatVoltage: 13}
+	require.Eventually(t, func() bool {
+		info, _ := core.Latest()
+		return info != nil
+	}, 5*time.Second, time.Millisecond)
+
+	time.Sleep(10 * time.Millisecond)
+	info, age = sub.(LatestSource).Latest()
+	assert.Equal(t, 12.0, info.BatVoltage)
+	assert.GreaterOrEqual(t, age, 10*time.Millisecond)
+
+	// Every caller gets its own copy.
+	info.LEDs[mk2driver.LedMain] = mk2driver.LedOff
+	info, _ = core.Latest()
+	assert.Equal(t, mk2driver.LedOn, info.LEDs[mk2driver.LedMain])
+}
+
+// Subscribers own their updates, changing one does not change the update of
+// another subscriber.
+func TestSubscriptionCopies(t *testing.T) {
+	source := newFakeSource()
+	core := NewCore(source)
+	defer core.Close()
+
+	subs := []mk2driver.Mk2{
+		core.Subscribe(context.Background(), SubscriptionOptions{Buffer: 1}),
+		core.Subscribe(context.Background(), SubscriptionOptions{Policy: Block}),
+	}
+	go func() {
+		source.c <- &mk2driver.Mk2Info{
+			Valid:  true,
+			LEDs:   map[mk2driver.Led]mk2driver.LEDstate{mk2driver.LedMain: mk2driver.LedOn},
+			Events: []mk2driver.Event{{Type: mk2driver.EventBootup}},
+		}
+	}()
+	var wg sync.WaitGroup
+	for _, sub := range subs {
+		wg.Add(1)
+		go func(sub mk2driver.Mk2) {
+			defer wg.Done()
+			e := <-sub.C()
+			assert.Equal(t, mk2driver.LedOn, e.LEDs[mk2driver.LedMain])
+			assert.Equal(t, mk2driver.EventBootup, e.Events[0].Type)
+			e.LEDs[mk2driver.LedMain] = mk2driver.LedOff
+			e.Events[0].Type = mk2driver.EventLockLost
+		}(sub)
+	}
+	wg.Wait()
+
+	info, _ := core.Latest()
+	assert.Equal(t, mk2driver.LedOn, info.LEDs[mk2driver.LedMain])
+}
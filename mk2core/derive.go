@@ -0,0 +1,46 @@
+package mk2core
+
+import (
+	"github.com/diebietse/invertergui/mk2driver"
+)
+
+// Processor is a stage of the core that adds to an update before it is kept
+// as latest update and delivered. The update is owned by the core while it is
+// processed.
+type Processor interface {
+	Process(e *mk2driver.Mk2Info)
+}
+
+// ProcessorFunc is a function used as Processor.
+type ProcessorFunc func(e *mk2driver.Mk2Info)
+
+func (f ProcessorFunc) Process(e *mk2driver.Mk2Info) {
+	f(e)
+}
+
+// Names of the assistant values holding the real AC powers in W, under which
+// the variables of assistant.in_real_power and assistant.out_real_power are
+// polled. When both are polled they are used for the real powers of the
+// derived values.
+const (
+	InRealPowerVar  = "ac_in_real_power"
+	OutRealPowerVar = "ac_out_real_power"
+)
+
+// Derive sets the derived values of e. The core runs it on every update before
+// any other processor.
+func Derive(e *mk2driver.Mk2Info) {
+	d := mk2driver.DerivedValues{
+		InPower:  e.InVoltage * e.InCurrent,
+		OutPower: e.OutVoltage * e.OutCurrent,
+		BatPower: e.BatVoltage * e.BatCurrent,
+	}
+	in, inOk := e.AssistantValues[InRealPowerVar]
+	out, outOk := e.AssistantValues[OutRealPowerVar]
+	d.GridPower, d.Losses = d.InPower, d.InPower-d.OutPower-d.BatPower
+	if inOk && outOk {
+		d.InRealPower, d.OutRealPower, d.RealPower = in, out, true
+		d.GridPower, d.Losses = in, in-out-d.BatPower
+	}
+	e.Derived = d
+}
@@ -0,0 +1,78 @@
+package mk2core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2driver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDerive(t *testing.T) {
+	e := &mk2driver.Mk2Info{
+		InVoltage:  230,
+		InCurrent:  2,
+		OutVoltage: 230,
+		OutCurrent: 1.5,
+		BatVoltage: 25,
+		BatCurrent: 4,
+	}
+	Derive(e)
+	assert.Equal(t, mk2driver.DerivedValues{
+		InPower:   460,
+		OutPower:  345,
+		BatPower:  100,
+		GridPower: 460,
+		Losses:    15,
+	}, e.Derived)
+
+	// Feeding back to the grid while discharging the battery.
+	e.InCurrent = -1
+	e.BatCurrent = -20
+	Derive(e)
+	assert.InDelta(t, -230, e.Derived.GridPower, 1e-9)
+	assert.InDelta(t, -500, e.Derived.BatPower, 1e-9)
+	assert.InDelta(t, -75, e.Derived.Losses, 1e-9)
+
+	e.AssistantValues = map[string]float64{InRealPowerVar: -220, OutRealPowerVar: 300}
+	Derive(e)
+	assert.True(t, e.Derived.RealPower)
+	assert.Equal(t, -220.0, e.Derived.InRealPower)
+	assert.Equal(t, 300.0, e.Derived.OutRealPower)
+	assert.Equal(t, -220.0, e.Derived.GridPower)
+	assert.Equal(t, -20.0, e.Derived.Losses)
+
+	// Both real powers are needed.
+	delete(e.AssistantValues, OutRealPowerVar)
+	Derive(e)
+	assert.False(t, e.Derived.RealPower)
+	assert.InDelta(t, -230, e.Derived.GridPower, 1e-9)
+}
+
+func TestProcessors(t *testing.T) {
+	source := newFakeSource()
+	var order []string
+	core := NewCore(source,
+		ProcessorFunc(func(e *mk2driver.Mk2Info) {
+			order = append(order, "first")
+			// Derived values are set before the processors run.
+			e.ChargeState = e.Derived.BatPower
+		}),
+		ProcessorFunc(func(e *mk2driver.Mk2Info) { order = append(order, "second") }),
+	)
+	defer core.Close()
+
+	sub := core.NewSubscription()
+	e := receive(t, source, sub)
+	source.c <- &mk2driver.Mk2Info{Valid: true, BatVoltage: 12, BatCurrent: 2}
+	require.Eventually(t, func() bool {
+		info, _ := core.Latest()
+		return info != nil && info.ChargeState == 24
+	}, 5*time.Second, time.Millisecond)
+	assert.True(t, e.Valid)
+
+	core.Close()
+	assert.Equal(t, "first", order[0])
+	assert.Equal(t, "second", order[1])
+}
@@ -0,0 +1,272 @@
+package mk2core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2driver"
+)
+
+// MaxEnergyGap is the longest time between two samples that is integrated.
+// Energy is not counted across longer gaps, such as while invertergui was not
+// running or the device was not reachable.
+const MaxEnergyGap = 30 * time.Second
+
+// Number of days kept with their own totals, older days are only counted in
+// their month.
+const energyDays = 400
+
+// Formats of the day and month of the energy periods, in local time.
+const (
+	dayFormat   = "2006-01-02"
+	monthFormat = "2006-01"
+)
+
+// EnergyPeriod is the energy counted in one day or month.
+type EnergyPeriod struct {
+	Period string                   `json:"period"`
+	Energy mk2driver.EnergyCounters `json:"energy"`
+}
+
+// EnergySource is implemented by energy counters that can be queried.
+type EnergySource interface {
+	// Total returns the energy since the counters were first started.
+	Total() mk2driver.EnergyCounters
+	// Days returns the energy of each day, oldest first.
+	Days() []EnergyPeriod
+	// Months returns the energy of each month, oldest first.
+	Months() []EnergyPeriod
+}
+
+// energyState is what the EnergyIntegrator keeps in its state file.
+type energyState struct {
+	Total  mk2driver.EnergyCounters            `json:"total"`
+	Days   map[string]mk2driver.EnergyCounters `json:"days"`
+	Months map[string]mk2driver.EnergyCounters `json:"months"`
+}
+
+// powerIntegrator integrates the power of successive samples with the
+// trapezoidal rule.
+type powerIntegrator struct {
+	last  time.Time
+	power float64
+}
+
+// step returns the energy in kWh of positive and of negative power, as a
+// positive value, between the previous sample and a sample of power W taken
+// at t. When the power changes sign it is split where the line between the
+// samples crosses zero. Samples that are not newer than the previous one are
+// ignored and do not change the start of the next step.
+func (p *powerIntegrator) step(t time.Time, power float64) (positive, negative float64) {
+	if t.IsZero() || !t.After(p.last) {
+		return 0, 0
+	}
+	if !p.last.IsZero() && t.Sub(p.last) <= MaxEnergyGap {
+		hours := t.Sub(p.last).Hours()
+		if (p.power < 0) != (power < 0) {
+			// The part of the step before the crossing.
+			before := p.power / (p.power - power)
+			positive, negative = splitEnergy(p.power/2*before*hours/1000, power/2*(1-before)*hours/1000)
+		} else {
+			positive, negative = splitEnergy((p.power+power)/2*hours/1000, 0)
+		}
+	}
+	p.last, p.power = t, power
+	return positive, negative
+}
+
+// splitEnergy sums the positive and the negative energy of a and b.
+func splitEnergy(a, b float64) (positive, negative float64) {
+	for _, kWh := range []float64{a, b} {
+		if kWh >= 0 {
+			positive += kWh
+		} else {
+			negative -= kWh
+		}
+	}
+	return positive, negative
+}
+
+// EnergyIntegrator is a Processor that counts the grid, AC output and battery
+// energy of the updates from the derived powers and their sample times. The
+// counters are kept in a state file so that they survive restarts.
+//
+// The grid and AC output energy are only counted while the real powers are
+// known, as the apparent powers would count VAh as kWh.
+type EnergyIntegrator struct {
+	path string
+
+	// Only changed by Process, under lock so that the counters can be
+	// queried and saved.
+	lock  sync.Mutex
+	state energyState
+	dirty bool
+
+	grid powerIntegrator
+	out  powerIntegrator
+	bat  powerIntegrator
+}
+
+// NewEnergyIntegrator returns an EnergyIntegrator that restores its counters
+// from the state file at path. A missing state file starts the counters at
+// zero, an empty path keeps them in memory only.
+func NewEnergyIntegrator(path string) (*EnergyIntegrator, error) {
+	i := &EnergyIntegrator{
+		path: path,
+		state: energyState{
+			Days:   map[string]mk2driver.EnergyCounters{},
+			Months: map[string]mk2driver.EnergyCounters{},
+		},
+	}
+	if path == "" {
+		return i, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return i, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &i.state); err != nil {
+		return nil, fmt.Errorf("invalid energy state file %s: %v", path, err)
+	}
+	if i.state.Days == nil {
+		i.state.Days = map[string]mk2driver.EnergyCounters{}
+	}
+	if i.state.Months == nil {
+		i.state.Months = map[string]mk2driver.EnergyCounters{}
+	}
+	return i, nil
+}
+
+// Process counts the energy since the previous samples and sets the energy
+// values of e.
+func (i *EnergyIntegrator) Process(e *mk2driver.Mk2Info) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if e.Valid {
+		ac, dc := e.SampleTimes.AC, e.SampleTimes.DC
+		if e.Derived.RealPower {
+			imported, exported := i.grid.step(ac, e.Derived.GridPower)
+			i.add(ac, mk2driver.EnergyCounters{GridImport: imported, GridExport: exported})
+			output, _ := i.out.step(ac, e.Derived.OutRealPower)
+			i.add(ac, mk2driver.EnergyCounters{ACOutput: output})
+		} else {
+			// The next real power sample starts a new step.
+			i.grid, i.out = powerIntegrator{}, powerIntegrator{}
+		}
+		charged, discharged := i.bat.step(dc, e.Derived.BatPower)
+		i.add(dc, mk2driver.EnergyCounters{BatCharge: charged, BatDischarge: discharged})
+	}
+	now := e.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+	e.Energy = mk2driver.EnergyValues{
+		Total: i.state.Total,
+		Today: i.state.Days[now.Local().Format(dayFormat)],
+	}
+}
+
+// add counts energy sampled at t, under lock.
+func (i *EnergyIntegrator) add(t time.Time, energy mk2driver.EnergyCounters) {
+	if energy == (mk2driver.EnergyCounters{}) {
+		return
+	}
+	t = t.Local()
+	day, month := t.Format(dayFormat), t.Format(monthFormat)
+	i.state.Total.Add(energy)
+	dayTotal, ok := i.state.Days[day]
+	dayTotal.Add(energy)
+	i.state.Days[day] = dayTotal
+	monthTotal := i.state.Months[month]
+	monthTotal.Add(energy)
+	i.state.Months[month] = monthTotal
+	if !ok && len(i.state.Days) > energyDays {
+		days := sortedPeriods(i.state.Days)
+		for _, old := range days[:len(days)-energyDays] {
+			delete(i.state.Days, old.Period)
+		}
+	}
+	i.dirty = true
+}
+
+func (i *EnergyIntegrator) Total() mk2driver.EnergyCounters {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.state.Total
+}
+
+func (i *EnergyIntegrator) Days() []EnergyPeriod {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return sortedPeriods(i.state.Days)
+}
+
+func (i *EnergyIntegrator) Months() []EnergyPeriod {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return sortedPeriods(i.state.Months)
+}
+
+func sortedPeriods(periods map[string]mk2driver.EnergyCounters) []EnergyPeriod {
+	sorted := make([]EnergyPeriod, 0, len(periods))
+	for period, energy := range periods {
+		sorted = append(sorted, EnergyPeriod{Period: period, Energy: energy})
+	}
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].Period < sorted[b].Period })
+	return sorted
+}
+
+// Save writes the counters to the state file if they changed since they were
+// last saved. The file is replaced atomically so that a crash leaves either
+// the old or the new counters.
+func (i *EnergyIntegrator) Save() error {
+	if i.path == "" {
+		return nil
+	}
+	i.lock.Lock()
+	if !i.dirty {
+		i.lock.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(i.state)
+	i.dirty = false
+	i.lock.Unlock()
+	if err == nil {
+		err = writeFileAtomic(i.path, data)
+	}
+	if err != nil {
+		i.lock.Lock()
+		i.dirty = true
+		i.lock.Unlock()
+	}
+	return err
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
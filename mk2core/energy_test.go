@@ -0,0 +1,220 @@
+package mk2core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2driver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Returns a valid update with the given derived real powers sampled at t.
+func energyUpdate(t time.Time, grid, out, bat float64) *mk2driver.Mk2Info {
+	return &mk2driver.Mk2Info{
+		Valid:       true,
+		Derived:     mk2driver.DerivedValues{GridPower: grid, OutRealPower: out, RealPower: true, BatPower: bat},
+		Timestamp:   t,
+		SampleTimes: mk2driver.SampleTimes{AC: t, DC: t},
+	}
+}
+
+func TestEnergyIntegrator(t *testing.T) {
+	energy, err := NewEnergyIntegrator("")
+	require.NoError(t, err)
+
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	// 1 kW from the grid and 2 kW to the battery for an hour, 10 s steps.
+	for s := 0; s <= 3600; s += 10 {
+		energy.Process(energyUpdate(start.Add(time.Duration(s)*time.Second), 1000, 500, 2000))
+	}
+	// Feeding back and discharging for another hour.
+	for s := 3600; s <= 7200; s += 10 {
+		energy.Process(energyUpdate(start.Add(time.Duration(s)*time.Second), -500, 500, -1000))
+	}
+
+	total := energy.Total()
+	// The step between the two hours ramps from one power to the other.
+	assert.InDelta(t, 1.0, total.GridImport, 0.01)
+	assert.InDelta(t, 0.5, total.GridExport, 0.01)
+	assert.InDelta(t, 1.0, total.ACOutput, 0.01)
+	assert.InDelta(t, 2.0, total.BatCharge, 0.01)
+	assert.InDelta(t, 1.0, total.BatDischarge, 0.01)
+
+	days := energy.Days()
+	require.Len(t, days, 1)
+	assert.Equal(t, "2024-06-01", days[0].Period)
+	assert.Equal(t, total, days[0].Energy)
+	months := energy.Months()
+	require.Len(t, months, 1)
+	assert.Equal(t, "2024-06", months[0].Period)
+
+	e := energyUpdate(start.Add(7200*time.Second), -500, 500, -1000)
+	energy.Process(e)
+	assert.Equal(t, total, e.Energy.Total)
+	assert.Equal(t, total, e.Energy.Today)
+}
+
+func TestEnergyIntegratorApparentPower(t *testing.T) {
+	energy, err := NewEnergyIntegrator("")
+	require.NoError(t, err)
+
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	for s := 0; s <= 20; s += 10 {
+		e := energyUpdate(start.Add(time.Duration(s)*time.Second), 1000, 500, 1000)
+		e.Derived = mk2driver.DerivedValues{InPower: 1000, OutPower: 500, GridPower: 1000, BatPower: 1000}
+		energy.Process(e)
+	}
+	// Only the battery energy is counted from apparent powers.
+	total := energy.Total()
+	assert.Zero(t, total.GridImport)
+	assert.Zero(t, total.ACOutput)
+	assert.InDelta(t, 1000*20/3600.0/1000, total.BatCharge, 1e-12)
+
+	// Counting starts again with the real powers, not from the last sample
+	// with real powers.
+	energy.Process(energyUpdate(start.Add(30*time.Second), 1000, 500, 1000))
+	energy.Process(energyUpdate(start.Add(40*time.Second), 1000, 500, 1000))
+	assert.InDelta(t, 1000*10/3600.0/1000, energy.Total().GridImport, 1e-12)
+}
+
+func TestEnergyIntegratorOutOfOrder(t *testing.T) {
+	energy, err := NewEnergyIntegrator("")
+	require.NoError(t, err)
+
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	energy.Process(energyUpdate(start.Add(10*time.Second), 0, 0, 1000))
+	// An older sample, which is ignored.
+	energy.Process(energyUpdate(start, 0, 0, 5000))
+	energy.Process(energyUpdate(start.Add(20*time.Second), 0, 0, 1000))
+
+	// Only the 10 s from the first to the last sample at 1 kW.
+	assert.InDelta(t, 1000*10/3600.0/1000, energy.Total().BatCharge, 1e-12)
+}
+
+func TestEnergyIntegratorSignChange(t *testing.T) {
+	energy, err := NewEnergyIntegrator("")
+	require.NoError(t, err)
+
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	// From importing 3 kW to exporting 1 kW in 20 s, crossing zero after 15 s.
+	energy.Process(energyUpdate(start, 3000, 0, -1000))
+	energy.Process(energyUpdate(start.Add(20*time.Second), -1000, 0, 3000))
+
+	total := energy.Total()
+	kWh := func(watts, seconds float64) float64 { return watts / 2 * seconds / 3600 / 1000 }
+	assert.InDelta(t, kWh(3000, 15), total.GridImport, 1e-12)
+	assert.InDelta(t, kWh(1000, 5), total.GridExport, 1e-12)
+	assert.InDelta(t, kWh(3000, 15), total.BatCharge, 1e-12)
+	assert.InDelta(t, kWh(1000, 5), total.BatDischarge, 1e-12)
+	assert.Zero(t, total.ACOutput)
+}
+
+func TestEnergyIntegratorSamples(t *testing.T) {
+	energy, err := NewEnergyIntegrator("")
+	require.NoError(t, err)
+
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	e := energyUpdate(start, 3600, 0, 0)
+	energy.Process(e)
+	// Repeated samples are counted once.
+	e = energyUpdate(start.Add(time.Second), 3600, 0, 0)
+	energy.Process(e)
+	energy.Process(e)
+	assert.InDelta(t, 0.001, energy.Total().GridImport, 1e-9)
+
+	// Gaps and invalid updates are not counted.
+	energy.Process(energyUpdate(start.Add(time.Minute), 3600, 0, 0))
+	invalid := energyUpdate(start.Add(time.Minute+time.Second), 3600, 0, 0)
+	invalid.Valid = false
+	energy.Process(invalid)
+	assert.InDelta(t, 0.001, energy.Total().GridImport, 1e-9)
+	assert.InDelta(t, 0.001, invalid.Energy.Total.GridImport, 1e-9)
+}
+
+func TestEnergyIntegratorDays(t *testing.T) {
+	energy, err := NewEnergyIntegrator("")
+	require.NoError(t, err)
+
+	midnight := time.Date(2024, 7, 1, 0, 0, 0, 0, time.Local)
+	for s := -5; s <= 5; s++ {
+		energy.Process(energyUpdate(midnight.Add(time.Duration(s)*time.Second), 3600, 0, 0))
+	}
+	days := energy.Days()
+	require.Len(t, days, 2)
+	assert.Equal(t, "2024-06-30", days[0].Period)
+	assert.InDelta(t, 0.004, days[0].Energy.GridImport, 1e-9)
+	assert.Equal(t, "2024-07-01", days[1].Period)
+	assert.InDelta(t, 0.006, days[1].Energy.GridImport, 1e-9)
+	months := energy.Months()
+	require.Len(t, months, 2)
+	assert.Equal(t, "2024-06", months[0].Period)
+	assert.Equal(t, "2024-07", months[1].Period)
+
+	// Old days are dropped, their months are kept.
+	for d := 1; d <= energyDays+1; d++ {
+		day := midnight.AddDate(0, 0, d)
+		energy.Process(energyUpdate(day, 3600, 0, 0))
+		energy.Process(energyUpdate(day.Add(time.Second), 3600, 0, 0))
+	}
+	days = energy.Days()
+	assert.Len(t, days, energyDays)
+	assert.Equal(t, midnight.AddDate(0, 0, 2).Format(dayFormat), days[0].Period)
+	assert.Equal(t, "2024-06", energy.Months()[0].Period)
+}
+
+func TestEnergyIntegratorState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "energy.json")
+	energy, err := NewEnergyIntegrator(path)
+	require.NoError(t, err)
+	// Nothing to save yet.
+	require.NoError(t, energy.Save())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	energy.Process(energyUpdate(start, 3600, 0, -3600))
+	energy.Process(energyUpdate(start.Add(time.Second), 3600, 0, -3600))
+	require.NoError(t, energy.Save())
+
+	restored, err := NewEnergyIntegrator(path)
+	require.NoError(t, err)
+	assert.Equal(t, energy.Total(), restored.Total())
+	assert.Equal(t, energy.Days(), restored.Days())
+	assert.Equal(t, energy.Months(), restored.Months())
+
+	// The restored counters keep counting.
+	restored.Process(energyUpdate(start.Add(time.Hour), 3600, 0, 0))
+	restored.Process(energyUpdate(start.Add(time.Hour+time.Second), 3600, 0, 0))
+	assert.InDelta(t, 0.002, restored.Total().GridImport, 1e-9)
+
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o644))
+	_, err = NewEnergyIntegrator(path)
+	assert.Error(t, err)
+}
+
+func TestEnergyIntegratorCore(t *testing.T) {
+	energy, err := NewEnergyIntegrator("")
+	require.NoError(t, err)
+	source := newFakeSource()
+	core := NewCore(source, energy)
+	defer core.Close()
+	sub := core.Subscribe(context.Background(), SubscriptionOptions{Buffer: 2})
+
+	start := time.Now()
+	for _, at := range []time.Time{start, start.Add(time.Second)} {
+		source.c <- &mk2driver.Mk2Info{
+			Valid: true,
+			// The grid energy is counted from the derived real power.
+			AssistantValues: map[string]float64{InRealPowerVar: 3600, OutRealPowerVar: 0},
+			Timestamp:       at,
+			SampleTimes:     mk2driver.SampleTimes{AC: at, DC: at},
+		}
+	}
+	<-sub.C()
+	e := <-sub.C()
+	assert.InDelta(t, 0.001, e.Energy.Total.GridImport, 1e-9)
+}
@@ -0,0 +1,35 @@
+package mk2core
+
+import (
+	"sort"
+
+	"github.com/diebietse/invertergui/mk2driver"
+)
+
+// Fields maps the names of the numeric fields of an update to functions
+// returning their values, so that updates can be filtered by field.
+var Fields = map[string]func(*mk2driver.Mk2Info) float64{
+	"bat_voltage":          func(i *mk2driver.Mk2Info) float64 { return i.BatVoltage },
+	"bat_current":          func(i *mk2driver.Mk2Info) float64 { return i.BatCurrent },
+	"bat_charger_current":  func(i *mk2driver.Mk2Info) float64 { return i.BatChargerCurrent },
+	"bat_inverter_current": func(i *mk2driver.Mk2Info) float64 { return i.BatInverterCurrent },
+	"bat_temperature":      func(i *mk2driver.Mk2Info) float64 { return i.BatTemperature },
+	"in_voltage":           func(i *mk2driver.Mk2Info) float64 { return i.InVoltage },
+	"in_current":           func(i *mk2driver.Mk2Info) float64 { return i.InCurrent },
+	"in_frequency":         func(i *mk2driver.Mk2Info) float64 { return i.InFrequency },
+	"out_voltage":          func(i *mk2driver.Mk2Info) float64 { return i.OutVoltage },
+	"out_current":          func(i *mk2driver.Mk2Info) float64 { return i.OutCurrent },
+	"out_frequency":        func(i *mk2driver.Mk2Info) float64 { return i.OutFrequency },
+	"inverter_period":      func(i *mk2driver.Mk2Info) float64 { return i.InverterPeriod },
+	"charge_state":         func(i *mk2driver.Mk2Info) float64 { return i.ChargeState },
+}
+
+// FieldNames returns the names of all Fields sorted.
+func FieldNames() []string {
+	names := make([]string, 0, len(Fields))
+	for name := range Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,101 @@
+package mk2core
+
+import (
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2driver"
+)
+
+// Filter decides which updates are delivered to a subscription, an update
+// that is not delivered is skipped. The events of skipped updates are added to
+// the next delivered update. The zero value delivers every update.
+type Filter struct {
+	// MinInterval is the minimum time between delivered updates.
+	MinInterval time.Duration
+	// OnChange only delivers updates that changed since the last delivered
+	// update: in a field of Fields by more than its deadband, or in its
+	// validity, LEDs, status, device state, switches, ESS state, active input,
+	// device, assistant values, or by reporting events or errors.
+	OnChange bool
+	// Deadbands holds the change of a field by name that is ignored by
+	// OnChange, fields without a deadband change on any difference.
+	Deadbands map[string]float64
+	// MaxInterval delivers an unchanged update once this long passed since the
+	// last delivered update, zero to never deliver unchanged updates.
+	MaxInterval time.Duration
+}
+
+// filterState holds what a filter compares updates to. Only used by the core.
+type filterState struct {
+	Filter
+	// Last delivered update and its time.
+	last     *mk2driver.Mk2Info
+	lastTime time.Time
+	// Events of the updates skipped since the last delivered one.
+	events []mk2driver.Event
+}
+
+// update returns the copy of e to deliver, false if e is skipped. The core
+// does not change e after delivering it, so it can be kept to compare to.
+func (f *filterState) update(e *mk2driver.Mk2Info) (*mk2driver.Mk2Info, bool) {
+	if f.MinInterval == 0 && !f.OnChange {
+		return e.Copy(), true
+	}
+	now := e.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if f.last != nil && !f.due(e, now.Sub(f.lastTime)) {
+		f.events = append(f.events, e.Events...)
+		return nil, false
+	}
+	f.last = e
+	f.lastTime = now
+	update := e.Copy()
+	if len(f.events) != 0 {
+		update.Events = append(f.events, update.Events...)
+		f.events = nil
+	}
+	return update, true
+}
+
+// Reports whether e is delivered, since is the time since the last delivered
+// update.
+func (f *filterState) due(e *mk2driver.Mk2Info, since time.Duration) bool {
+	if since < f.MinInterval {
+		return false
+	}
+	if !f.OnChange || (f.MaxInterval > 0 && since >= f.MaxInterval) {
+		return true
+	}
+	return f.changed(e)
+}
+
+func (f *filterState) changed(e *mk2driver.Mk2Info) bool {
+	last := f.last
+	if len(e.Events) != 0 || len(e.Errors) != 0 || len(f.events) != 0 {
+		return true
+	}
+	for name, value := range Fields {
+		if math.Abs(value(e)-value(last)) > f.Deadbands[name] {
+			return true
+		}
+	}
+	switches, lastSwitches := e.Switches, last.Switches
+	switches.Timestamp, lastSwitches.Timestamp = time.Time{}, time.Time{}
+	ess, lastESS := e.ESS, last.ESS
+	ess.Timestamp, lastESS.Timestamp = time.Time{}, time.Time{}
+	return e.Valid != last.Valid ||
+		e.Status != last.Status ||
+		e.DeviceState.State != last.DeviceState.State ||
+		e.DeviceState.ChargeState != last.DeviceState.ChargeState ||
+		switches != lastSwitches ||
+		ess != lastESS ||
+		e.ActiveInput != last.ActiveInput ||
+		e.Device != last.Device ||
+		!reflect.DeepEqual(e.LEDs, last.LEDs) ||
+		!reflect.DeepEqual(e.AssistantValues, last.AssistantValues) ||
+		!reflect.DeepEqual(e.Unsupported, last.Unsupported)
+}
@@ -0,0 +1,164 @@
+package mk2core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2driver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var filterStart = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// Returns a valid update sent at offset after filterStart.
+func update(offset time.Duration, change func(*mk2driver.Mk2Info)) *mk2driver.Mk2Info {
+	e := &mk2driver.Mk2Info{
+		Valid:      true,
+		BatVoltage: 12,
+		LEDs:       map[mk2driver.Led]mk2driver.LEDstate{mk2driver.LedMain: mk2driver.LedOn},
+		Timestamp:  filterStart.Add(offset),
+	}
+	if change != nil {
+		change(e)
+	}
+	return e
+}
+
+// Returns which of the updates pass the filter.
+func filtered(filter Filter, updates ...*mk2driver.Mk2Info) []bool {
+	state := &filterState{Filter: filter}
+	passed := make([]bool, len(updates))
+	for i, e := range updates {
+		_, passed[i] = state.update(e)
+	}
+	return passed
+}
+
+func TestFilter(t *testing.T) {
+	voltage := func(v float64) func(*mk2driver.Mk2Info) {
+		return func(e *mk2driver.Mk2Info) { e.BatVoltage = v }
+	}
+	tests := []struct {
+		name    string
+		filter  Filter
+		updates []*mk2driver.Mk2Info
+		passed  []bool
+	}{
+		{
+			name:    "no filter",
+			updates: []*mk2driver.Mk2Info{update(0, nil), update(0, nil)},
+			passed:  []bool{true, true},
+		},
+		{
+			name:   "min interval",
+			filter: Filter{MinInterval: time.Second},
+			updates: []*mk2driver.Mk2Info{
+				update(0, nil), update(500*time.Millisecond, voltage(13)), update(time.Second, nil), update(1500*time.Millisecond, nil),
+			},
+			passed: []bool{true, false, true, false},
+		},
+		{
+			name:   "on change",
+			filter: Filter{OnChange: true},
+			updates: []*mk2driver.Mk2Info{
+				update(0, nil),
+				update(time.Second, nil),
+				update(2*time.Second, voltage(12.01)),
+				update(3*time.Second, voltage(12.01)),
+				update(4*time.Second, func(e *mk2driver.Mk2Info) {
+					e.BatVoltage = 12.01
+					e.LEDs[mk2driver.LedMain] = mk2driver.LedBlink
+				}),
+			},
+			passed: []bool{true, false, true, false, true},
+		},
+		{
+			name:   "deadband",
+			filter: Filter{OnChange: true, Deadbands: map[string]float64{"bat_voltage": 0.1}},
+			updates: []*mk2driver.Mk2Info{
+				update(0, nil),
+				update(time.Second, voltage(12.05)),
+				update(2*time.Second, voltage(12.1)),
+				update(3*time.Second, voltage(12.15)),
+				update(4*time.Second, voltage(12.15)),
+			},
+			passed: []bool{true, false, false, true, false},
+		},
+		{
+			name:   "max interval",
+			filter: Filter{OnChange: true, MaxInterval: 2 * time.Second},
+			updates: []*mk2driver.Mk2Info{
+				update(0, nil), update(time.Second, nil), update(2*time.Second, nil), update(3*time.Second, nil),
+			},
+			passed: []bool{true, false, true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.passed, filtered(tt.filter, tt.updates...))
+		})
+	}
+}
+
+func TestFilterTransitions(t *testing.T) {
+	changes := map[string]func(*mk2driver.Mk2Info){
+		"charger stage": func(e *mk2driver.Mk2Info) { e.Status.ChargerStage = mk2driver.ChargerFloat },
+		"invalid":       func(e *mk2driver.Mk2Info) { e.Valid = false },
+		"relay":         func(e *mk2driver.Mk2Info) { e.Switches.Relay = true },
+		"device state":  func(e *mk2driver.Mk2Info) { e.DeviceState.State = mk2driver.VEBusState(2) },
+		"active input":  func(e *mk2driver.Mk2Info) { e.ActiveInput = mk2driver.InputAC2 },
+		"event":         func(e *mk2driver.Mk2Info) { e.Events = []mk2driver.Event{{Type: mk2driver.EventBootup}} },
+		"assistant":     func(e *mk2driver.Mk2Info) { e.AssistantValues = map[string]float64{"setpoint": 1} },
+	}
+	for name, change := range changes {
+		assert.Equal(t, []bool{true, true}, filtered(Filter{OnChange: true}, update(0, nil), update(time.Second, change)), name)
+	}
+	// Only the sample times changed.
+	assert.Equal(t, []bool{true, false}, filtered(Filter{OnChange: true}, update(0, nil), update(time.Second, func(e *mk2driver.Mk2Info) {
+		e.Switches.Timestamp = filterStart
+		e.SampleTimes.DC = filterStart
+	})))
+}
+
+// Events of skipped updates are delivered with the next update.
+func TestFilterEvents(t *testing.T) {
+	state := &filterState{Filter: Filter{MinInterval: time.Second}}
+	_, ok := state.update(update(0, nil))
+	require.True(t, ok)
+	_, ok = state.update(update(100*time.Millisecond, func(e *mk2driver.Mk2Info) {
+		e.Events = []mk2driver.Event{{Type: mk2driver.EventLockLost}}
+	}))
+	require.False(t, ok)
+	e, ok := state.update(update(time.Second, func(e *mk2driver.Mk2Info) {
+		e.Events = []mk2driver.Event{{Type: mk2driver.EventLockAcquired}}
+	}))
+	require.True(t, ok)
+	assert.Equal(t, []mk2driver.Event{{Type: mk2driver.EventLockLost}, {Type: mk2driver.EventLockAcquired}}, e.Events)
+
+	e, ok = state.update(update(2*time.Second, nil))
+	require.True(t, ok)
+	assert.Empty(t, e.Events)
+}
+
+func TestSubscriptionFilter(t *testing.T) {
+	source := newFakeSource()
+	core := NewCore(source)
+	defer core.Close()
+
+	sub := core.Subscribe(context.Background(), SubscriptionOptions{Buffer: 4, Filter: Filter{OnChange: true}})
+	for i := 0; i < 3; i++ {
+		source.c <- update(time.Duration(i)*time.Second, nil)
+	}
+	source.c <- update(3*time.Second, func(e *mk2driver.Mk2Info) { e.BatVoltage = 13 })
+	require.Eventually(t, func() bool {
+		stats := core.SubscriptionStats()
+		return stats[0].Delivered+stats[0].Filtered == 4
+	}, 5*time.Second, time.Millisecond)
+
+	stats := core.SubscriptionStats()[0]
+	assert.Equal(t, uint64(2), stats.Delivered)
+	assert.Equal(t, uint64(2), stats.Filtered)
+	assert.Equal(t, []float64{12, 13}, queued(sub))
+}
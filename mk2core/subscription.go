@@ -0,0 +1,219 @@
+package mk2core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2driver"
+)
+
+// DropPolicy decides what happens to an update when the buffer of a
+// subscription is full.
+type DropPolicy int
+
+const (
+	// DropNewest drops the new update.
+	DropNewest DropPolicy = iota
+	// DropOldest drops the oldest buffered update to make room for the new
+	// one.
+	DropOldest
+	// Block waits up to the timeout of the subscription for room and drops the
+	// new update if there is none by then. While it waits the other
+	// subscriptions are delivered to, but the next update is not.
+	Block
+	// LatestOnly only keeps the most recent update, as a buffer of one that
+	// drops the oldest update.
+	LatestOnly
+)
+
+// DropPolicyNames maps the names of the drop policies to their values.
+var DropPolicyNames = map[string]DropPolicy{
+	"drop_newest": DropNewest,
+	"drop_oldest": DropOldest,
+	"block":       Block,
+	"latest_only": LatestOnly,
+}
+
+func (p DropPolicy) String() string {
+	for name, policy := range DropPolicyNames {
+		if policy == p {
+			return name
+		}
+	}
+	return fmt.Sprintf("DropPolicy(%d)", int(p))
+}
+
+const defaultBlockTimeout = time.Second
+
+// SubscriptionOptions configures the buffering of a subscription. The zero
+// value is an unbuffered subscription that drops updates it is not ready to
+// receive.
+type SubscriptionOptions struct {
+	// Name identifies the subscription in its statistics.
+	Name string
+	// Buffer is the number of updates buffered for the subscriber, at least
+	// one for DropOldest.
+	Buffer int
+	Policy DropPolicy
+	// Timeout is how long the Block policy waits, one second if zero.
+	Timeout time.Duration
+	// Filter selects the updates delivered to the subscription.
+	Filter Filter
+}
+
+// SubscriptionStats holds the delivery counters of a subscription.
+type SubscriptionStats struct {
+	Name   string `json:"name"`
+	Policy string `json:"policy"`
+	Buffer int    `json:"buffer"`
+	// Queued is the number of updates waiting in the buffer.
+	Queued int `json:"queued"`
+	// Delivered counts the updates handed to the subscription. Updates later
+	// dropped from its buffer are counted in Dropped as well.
+	Delivered uint64 `json:"delivered"`
+	Dropped   uint64 `json:"dropped"`
+	// Filtered counts the updates skipped by the filter of the subscription.
+	Filtered uint64 `json:"filtered"`
+}
+
+// StatsSource is implemented by cores that report the statistics of their
+// subscriptions.
+type StatsSource interface {
+	SubscriptionStats() []SubscriptionStats
+}
+
+// Subscribe returns a subscription to the updates of the core buffered as
+// configured by opts. Closing it or cancelling ctx unsubscribes. Its channel is
+// closed once it is unsubscribed or the core stopped.
+func (c *Core) Subscribe(ctx context.Context, opts SubscriptionOptions) mk2driver.Mk2 {
+	if opts.Policy == LatestOnly {
+		opts.Buffer = 1
+	}
+	if opts.Buffer < 0 {
+		opts.Buffer = 0
+	}
+	// There is no oldest update to drop without a buffer.
+	if opts.Policy == DropOldest && opts.Buffer == 0 {
+		opts.Buffer = 1
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultBlockTimeout
+	}
+	sub := &subscription{
+		core: c,
+		opts: opts,
+		send: make(chan *mk2driver.Mk2Info, opts.Buffer),
+		done: make(chan struct{}),
+	}
+	sub.filter.Filter = opts.Filter
+	select {
+	case c.register <- sub:
+	case <-c.done:
+		close(sub.send)
+		return sub
+	}
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				sub.Close()
+			case <-sub.done:
+			case <-c.done:
+			}
+		}()
+	}
+	return sub
+}
+
+type subscription struct {
+	core *Core
+	opts SubscriptionOptions
+	send chan *mk2driver.Mk2Info
+	// Closed once Close was called.
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// Only used by the core.
+	filter filterState
+
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+	filtered  atomic.Uint64
+}
+
+func (s *subscription) C() chan *mk2driver.Mk2Info {
+	return s.send
+}
+
+// Latest returns the most recent valid update of the core, see Core.Latest.
+func (s *subscription) Latest() (*mk2driver.Mk2Info, time.Duration) {
+	return s.core.Latest()
+}
+
+// Close unsubscribes from the core, which closes the channel of the
+// subscription.
+func (s *subscription) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		select {
+		case s.core.unregister <- s:
+		case <-s.core.done:
+		}
+	})
+}
+
+// Delivers e according to the drop policy. Only called by the core, which is
+// the only sender on the channel.
+func (s *subscription) deliver(e *mk2driver.Mk2Info) {
+	switch s.opts.Policy {
+	case DropOldest, LatestOnly:
+		for {
+			select {
+			case s.send <- e:
+				s.delivered.Add(1)
+				return
+			default:
+			}
+			select {
+			case <-s.send:
+				s.dropped.Add(1)
+			default:
+			}
+		}
+	case Block:
+		timer := time.NewTimer(s.opts.Timeout)
+		defer timer.Stop()
+		select {
+		case s.send <- e:
+			s.delivered.Add(1)
+		case <-timer.C:
+			s.dropped.Add(1)
+		case <-s.done:
+			s.dropped.Add(1)
+		case <-s.core.ctx.Done():
+			s.dropped.Add(1)
+		}
+	default:
+		select {
+		case s.send <- e:
+			s.delivered.Add(1)
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+func (s *subscription) stats() SubscriptionStats {
+	return SubscriptionStats{
+		Name:      s.opts.Name,
+		Policy:    s.opts.Policy.String(),
+		Buffer:    s.opts.Buffer,
+		Queued:    len(s.send),
+		Delivered: s.delivered.Load(),
+		Dropped:   s.dropped.Load(),
+		Filtered:  s.filtered.Load(),
+	}
+}
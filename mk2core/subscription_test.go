@@ -0,0 +1,162 @@
+package mk2core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2driver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Sends updates with the battery voltages 1 to n and waits until the core
+// handled all of them.
+func sendUpdates(t *testing.T, core *Core, source *fakeSource, n int) {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		source.c <- &mk2driver.Mk2Info{Valid: true, BatVoltage: float64(i)}
+	}
+	require.Eventually(t, func() bool {
+		for _, stats := range core.SubscriptionStats() {
+			if stats.Delivered+stats.Dropped < uint64(n) {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, time.Millisecond)
+}
+
+// Returns the battery voltages of the queued updates of sub.
+func queued(sub mk2driver.Mk2) []float64 {
+	var voltages []float64
+	for {
+		select {
+		case e := <-sub.C():
+			voltages = append(voltages, e.BatVoltage)
+		default:
+			return voltages
+		}
+	}
+}
+
+func TestSubscriptionPolicies(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      SubscriptionOptions
+		queued    []float64
+		delivered uint64
+		dropped   uint64
+	}{
+		{
+			name:      "unbuffered",
+			opts:      SubscriptionOptions{},
+			delivered: 0,
+			dropped:   4,
+		},
+		{
+			name:      "drop newest",
+			opts:      SubscriptionOptions{Buffer: 2, Policy: DropNewest},
+			queued:    []float64{1, 2},
+			delivered: 2,
+			dropped:   2,
+		},
+		{
+			name:      "drop oldest",
+			opts:      SubscriptionOptions{Buffer: 2, Policy: DropOldest},
+			queued:    []float64{3, 4},
+			delivered: 4,
+			dropped:   2,
+		},
+		{
+			name:      "drop oldest unbuffered",
+			opts:      SubscriptionOptions{Policy: DropOldest},
+			queued:    []float64{4},
+			delivered: 4,
+			dropped:   3,
+		},
+		{
+			name:      "latest only",
+			opts:      SubscriptionOptions{Buffer: 8, Policy: LatestOnly},
+			queued:    []float64{4},
+			delivered: 4,
+			dropped:   3,
+		},
+		{
+			name:      "block",
+			opts:      SubscriptionOptions{Buffer: 1, Policy: Block, Timeout: 10 * time.Millisecond},
+			queued:    []float64{1},
+			delivered: 1,
+			dropped:   3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := newFakeSource()
+			core := NewCore(source)
+			defer core.Close()
+
+			tt.opts.Name = tt.name
+			sub := core.Subscribe(context.Background(), tt.opts)
+			sendUpdates(t, core, source, 4)
+
+			stats := core.SubscriptionStats()
+			require.Len(t, stats, 1)
+			assert.Equal(t, tt.name, stats[0].Name)
+			assert.Equal(t, len(tt.queued), stats[0].Queued)
+			assert.Equal(t, tt.delivered, stats[0].Delivered)
+			assert.Equal(t, tt.dropped, stats[0].Dropped)
+			assert.Equal(t, tt.queued, queued(sub))
+		})
+	}
+}
+
+func TestSubscriptionBlock(t *testing.T) {
+	source := newFakeSource()
+	core := NewCore(source)
+	defer core.Close()
+
+	blocking := core.Subscribe(context.Background(), SubscriptionOptions{Policy: Block, Timeout: time.Minute})
+	other := core.Subscribe(context.Background(), SubscriptionOptions{Buffer: 1})
+
+	// The blocked update still reaches the other subscription.
+	go func() { source.c <- &mk2driver.Mk2Info{BatVoltage: 1} }()
+	e := <-other.C()
+	assert.Equal(t, 1.0, e.BatVoltage)
+	e = <-blocking.C()
+	assert.Equal(t, 1.0, e.BatVoltage)
+
+	// Closing a blocked subscription drops the update instead of waiting.
+	go func() { source.c <- &mk2driver.Mk2Info{BatVoltage: 2} }()
+	<-other.C()
+	blocking.Close()
+	waitClosed(t, blocking)
+	receive(t, source, other)
+}
+
+func TestSubscriptionStats(t *testing.T) {
+	source := newFakeSource()
+	core := NewCore(source)
+	defer core.Close()
+
+	core.Subscribe(context.Background(), SubscriptionOptions{Name: "web", Policy: LatestOnly})
+	mqtt := core.Subscribe(context.Background(), SubscriptionOptions{Name: "mqtt", Buffer: 16, Policy: DropOldest})
+	require.Eventually(t, func() bool { return len(core.SubscriptionStats()) == 2 }, 5*time.Second, time.Millisecond)
+	assert.Equal(t, []SubscriptionStats{
+		{Name: "mqtt", Policy: "drop_oldest", Buffer: 16},
+		{Name: "web", Policy: "latest_only", Buffer: 1},
+	}, core.SubscriptionStats())
+
+	mqtt.Close()
+	waitClosed(t, mqtt)
+	stats := core.SubscriptionStats()
+	require.Len(t, stats, 1)
+	assert.Equal(t, "web", stats[0].Name)
+}
+
+func TestDropPolicyString(t *testing.T) {
+	for name, policy := range DropPolicyNames {
+		assert.Equal(t, name, policy.String())
+	}
+	assert.Equal(t, "DropPolicy(9)", DropPolicy(9).String())
+}
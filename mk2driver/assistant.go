@@ -0,0 +1,273 @@
+package mk2driver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The RAM variables of loaded assistants follow each other from
+// assistantRAMStart. Each assistant starts with a header variable holding the
+// assistant ID in the high 12 bits and the number of variables that follow in
+// the low 4 bits. A zero header ends the list.
+const (
+	assistantRAMStart = 0x80
+	assistantVarsMask = 0x0f
+	assistantIDShift  = 4
+)
+
+// Number of RAM variables read with one command.
+const maxRAMReadIDs = 6
+
+// AssistantESS is the ID of the ESS assistant.
+const AssistantESS uint16 = 5
+
+// AssistantNames holds the names of known assistant IDs, which can be used
+// instead of the ID in assistant variable references.
+var AssistantNames = map[uint16]string{
+	AssistantESS: "ess",
+}
+
+// Assistant is an assistant loaded in the VE.Bus device.
+type Assistant struct {
+	ID uint16
+	// RAM variable ID of the assistant header.
+	Header byte
+	// Number of RAM variables after the header.
+	Vars int
+}
+
+// Name returns the known name of the assistant or its ID.
+func (a Assistant) Name() string {
+	if name, ok := AssistantNames[a.ID]; ok {
+		return name
+	}
+	return strconv.Itoa(int(a.ID))
+}
+
+// VarID returns the RAM variable ID of the index'th variable of the
+// assistant.
+func (a Assistant) VarID(index int) (byte, bool) {
+	if index < 0 || index >= a.Vars {
+		return 0, false
+	}
+	return a.Header + 1 + byte(index), true
+}
+
+// AssistantVar is an assistant RAM variable that is read in every poll cycle
+// and reported by name in Mk2Info.AssistantValues.
+type AssistantVar struct {
+	Name string
+	// RAM variable ID, zero if the variable is referenced by assistant.
+	ID byte
+	// Assistant ID and index of the variable after its header.
+	AssistantID uint16
+	Index       int
+}
+
+// ParseAssistantVar parses an assistant variable reference of the form
+// name=id, with id the RAM variable ID, or name=assistant:index, with
+// assistant the name or ID of the assistant and index the position of the
+// variable after its header.
+func ParseAssistantVar(s string) (AssistantVar, error) {
+	name, ref, ok := strings.Cut(s, "=")
+	name = strings.TrimSpace(name)
+	ref = strings.TrimSpace(ref)
+	if !ok || name == "" || ref == "" {
+		return AssistantVar{}, fmt.Errorf("invalid assistant variable %q, expected name=id or name=assistant:index", s)
+	}
+	v := AssistantVar{Name: name}
+	assistant, index, relative := strings.Cut(ref, ":")
+	if !relative {
+		id, err := strconv.ParseUint(ref, 0, 8)
+		if err != nil || id < assistantRAMStart {
+			return AssistantVar{}, fmt.Errorf("invalid assistant RAM variable ID %q in %q", ref, s)
+		}
+		v.ID = byte(id)
+		return v, nil
+	}
+	id, err := parseAssistantID(assistant)
+	if err != nil {
+		return AssistantVar{}, fmt.Errorf("%w in %q", err, s)
+	}
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 || i >= assistantVarsMask {
+		return AssistantVar{}, fmt.Errorf("invalid assistant variable index %q in %q", index, s)
+	}
+	v.AssistantID = id
+	v.Index = i
+	return v, nil
+}
+
+func parseAssistantID(s string) (uint16, error) {
+	for id, name := range AssistantNames {
+		if name == s {
+			return id, nil
+		}
+	}
+	id, err := strconv.ParseUint(s, 0, 12)
+	if err != nil {
+		return 0, fmt.Errorf("unknown assistant %q", s)
+	}
+	return uint16(id), nil
+}
+
+// Returns the RAM variable ID of v among the loaded assistants.
+func (v AssistantVar) resolve(assistants []Assistant) (byte, bool) {
+	if v.ID != 0 {
+		return v.ID, true
+	}
+	for _, a := range assistants {
+		if a.ID == v.AssistantID {
+			return a.VarID(v.Index)
+		}
+	}
+	return 0, false
+}
+
+// Assistants returns the assistants found on the device, nil until the device
+// configuration was read.
+func (m *mk2Ser) Assistants() []Assistant {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	return append([]Assistant(nil), m.assistants...)
+}
+
+// MonitorAssistantVars sets the assistant variables read in every poll cycle.
+// Variables that reference an assistant that is not loaded are skipped.
+func (m *mk2Ser) MonitorAssistantVars(vars []AssistantVar) {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	m.assistantVars = append([]AssistantVar(nil), vars...)
+	m.readsChanged = true
+}
+
+// WriteAssistantVar writes value to the assistant RAM variable v. It returns
+// ErrNotSupported if v references an assistant that is not loaded.
+func (m *mk2Ser) WriteAssistantVar(v AssistantVar, value int16) error {
+	m.stateLock.Lock()
+	id, ok := v.resolve(m.assistants)
+	m.stateLock.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: assistant variable %s not found", ErrNotSupported, v.Name)
+	}
+	return m.writeRAMVar(id, uint16(value))
+}
+
+// Request the assistant header at RAM variable id.
+func (m *mk2Ser) reqAssistantHeader(id byte) {
+	m.assistantNext = id
+	m.assistantPending = true
+	m.sendCommand(readRAMCommand([]byte{id}))
+}
+
+// Decode an assistant header and request the next one, or start the poll
+// cycle once all assistants were found.
+func (m *mk2Ser) assistantDecode(frame []byte) {
+	m.assistantPending = false
+	if len(frame) < 3 {
+		m.addError(newFrameError(ErrShortFrame, frame))
+		m.assistantsDiscovered()
+		return
+	}
+	header := uint16(getUnsigned16(frame[1:3]))
+	if header == 0 {
+		m.assistantsDiscovered()
+		return
+	}
+	a := Assistant{
+		ID:     header >> assistantIDShift,
+		Header: m.assistantNext,
+		Vars:   int(header & assistantVarsMask),
+	}
+	m.stateLock.Lock()
+	m.assistants = append(m.assistants, a)
+	m.stateLock.Unlock()
+	logrus.Infof("VE.Bus device has assistant %s with %d RAM variables from 0x%02x", a.Name(), a.Vars, a.Header+1)
+
+	next := int(a.Header) + 1 + a.Vars
+	if next > 0xff {
+		m.assistantsDiscovered()
+		return
+	}
+	m.reqAssistantHeader(byte(next))
+}
+
+// Ends the assistant discovery and starts the poll cycle.
+func (m *mk2Ser) assistantsDiscovered() {
+	m.assistantPending = false
+	m.assistantsDone = true
+	m.stateLock.Lock()
+	m.readsChanged = true
+	m.stateLock.Unlock()
+	m.startCycle()
+}
+
+// Handles an assistant header request that was not answered before the next
+// poll cycle. The assistants found so far are kept.
+func (m *mk2Ser) assistantTimeout() {
+	if !m.assistantPending {
+		return
+	}
+	logrus.Warn("VE.Bus device did not report its assistants")
+	m.assistantPending = false
+	m.assistantsDone = true
+	m.stateLock.Lock()
+	m.readsChanged = true
+	m.stateLock.Unlock()
+}
+
+// Rebuilds the RAM variable reads of the poll cycle from the monitored
+// assistant variables, if they or the loaded assistants changed.
+func (m *mk2Ser) updateReads() {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	if !m.readsChanged {
+		return
+	}
+	m.readsChanged = false
+	m.reads = append(m.reads[:0:0], ramReads...)
+	for i := range m.ramUnsupported {
+		if i >= len(ramReads) {
+			delete(m.ramUnsupported, i)
+		}
+	}
+	m.info.AssistantValues = nil
+	var ids []byte
+	var names []string
+	for _, v := range m.assistantVars {
+		id, ok := v.resolve(m.assistants)
+		if !ok {
+			logrus.Warnf("Assistant variable %s not found on the VE.Bus device", v.Name)
+			continue
+		}
+		ids = append(ids, id)
+		names = append(names, v.Name)
+		if len(ids) == maxRAMReadIDs {
+			m.reads = append(m.reads, assistantRead(ids, names))
+			ids, names = nil, nil
+		}
+	}
+	if len(ids) > 0 {
+		m.reads = append(m.reads, assistantRead(ids, names))
+	}
+}
+
+// Returns the read of the assistant variables ids, reported as names.
+func assistantRead(ids []byte, names []string) ramRead {
+	return ramRead{
+		ids: ids,
+		decode: func(m *mk2Ser, data []byte) {
+			values := make(map[string]float64, len(m.info.AssistantValues)+len(names))
+			for name, v := range m.info.AssistantValues {
+				values[name] = v
+			}
+			for i, name := range names {
+				values[name] = getSigned(data[2*i : 2*i+2])
+			}
+			m.info.AssistantValues = values
+		},
+	}
+}
@@ -0,0 +1,131 @@
+package mk2driver
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAssistantVar(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    AssistantVar
+		wantErr bool
+	}{
+		{in: "setpoint=0x83", want: AssistantVar{Name: "setpoint", ID: 0x83}},
+		{in: "setpoint=131", want: AssistantVar{Name: "setpoint", ID: 0x83}},
+		{in: "setpoint = ess:2", want: AssistantVar{Name: "setpoint", AssistantID: AssistantESS, Index: 2}},
+		{in: "genstart=7:0", want: AssistantVar{Name: "genstart", AssistantID: 7}},
+		{in: "setpoint", wantErr: true},
+		{in: "=0x83", wantErr: true},
+		{in: "charge=0x0d", wantErr: true},
+		{in: "setpoint=0x183", wantErr: true},
+		{in: "setpoint=unknown:1", wantErr: true},
+		{in: "setpoint=ess:16", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseAssistantVar(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAssistantDiscovery(t *testing.T) {
+	m := newReadyMk2()
+	m.assistantsDone = false
+	m.startCycle()
+	assert.Equal(t, []byte{0x05, 0xff, 0x57, 0x30, 0x80, 0x00, 0xf5}, writeBuffer.Bytes(), "First assistant not requested")
+
+	// ESS assistant with 3 variables
+	writeBuffer.Reset()
+	m.handleFrame(buildFrame(0xff, 0x57, 0x85, 0x53, 0x00))
+	assert.Equal(t, []byte{0x05, 0xff, 0x57, 0x30, 0x84, 0x00, 0xf1}, writeBuffer.Bytes(), "Next assistant not requested")
+
+	writeBuffer.Reset()
+	m.handleFrame(buildFrame(0xff, 0x57, 0x85, 0x00, 0x00))
+	assert.Equal(t, []byte{0x03, 0xff, 0x46, 0x00, 0xb8}, writeBuffer.Bytes(), "Poll cycle not started")
+	assert.True(t, m.assistantsDone)
+	assert.Equal(t, []Assistant{{ID: AssistantESS, Header: 0x80, Vars: 3}}, m.Assistants())
+	assert.Equal(t, "ess", m.Assistants()[0].Name())
+}
+
+func TestAssistantDiscoveryQueuedTransaction(t *testing.T) {
+	m := newReadyMk2()
+	m.assistantsDone = false
+	m.startCycle()
+	m.transactions <- &transaction{
+		cmds:  [][]byte{{winmonFrame, commandGetSetDeviceState, 0x00, 0x00}},
+		match: matchWinmon,
+		reply: make(chan transactionResult, 1),
+	}
+
+	// The cycle after the discovery starts with the queued transaction.
+	writeBuffer.Reset()
+	m.handleFrame(buildFrame(0xff, 0x57, 0x85, 0x00, 0x00))
+	assert.True(t, m.assistantsDone)
+	assert.Equal(t, []byte{0x05, 0xff, 0x57, 0x0e, 0x00, 0x00, 0x97}, writeBuffer.Bytes(), "Queued transaction not sent")
+	assert.NotNil(t, m.pending)
+}
+
+func TestAssistantDiscoveryTimeout(t *testing.T) {
+	m := newReadyMk2()
+	m.assistantsDone = false
+	m.startCycle()
+	writeBuffer.Reset()
+	m.handleFrame(buildFrame(versionFrame...))
+	assert.True(t, m.assistantsDone)
+	assert.Equal(t, []byte{0x03, 0xff, 0x46, 0x00, 0xb8}, writeBuffer.Bytes(), "Poll cycle not started")
+}
+
+func TestAssistantVarsPolled(t *testing.T) {
+	m := newReadyMk2()
+	m.infochan = make(chan *Mk2Info, 1)
+	m.masterLEDUnsupported = true
+	m.ramUnsupported = map[int]bool{0: true, 1: true, 2: true}
+	m.assistants = []Assistant{{ID: AssistantESS, Header: 0x80, Vars: 3}}
+	m.MonitorAssistantVars([]AssistantVar{
+		{Name: "setpoint", AssistantID: AssistantESS, Index: 1},
+		{Name: "raw", ID: 0x90},
+		{Name: "missing", AssistantID: 7},
+	})
+
+	m.ledDecode([]byte{0x00, 0x00, 0x00, 0x00})
+	assert.Equal(t, []byte{0x05, 0xff, 0x57, 0x30, 0x82, 0x90, 0x63}, writeBuffer.Bytes(), "Assistant variables not requested")
+	m.handleFrame(buildFrame(0xff, 0x57, 0x85, 0x18, 0xfc, 0x01, 0x00))
+	info := <-m.infochan
+	assert.Equal(t, map[string]float64{"setpoint": -1000, "raw": 1}, info.AssistantValues)
+}
+
+func TestWriteAssistantVar(t *testing.T) {
+	m := newReadyMk2()
+	m.assistants = []Assistant{{ID: AssistantESS, Header: 0x80, Vars: 3}}
+
+	var err error
+	done := make(chan struct{})
+	go func() {
+		err = m.WriteAssistantVar(AssistantVar{Name: "setpoint", AssistantID: AssistantESS}, -500)
+		close(done)
+	}()
+	waitQueued(t, m)
+	m.handleFrame(buildFrame(versionFrame...))
+	l, write := buildFrame(0xff, 0x57, 0x32, 0x81, 0x00)
+	ld, data := buildFrame(0xff, 0x57, 0x34, 0x0c, 0xfe)
+	expected := append(append([]byte{l}, write...), append([]byte{ld}, data...)...)
+	assert.Equal(t, expected, writeBuffer.Bytes())
+	// A late RAM read reply of the poll cycle does not answer the write.
+	m.handleFrame(buildFrame(0xff, 0x57, 0x85, 0x18, 0xfc))
+	assert.NotNil(t, m.pending, "Write answered by a RAM read reply")
+	m.handleFrame(buildFrame(0xff, 0x57, 0x87, 0x00, 0x00))
+	<-done
+	assert.NoError(t, err)
+
+	err = m.WriteAssistantVar(AssistantVar{Name: "genstart", AssistantID: 7}, 1)
+	assert.True(t, errors.Is(err, ErrNotSupported), "Unexpected error %v", err)
+}
@@ -0,0 +1,190 @@
+package mk2driver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "write the decoded captures to their golden files")
+
+// captureLine holds bytes received from or sent to a device, in the order they
+// were captured on the wire.
+type captureLine struct {
+	dir  Direction
+	data []byte
+}
+
+// Reads a capture from testdata/captures. Every line holds the direction, rx
+// or tx, followed by the bytes in hex. Lines starting with # are comments.
+func readCapture(t testing.TB, path string) []captureLine {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var lines []captureLine
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		var dir Direction
+		switch fields[0] {
+		case DirectionReceived.String():
+			dir = DirectionReceived
+		case DirectionSent.String():
+			dir = DirectionSent
+		default:
+			t.Fatalf("Invalid direction in %q", line)
+		}
+		data, err := hex.DecodeString(strings.Join(fields[1:], ""))
+		require.NoError(t, err, "invalid bytes in %q", line)
+		lines = append(lines, captureLine{dir: dir, data: data})
+	}
+	require.NoError(t, scanner.Err())
+	return lines
+}
+
+// Returns all bytes received in a capture.
+func receivedStream(lines []captureLine) []byte {
+	var stream []byte
+	for _, line := range lines {
+		if line.dir == DirectionReceived {
+			stream = append(stream, line.data...)
+		}
+	}
+	return stream
+}
+
+// drainReader closes drained once all of its data was read and then blocks
+// until released, so that the frame locker does not spin on EOF.
+type drainReader struct {
+	r       io.Reader
+	drained chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (d *drainReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err == io.EOF {
+		d.once.Do(func() { close(d.drained) })
+		<-d.release
+	}
+	return n, err
+}
+
+// Feeds stream to a driver through its frame locker and closes it once the
+// stream was read.
+func replayStream(t testing.TB, stream []byte) *mk2Ser {
+	t.Helper()
+	r := &drainReader{
+		r:       bytes.NewReader(stream),
+		drained: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	mk2, err := NewMk2Connection(&testIo{Reader: r, Writer: io.Discard})
+	require.NoError(t, err)
+	m := mk2.(*mk2Ser)
+	select {
+	case <-r.drained:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Driver did not read the whole stream")
+	}
+	// Stop the frame locker before the blocked read returns.
+	close(m.run)
+	close(r.release)
+	m.wg.Wait()
+	return m
+}
+
+func writeDecoded(w io.Writer, f DecodedFrame) {
+	fmt.Fprintf(w, "%s %-10s % x", f.Direction, f.Type, f.Frame)
+	if f.Command != "" {
+		fmt.Fprintf(w, "  %s", f.Command)
+	}
+	fmt.Fprintln(w)
+	for _, field := range f.Fields {
+		if field.Scaling != "" {
+			fmt.Fprintf(w, "    %s: %s (%s)\n", field.Name, field.Value, field.Scaling)
+		} else {
+			fmt.Fprintf(w, "    %s: %s\n", field.Name, field.Value)
+		}
+	}
+	if f.Error != "" {
+		fmt.Fprintf(w, "    error: %s\n", f.Error)
+	}
+}
+
+func writeStats(w io.Writer, s Stats) {
+	fmt.Fprintf(w, "driver: frames %d, checksum errors %d, resyncs %d, bootups %d\n",
+		s.FramesReceived, s.ChecksumErrors, s.Resyncs, s.Bootups)
+	types := make([]string, 0, len(s.FramesByType))
+	for frameType := range s.FramesByType {
+		types = append(types, frameType)
+	}
+	sort.Strings(types)
+	for _, frameType := range types {
+		fmt.Fprintf(w, "    %s: %d\n", frameType, s.FramesByType[frameType])
+	}
+}
+
+func writeDevice(w io.Writer, d DeviceInfo) {
+	fmt.Fprintf(w, "device: %s %d, firmware %d, product code %d\n",
+		d.Interface, d.InterfaceVersion, d.FirmwareVersion, d.ProductCode)
+}
+
+// Decodes the captures in testdata/captures and replays the received bytes
+// through the driver, comparing the results with the golden files next to
+// them. Run with -update to rewrite the golden files.
+func TestCaptures(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "captures", "*.txt"))
+	require.NoError(t, err)
+	require.NotEmpty(t, paths)
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".txt")
+		t.Run(name, func(t *testing.T) {
+			lines := readCapture(t, path)
+
+			var out bytes.Buffer
+			d := NewDecoder()
+			for _, line := range lines {
+				frames, skipped := SplitFrames(line.data)
+				if skipped > 0 {
+					fmt.Fprintf(&out, "%s skipped %d bytes\n", line.dir, skipped)
+				}
+				for _, frame := range frames {
+					writeDecoded(&out, d.Decode(line.dir, frame))
+				}
+			}
+			m := replayStream(t, receivedStream(lines))
+			writeStats(&out, m.Stats())
+			writeDevice(&out, m.DeviceInfo())
+
+			golden := strings.TrimSuffix(path, ".txt") + ".golden"
+			if *updateGolden {
+				require.NoError(t, os.WriteFile(golden, out.Bytes(), 0o644))
+			}
+			want, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			assert.Equal(t, string(want), out.String())
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package mk2driver
+
+import (
+	"fmt"
+	"time"
+)
+
+// VEBusState is the main state of the VE.Bus device.
+type VEBusState int
+
+const (
+	VEBusStateDown VEBusState = iota
+	VEBusStateStartup
+	VEBusStateOff
+	VEBusStateSlave
+	VEBusStateInvertFull
+	VEBusStateInvertHalf
+	VEBusStateInvertAES
+	VEBusStatePowerAssist
+	VEBusStateBypass
+	VEBusStateCharge
+)
+
+var VEBusStateNames = map[VEBusState]string{
+	VEBusStateDown:        "down",
+	VEBusStateStartup:     "startup",
+	VEBusStateOff:         "off",
+	VEBusStateSlave:       "slave",
+	VEBusStateInvertFull:  "invert_full",
+	VEBusStateInvertHalf:  "invert_half",
+	VEBusStateInvertAES:   "invert_aes",
+	VEBusStatePowerAssist: "power_assist",
+	VEBusStateBypass:      "bypass",
+	VEBusStateCharge:      "charge",
+}
+
+func (s VEBusState) String() string {
+	name, ok := VEBusStateNames[s]
+	if !ok {
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+	return name
+}
+
+// MarshalText encodes the state by name.
+func (s VEBusState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// VEBusChargeState is the charger sub state while in VEBusStateCharge.
+type VEBusChargeState int
+
+const (
+	VEBusChargeInit VEBusChargeState = iota
+	VEBusChargeBulk
+	VEBusChargeAbsorption
+	VEBusChargeFloat
+	VEBusChargeStorage
+	VEBusChargeRepeatedAbsorption
+	VEBusChargeForcedAbsorption
+	VEBusChargeEqualise
+	VEBusChargeBulkStopped
+)
+
+var VEBusChargeStateNames = map[VEBusChargeState]string{
+	VEBusChargeInit:               "init",
+	VEBusChargeBulk:               "bulk",
+	VEBusChargeAbsorption:         "absorption",
+	VEBusChargeFloat:              "float",
+	VEBusChargeStorage:            "storage",
+	VEBusChargeRepeatedAbsorption: "repeated_absorption",
+	VEBusChargeForcedAbsorption:   "forced_absorption",
+	VEBusChargeEqualise:           "equalise",
+	VEBusChargeBulkStopped:        "bulk_stopped",
+}
+
+func (s VEBusChargeState) String() string {
+	name, ok := VEBusChargeStateNames[s]
+	if !ok {
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+	return name
+}
+
+// MarshalText encodes the charge state by name.
+func (s VEBusChargeState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// DeviceState is the state reported by the VE.Bus device in reply to a
+// device state command.
+type DeviceState struct {
+	State       VEBusState
+	ChargeState VEBusChargeState
+	// Time the state was received, zero if it was never read.
+	Timestamp time.Time
+}
+
+// ChargerMode is a charger mode that can be forced with SetChargerMode.
+type ChargerMode int
+
+const (
+	ChargerModeAbsorption ChargerMode = iota
+	ChargerModeFloat
+	ChargerModeEqualize
+)
+
+var ChargerModeNames = map[ChargerMode]string{
+	ChargerModeAbsorption: "absorption",
+	ChargerModeFloat:      "float",
+	ChargerModeEqualize:   "equalize",
+}
+
+func (c ChargerMode) String() string {
+	return ChargerModeNames[c]
+}
+
+// Device state command values.
+const (
+	deviceStateInquire         = 0x00
+	deviceStateForceEqualise   = 0x01
+	deviceStateForceAbsorption = 0x02
+	deviceStateForceFloat      = 0x03
+)
+
+// Command value and the LED charger stages the mode can be forced from.
+var chargerModes = map[ChargerMode]struct {
+	command byte
+	from    []ChargerStage
+}{
+	ChargerModeAbsorption: {deviceStateForceAbsorption, []ChargerStage{ChargerBulk, ChargerFloat}},
+	ChargerModeFloat:      {deviceStateForceFloat, []ChargerStage{ChargerBulk, ChargerAbsorption, ChargerEqualize}},
+	ChargerModeEqualize:   {deviceStateForceEqualise, []ChargerStage{ChargerAbsorption, ChargerFloat}},
+}
+
+// SetChargerMode forces the charger into mode. The command is only sent if
+// the AC input is accepted, the LEDs show a charger stage mode can be forced
+// from and the device reports it is charging, otherwise ErrInvalidState is
+// returned. The returned state is the one reported by the device after the
+// command and is also set in the next Mk2Info.
+func (m *mk2Ser) SetChargerMode(mode ChargerMode) (DeviceState, error) {
+	target, ok := chargerModes[mode]
+	if !ok {
+		return DeviceState{}, fmt.Errorf("unknown charger mode %d", mode)
+	}
+
+	m.stateLock.Lock()
+	status := m.status
+	m.stateLock.Unlock()
+	if status.ACInput != ACInputAccepted {
+		return DeviceState{}, fmt.Errorf("%w: AC input %v", ErrInvalidState, status.ACInput)
+	}
+	if !containsStage(target.from, status.ChargerStage) {
+		return DeviceState{}, fmt.Errorf("%w: can not force %v from charger stage %v", ErrInvalidState, mode, status.ChargerStage)
+	}
+
+	state, err := m.deviceState(deviceStateInquire)
+	if err != nil {
+		return state, err
+	}
+	if state.State != VEBusStateCharge {
+		return state, fmt.Errorf("%w: device state %v", ErrInvalidState, state.State)
+	}
+	return m.deviceState(target.command)
+}
+
+func containsStage(stages []ChargerStage, stage ChargerStage) bool {
+	for _, s := range stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// Sends a device state command and returns the state in the reply.
+func (m *mk2Ser) deviceState(command byte) (DeviceState, error) {
+	cmd := []byte{winmonFrame, commandGetSetDeviceState, command, 0x00}
+	reply, err := m.transact(matchWinmonReply(cmd), func(frame []byte) {
+		if frame[2] == commandGetSetDeviceStateResponse && len(frame) >= 6 {
+			m.info.DeviceState = decodeDeviceState(frame[3:5])
+		}
+	}, cmd)
+	if err != nil {
+		return DeviceState{}, err
+	}
+	if reply[2] != commandGetSetDeviceStateResponse || len(reply) < 6 {
+		return DeviceState{}, newFrameError(ErrNotSupported, reply)
+	}
+	return decodeDeviceState(reply[3:5]), nil
+}
+
+func decodeDeviceState(data []byte) DeviceState {
+	return DeviceState{
+		State:       VEBusState(data[0]),
+		ChargeState: VEBusChargeState(data[1]),
+		Timestamp:   time.Now(),
+	}
+}
@@ -0,0 +1,198 @@
+package mk2driver
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetChargerMode(t *testing.T) {
+	m := newReadyMk2()
+	m.status = Status{ChargerStage: ChargerFloat, ACInput: ACInputAccepted}
+
+	var state DeviceState
+	var err error
+	done := make(chan struct{})
+	go func() {
+		state, err = m.SetChargerMode(ChargerModeEqualize)
+		close(done)
+	}()
+
+	// Inquire the state first
+	waitQueued(t, m)
+	m.handleFrame(buildFrame(versionFrame...))
+	assert.Equal(t, []byte{0x05, 0xff, 0x57, 0x0e, 0x00, 0x00, 0x97}, writeBuffer.Bytes())
+	writeBuffer.Reset()
+	m.handleFrame(buildFrame(0xff, 0x57, 0x94, byte(VEBusStateCharge), byte(VEBusChargeFloat)))
+	assert.Equal(t, []byte{0x03, 0xff, 0x46, 0x00, 0xb8}, writeBuffer.Bytes(), "Poll cycle not started")
+
+	// Then force equalise in the next cycle
+	waitQueued(t, m)
+	writeBuffer.Reset()
+	m.handleFrame(buildFrame(versionFrame...))
+	assert.Equal(t, []byte{0x05, 0xff, 0x57, 0x0e, 0x01, 0x00, 0x96}, writeBuffer.Bytes())
+	m.handleFrame(buildFrame(0xff, 0x57, 0x94, byte(VEBusStateCharge), byte(VEBusChargeEqualise)))
+	<-done
+
+	assert.NoError(t, err)
+	assert.Equal(t, VEBusStateCharge, state.State)
+	assert.Equal(t, VEBusChargeEqualise, state.ChargeState)
+	// Decoded separately from the same reply.
+	reported := m.info.DeviceState
+	assert.WithinDuration(t, state.Timestamp, reported.Timestamp, time.Second)
+	reported.Timestamp = state.Timestamp
+	assert.Equal(t, state, reported, "State not reported in Mk2Info")
+}
+
+func TestSetChargerModeInvalidState(t *testing.T) {
+	tests := []struct {
+		name   string
+		status Status
+		mode   ChargerMode
+	}{
+		{
+			name:   "no AC input",
+			status: Status{ChargerStage: ChargerFloat, ACInput: ACInputPresent},
+			mode:   ChargerModeEqualize,
+		},
+		{
+			name:   "equalize from bulk",
+			status: Status{ChargerStage: ChargerBulk, ACInput: ACInputAccepted},
+			mode:   ChargerModeEqualize,
+		},
+		{
+			name:   "absorption while charger off",
+			status: Status{ChargerStage: ChargerOff, ACInput: ACInputAccepted},
+			mode:   ChargerModeAbsorption,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newReadyMk2()
+			m.status = tt.status
+			_, err := m.SetChargerMode(tt.mode)
+			assert.True(t, errors.Is(err, ErrInvalidState), "Unexpected error %v", err)
+			assert.Len(t, m.transactions, 0, "Command queued")
+		})
+	}
+}
+
+func TestSetChargerModeNotCharging(t *testing.T) {
+	m := newReadyMk2()
+	m.status = Status{ChargerStage: ChargerAbsorption, ACInput: ACInputAccepted}
+
+	var err error
+	done := make(chan struct{})
+	go func() {
+		_, err = m.SetChargerMode(ChargerModeFloat)
+		close(done)
+	}()
+	waitQueued(t, m)
+	m.handleFrame(buildFrame(versionFrame...))
+	m.handleFrame(buildFrame(0xff, 0x57, 0x94, byte(VEBusStateBypass), 0x00))
+	<-done
+
+	assert.True(t, errors.Is(err, ErrInvalidState), "Unexpected error %v", err)
+	assert.Len(t, m.transactions, 0, "Command queued")
+}
+
+func TestTransactionTimeout(t *testing.T) {
+	m := newReadyMk2()
+	var err error
+	done := make(chan struct{})
+	go func() {
+		_, err = m.transact(matchWinmon, nil, []byte{winmonFrame, commandGetSetDeviceState, 0x00, 0x00})
+		close(done)
+	}()
+	waitQueued(t, m)
+	m.handleFrame(buildFrame(versionFrame...))
+	// No reply before the next cycle
+	m.handleFrame(buildFrame(versionFrame...))
+	<-done
+
+	assert.True(t, errors.Is(err, ErrTimeout), "Unexpected error %v", err)
+	assert.Nil(t, m.pending)
+	// Reported once, without invalidating the received values.
+	if assert.Len(t, m.info.Errors, 1) {
+		assert.True(t, errors.Is(m.info.Errors[0], ErrTimeout), "Unexpected error %v", m.info.Errors[0])
+	}
+	assert.True(t, m.info.Valid)
+}
+
+func TestMatchWinmonReply(t *testing.T) {
+	ramRead := []byte{0xff, 0x57, 0x85, 0x10, 0x00, 0x10}
+	ramWrite := []byte{0xff, 0x57, 0x87, 0x00, 0x00, 0x00}
+	settingWrite := []byte{0xff, 0x57, 0x88, 0x00, 0x00, 0x00}
+	variableNotSupported := []byte{0xff, 0x57, 0x90, 0x00, 0x00, 0x00}
+	settingNotSupported := []byte{0xff, 0x57, 0x91, 0x00, 0x00, 0x00}
+	notSupported := []byte{0xff, 0x57, 0x80, 0x00, 0x00, 0x00}
+	led := []byte{0xff, 0x4c, 0x00, 0x00, 0x00}
+
+	tests := []struct {
+		name  string
+		cmd   []byte
+		match [][]byte
+		other [][]byte
+	}{
+		{
+			name:  "write RAM variable",
+			cmd:   []byte{winmonFrame, commandWriteRAMVar, 0x81, 0x00},
+			match: [][]byte{ramWrite, variableNotSupported, notSupported},
+			other: [][]byte{ramRead, settingWrite, settingNotSupported, led},
+		},
+		{
+			name:  "write setting",
+			cmd:   []byte{winmonFrame, commandWriteSetting, 0x01, 0x00},
+			match: [][]byte{settingWrite, settingNotSupported, notSupported},
+			other: [][]byte{ramRead, ramWrite, variableNotSupported},
+		},
+		{
+			name:  "read RAM variables",
+			cmd:   []byte{winmonFrame, commandReadRAMVar, 0x0d},
+			match: [][]byte{ramRead, variableNotSupported},
+			other: [][]byte{ramWrite, settingNotSupported},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match := matchWinmonReply(tt.cmd)
+			for _, frame := range tt.match {
+				assert.True(t, match(frame), "Reply %x not matched", frame)
+			}
+			for _, frame := range tt.other {
+				assert.False(t, match(frame), "Frame %x matched", frame)
+			}
+		})
+	}
+}
+
+func TestTransactionCancelled(t *testing.T) {
+	m := newReadyMk2()
+	called := false
+	tr := &transaction{
+		match:   matchWinmon,
+		onReply: func([]byte) { called = true },
+		reply:   make(chan transactionResult, 1),
+	}
+	// The caller timed out before the late reply arrived.
+	tr.cancelled.Store(true)
+	m.pending = tr
+	m.handleFrame(buildFrame(0xff, 0x57, 0x94, byte(VEBusStateCharge), byte(VEBusChargeFloat)))
+
+	assert.False(t, called, "Reply handler run for a cancelled transaction")
+	assert.Nil(t, m.pending)
+}
+
+func TestPollCycleTimeout(t *testing.T) {
+	m := newReadyMk2()
+	m.handleFrame(buildFrame(versionFrame...))
+	assert.Empty(t, m.info.Errors)
+	// The DC info request is not answered before the next cycle.
+	m.handleFrame(buildFrame(versionFrame...))
+	if assert.Len(t, m.info.Errors, 1) {
+		assert.True(t, errors.Is(m.info.Errors[0], ErrTimeout), "Unexpected error %v", m.info.Errors[0])
+	}
+	assert.Equal(t, uint64(1), m.Stats().Timeouts)
+}
@@ -0,0 +1,515 @@
+package mk2driver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Direction of a frame relative to the host.
+type Direction int
+
+const (
+	DirectionUnknown Direction = iota
+	// Frames received from the interface.
+	DirectionReceived
+	// Commands sent to the interface.
+	DirectionSent
+)
+
+var directionNames = map[Direction]string{
+	DirectionUnknown:  "??",
+	DirectionReceived: "rx",
+	DirectionSent:     "tx",
+}
+
+func (d Direction) String() string {
+	return directionNames[d]
+}
+
+// Names of the RAM variables the driver knows.
+var ramVarNames = map[byte]string{
+	ramVarVMains:         "mains_voltage",
+	ramVarIMains:         "mains_current",
+	ramVarVInverter:      "inverter_voltage",
+	ramVarIInverter:      "inverter_current",
+	ramVarVBat:           "battery_voltage",
+	ramVarIBat:           "battery_current",
+	ramVarVBatRipple:     "battery_ripple",
+	ramVarInverterPeriod: "inverter_period",
+	ramVarMainPeriod:     "mains_period",
+	ramVarIACLoad:        "ac_load_current",
+	ramVarVirSwitchPos:   "virtual_switch",
+	ramVarIgnACInState:   "ignore_ac_input",
+	ramVarMultiFuncRelay: "relay",
+	ramVarChargeState:    "charge_state",
+	ramVarBatTemperature: "battery_temperature",
+}
+
+var winmonCommandNames = map[byte]string{
+	commandSendSoftwareVersionPart0:     "read firmware version low",
+	commandSendSoftwareVersionPart1:     "read firmware version high",
+	commandGetSetDeviceState:            "device state",
+	commandReadRAMVar:                   "read RAM variables",
+	commandReadSetting:                  "read setting",
+	commandWriteRAMVar:                  "write RAM variable",
+	commandWriteSetting:                 "write setting",
+	commandWriteData:                    "write data",
+	commandGetSettingInfo:               "read setting info",
+	commandGetRAMVarInfo:                "read RAM variable info",
+	commandNotSupportedResponse:         "command not supported",
+	commandSoftwareVersionPart0Response: "firmware version low",
+	commandSoftwareVersionPart1Response: "firmware version high",
+	commandReadRAMResponse:              "RAM variables",
+	commandReadSettingResponse:          "setting",
+	commandWriteRAMResponse:             "RAM variable written",
+	commandWriteSettingResponse:         "setting written",
+	commandGetSettingInfoResponse:       "setting info",
+	commandGetRAMVarInfoResponse:        "RAM variable info",
+	commandVariableNotSupportedResponse: "variable not supported",
+	commandSettingNotSupportedResponse:  "setting not supported",
+	commandGetSetDeviceStateResponse:    "device state",
+}
+
+var infoRequestNames = map[byte]string{
+	infoReqAddrDC:        "request DC info",
+	infoReqAddrACL1:      "request AC L1 info",
+	infoReqAddrMasterLED: "request master multi LED",
+}
+
+// Returns the RAM variable name of id, or its number.
+func ramVarName(id byte) string {
+	if name, ok := ramVarNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("ram_0x%02x", id)
+}
+
+// Returns the index in mk2Ser.scales of the scale factor of RAM variable id.
+func scaleIndex(id byte) (int, bool) {
+	if id < ramVarMaxOffset {
+		return int(id), true
+	}
+	for i, v := range extraScaleVars {
+		if v == id {
+			return ramVarMaxOffset + i, true
+		}
+	}
+	return 0, false
+}
+
+// Field is a value decoded from a frame.
+type Field struct {
+	Name  string
+	Value string
+	// Scale factor applied to the raw value, "unscaled" if the value needs a
+	// scale factor that was not in the stream, empty if it needs none.
+	Scaling string
+}
+
+// DecodedFrame is a frame annotated by a Decoder.
+type DecodedFrame struct {
+	Direction Direction
+	// Frame from its header up to and including the checksum.
+	Frame         []byte
+	ChecksumValid bool
+	// Frame type, one of the FrameType constants.
+	Type string
+	// Command or response, empty for frames without one.
+	Command string
+	Fields  []Field
+	// Set if the frame could not be decoded.
+	Error string
+}
+
+// Decoder annotates captured frames with the decoders of the driver. Frames
+// have to be passed in the order they were captured, as replies are decoded
+// according to the last command sent and scale factor replies are applied to
+// the frames after them. Values that need a scale factor that was not in the
+// stream are reported unscaled.
+type Decoder struct {
+	m *mk2Ser
+	// Scale factors that were in the stream, by index in m.scales.
+	known []bool
+	// Last winmon command sent, which the next winmon reply answers.
+	last []byte
+}
+
+// NewDecoder returns a decoder that did not see any scale factors yet.
+func NewDecoder() *Decoder {
+	m := &mk2Ser{info: &Mk2Info{}}
+	m.scales = make([]scaling, scaleVarCount)
+	for i := range m.scales {
+		m.scales[i] = scaling{scale: 1, supported: true}
+	}
+	return &Decoder{m: m, known: make([]bool, scaleVarCount)}
+}
+
+// Decode annotates frame, from its header up to and including the checksum.
+// The direction is guessed if it is not known.
+func (d *Decoder) Decode(dir Direction, frame []byte) DecodedFrame {
+	f := DecodedFrame{
+		Direction: dir,
+		Frame:     append([]byte(nil), frame...),
+		Type:      FrameType(frame),
+	}
+	if len(frame) < 2 {
+		f.Error = "frame too short"
+		return f
+	}
+	f.ChecksumValid = checkChecksum(byte(len(frame)-1), frame[0], frame[1:])
+	if f.Direction == DirectionUnknown {
+		f.Direction = guessDirection(frame)
+	}
+	if !f.ChecksumValid {
+		// The driver drops frames with an invalid checksum.
+		f.Error = "invalid checksum"
+		return f
+	}
+	if f.Direction == DirectionSent {
+		d.decodeSent(&f, frame)
+	} else {
+		d.decodeReceived(&f, frame)
+	}
+	return f
+}
+
+// Commands have a winmon command byte below 0x80, replies above. Info and
+// LED requests are shorter than their replies.
+func guessDirection(frame []byte) Direction {
+	if frame[0] != frameHeader {
+		return DirectionReceived
+	}
+	switch frame[1] {
+	case winmonFrame:
+		if len(frame) > 2 && frame[2] < commandNotSupportedResponse {
+			return DirectionSent
+		}
+	case infoReqFrame:
+		return DirectionSent
+	case ledFrame:
+		if len(frame) <= 3 {
+			return DirectionSent
+		}
+	case setTargetFrame:
+		if len(frame) <= 2+masterLEDLength {
+			return DirectionSent
+		}
+	}
+	return DirectionReceived
+}
+
+func (d *Decoder) decodeSent(f *DecodedFrame, frame []byte) {
+	if frame[0] != frameHeader {
+		f.Error = "unknown command frame"
+		return
+	}
+	if len(frame) < minFrameLength {
+		f.Error = "frame too short"
+		return
+	}
+	data := frame[2 : len(frame)-1]
+	switch frame[1] {
+	case setTargetFrame:
+		f.Command = "set target"
+	case infoReqFrame:
+		if len(data) < 1 {
+			f.Error = "frame too short"
+			return
+		}
+		f.Command = infoRequestNames[data[0]]
+		if f.Command == "" {
+			f.Command = fmt.Sprintf("request info 0x%02x", data[0])
+		}
+	case ledFrame:
+		f.Command = "request LEDs"
+	case winmonFrame:
+		d.decodeWinmonCommand(f, data)
+	default:
+		f.Command = fmt.Sprintf("command %q", frame[1])
+	}
+}
+
+func (d *Decoder) decodeWinmonCommand(f *DecodedFrame, data []byte) {
+	if len(data) < 1 {
+		f.Error = "frame too short"
+		return
+	}
+	f.Command = winmonName(data[0])
+	args := data[1:]
+	if data[0] == commandReadRAMVar && len(args) == 2 && args[1] == 0x00 {
+		// Padding added by readRAMCommand to a single variable.
+		args = args[:1]
+	}
+	if data[0] != commandWriteData {
+		d.last = append([]byte{data[0]}, args...)
+	}
+	switch data[0] {
+	case commandReadRAMVar:
+		for _, id := range args {
+			f.Fields = append(f.Fields, Field{Name: "variable", Value: fmt.Sprintf("%s (0x%02x)", ramVarName(id), id)})
+		}
+	case commandWriteRAMVar, commandGetRAMVarInfo:
+		if len(args) > 0 {
+			f.Fields = append(f.Fields, Field{Name: "variable", Value: fmt.Sprintf("%s (0x%02x)", ramVarName(args[0]), args[0])})
+		}
+	case commandReadSetting, commandWriteSetting, commandGetSettingInfo:
+		if len(args) >= 2 {
+			f.Fields = append(f.Fields, Field{Name: "setting", Value: fmt.Sprint(getUnsigned16(args[0:2]))})
+		}
+	case commandWriteData:
+		if len(args) >= 2 {
+			f.Fields = append(f.Fields, Field{Name: "value", Value: fmt.Sprintf("%d (0x%04x)", int16(getSigned(args[0:2])), uint16(getUnsigned16(args[0:2])))})
+		}
+	case commandGetSetDeviceState:
+		if len(args) > 0 {
+			f.Fields = append(f.Fields, Field{Name: "state command", Value: fmt.Sprint(args[0])})
+		}
+	}
+}
+
+func winmonName(command byte) string {
+	if name, ok := winmonCommandNames[command]; ok {
+		return fmt.Sprintf("%s (0x%02x)", name, command)
+	}
+	return fmt.Sprintf("0x%02x", command)
+}
+
+func (d *Decoder) decodeReceived(f *DecodedFrame, frame []byte) {
+	m := d.m
+	switch f.Type {
+	case FrameTypeBootup:
+		f.Command = "bootup"
+	case FrameTypeVersion:
+		if len(frame) < versionFrameLength {
+			f.Error = "frame too short"
+			return
+		}
+		var version uint32
+		for i := 0; i < 4; i++ {
+			version += uint32(frame[2+i]) << (uint(i) * 8)
+		}
+		f.Fields = []Field{
+			{Name: "version", Value: fmt.Sprint(version)},
+			{Name: "interface", Value: interfaceType(version).String()},
+		}
+	case FrameTypeDCInfo:
+		if len(frame) < infoFrameLength {
+			f.Error = "frame too short"
+			return
+		}
+		m.decodeDC(frame[1:])
+		f.Fields = []Field{
+			d.scaled("battery_voltage", m.info.BatVoltage, ramVarVBat),
+			d.scaled("battery_inverter_current", m.info.BatInverterCurrent, ramVarIBat),
+			d.scaled("battery_charger_current", m.info.BatChargerCurrent, ramVarIBat),
+			d.scaled("battery_current", m.info.BatCurrent, ramVarIBat),
+			d.scaled("output_frequency", m.info.OutFrequency, ramVarInverterPeriod),
+			d.scaled("inverter_period", m.info.InverterPeriod, ramVarInverterPeriod),
+		}
+	case FrameTypeACInfo:
+		if len(frame) < infoFrameLength {
+			f.Error = "frame too short"
+			return
+		}
+		m.decodeAC(frame[1:])
+		f.Fields = []Field{
+			d.scaled("input_voltage", m.info.InVoltage, ramVarVMains),
+			d.scaled("input_current", m.info.InCurrent, ramVarIMains),
+			d.scaled("output_voltage", m.info.OutVoltage, ramVarVInverter),
+			d.scaled("output_current", m.info.OutCurrent, ramVarIInverter),
+			d.scaled("input_frequency", m.info.InFrequency, ramVarMainPeriod),
+		}
+	case FrameTypeLED:
+		if len(frame) < ledFrameLength {
+			f.Error = "frame too short"
+			return
+		}
+		leds := getLEDs(frame[2], frame[3])
+		status := DecodeStatus(leds)
+		f.Fields = []Field{
+			{Name: "leds", Value: formatLEDs(leds)},
+			{Name: "charger_stage", Value: status.ChargerStage.String()},
+			{Name: "ac_input", Value: status.ACInput.String()},
+		}
+	case FrameTypeMasterLED:
+		f.Command = "master multi LED"
+		f.Fields = []Field{{Name: "active_input", Value: decodeActiveInput(frame[2:]).String()}}
+	case FrameTypeSetTarget:
+		f.Command = "target set"
+	case FrameTypeWinmon:
+		if len(frame) < winmonReplyLength {
+			f.Error = "frame too short"
+			return
+		}
+		d.decodeWinmonReply(f, frame[2:])
+	default:
+		f.Error = "unknown frame"
+	}
+}
+
+// Decodes a winmon reply, starting at the response byte and including the
+// checksum as passed to the driver decoders, according to the last command
+// sent.
+func (d *Decoder) decodeWinmonReply(f *DecodedFrame, reply []byte) {
+	data := reply[:len(reply)-1]
+	if len(data) < 1 {
+		f.Error = "frame too short"
+		return
+	}
+	f.Command = winmonName(data[0])
+	var last byte
+	var args []byte
+	if len(d.last) > 0 {
+		last = d.last[0]
+		args = d.last[1:]
+	}
+	d.last = nil
+	values := data[1:]
+
+	switch data[0] {
+	case commandGetRAMVarInfoResponse:
+		s := parseScaling(reply)
+		if last == commandGetRAMVarInfo && len(args) > 0 {
+			f.Fields = append(f.Fields, Field{Name: "variable", Value: fmt.Sprintf("%s (0x%02x)", ramVarName(args[0]), args[0])})
+			if i, ok := scaleIndex(args[0]); ok {
+				d.m.scales[i] = s
+				d.known[i] = true
+			}
+		}
+		if s.supported {
+			f.Fields = append(f.Fields,
+				Field{Name: "scale", Value: fmt.Sprint(s.scale)},
+				Field{Name: "offset", Value: fmt.Sprint(s.offset)},
+				Field{Name: "signed", Value: fmt.Sprint(s.signed)},
+			)
+		}
+	case commandVariableNotSupportedResponse, commandNotSupportedResponse:
+		if last == commandGetRAMVarInfo && len(args) > 0 {
+			if i, ok := scaleIndex(args[0]); ok {
+				d.m.scales[i] = scaling{}
+				d.known[i] = true
+			}
+		}
+	case commandReadRAMResponse:
+		if last != commandReadRAMVar {
+			for i := 0; i+1 < len(values); i += 2 {
+				f.Fields = append(f.Fields, Field{Name: fmt.Sprintf("value %d", i/2), Value: fmt.Sprint(getSigned(values[i : i+2]))})
+			}
+			return
+		}
+		for i, id := range args {
+			if 2*i+1 >= len(values) {
+				break
+			}
+			f.Fields = append(f.Fields, d.ramValue(id, values[2*i:2*i+2]))
+		}
+	case commandSoftwareVersionPart0Response, commandSoftwareVersionPart1Response, commandReadSettingResponse:
+		if len(values) < 2 {
+			f.Error = "frame too short"
+			return
+		}
+		f.Fields = []Field{{Name: "value", Value: fmt.Sprint(getUnsigned16(values[0:2]))}}
+	case commandGetSettingInfoResponse:
+		var id uint16
+		if last == commandGetSettingInfo && len(args) >= 2 {
+			id = uint16(getUnsigned16(args[0:2]))
+		}
+		info, err := decodeSettingInfo(id, values)
+		if err != nil {
+			f.Error = err.Error()
+			return
+		}
+		f.Fields = []Field{
+			{Name: "setting", Value: fmt.Sprint(info.ID)},
+			{Name: "scale", Value: fmt.Sprint(info.Scale)},
+			{Name: "offset", Value: fmt.Sprint(info.Offset)},
+			{Name: "default", Value: fmt.Sprint(info.Default)},
+			{Name: "min", Value: fmt.Sprint(info.Min)},
+			{Name: "max", Value: fmt.Sprint(info.Max)},
+			{Name: "access_level", Value: fmt.Sprint(info.AccessLevel)},
+		}
+	case commandGetSetDeviceStateResponse:
+		if len(values) < 2 {
+			f.Error = "frame too short"
+			return
+		}
+		state := decodeDeviceState(values[0:2])
+		f.Fields = []Field{
+			{Name: "state", Value: state.State.String()},
+			{Name: "charge_state", Value: state.ChargeState.String()},
+		}
+	}
+}
+
+// Returns the field of RAM variable id read as data.
+func (d *Decoder) ramValue(id byte, data []byte) Field {
+	name := ramVarName(id)
+	i, ok := scaleIndex(id)
+	if !ok || id == ramVarVirSwitchPos || id == ramVarMultiFuncRelay {
+		return Field{Name: name, Value: fmt.Sprint(getSigned(data))}
+	}
+	if !d.m.scales[i].supported {
+		return Field{Name: name, Value: fmt.Sprint(getSigned(data)), Scaling: "not supported"}
+	}
+	return d.scaled(name, d.m.applyScaleAndSign(data, i), i)
+}
+
+// Returns the field of a value scaled with the scale factor at index scale.
+func (d *Decoder) scaled(name string, value float64, scale int) Field {
+	f := Field{Name: name, Value: fmt.Sprintf("%.3f", value)}
+	s := d.m.scales[scale]
+	switch {
+	case !d.known[scale]:
+		f.Scaling = "unscaled"
+	case !s.supported:
+		f.Scaling = "not supported"
+	default:
+		f.Scaling = fmt.Sprintf("scale %v, offset %v", s.scale, s.offset)
+	}
+	return f
+}
+
+func formatLEDs(leds map[Led]LEDstate) string {
+	var active []string
+	for led, state := range leds {
+		if state != LedOff {
+			active = append(active, LedNames[led]+"="+StateNames[state])
+		}
+	}
+	if len(active) == 0 {
+		return "none"
+	}
+	sort.Strings(active)
+	return strings.Join(active, " ")
+}
+
+// SplitFrames splits a captured byte stream into frames, from their header up
+// to and including the checksum, the way the driver locks onto the stream.
+// Bytes before the first frame with a valid checksum and after a frame with
+// an invalid one are skipped until the next valid frame. skipped is the
+// number of bytes that were not part of a frame.
+func SplitFrames(stream []byte) (frames [][]byte, skipped int) {
+	locked := false
+	for i := 0; i < len(stream); {
+		l := int(stream[i])
+		end := i + 2 + l
+		if end > len(stream) || l == 0 {
+			skipped++
+			i++
+			locked = false
+			continue
+		}
+		frame := stream[i+1 : end]
+		valid := checkChecksum(byte(l), frame[0], frame[1:])
+		if !locked && (!valid || (frame[0] != frameHeader && frame[0] != infoFrameHeader)) {
+			skipped++
+			i++
+			continue
+		}
+		frames = append(frames, frame)
+		locked = valid
+		i = end
+	}
+	return frames, skipped
+}
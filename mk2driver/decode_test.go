@@ -0,0 +1,117 @@
+package mk2driver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitFrames(t *testing.T) {
+	stream := append([]byte{0x12, 0x34}, basicWrites[:13]...)
+	frames, skipped := SplitFrames(stream)
+	assert.Equal(t, 2, skipped)
+	assert.Equal(t, [][]byte{
+		{0xff, 0x41, 0x01, 0x00, 0xbb},
+		{0xff, 0x57, 0x36, 0x00, 0x00, 0x6f},
+	}, frames)
+
+	// Frames with an invalid checksum are returned once locked.
+	frames, skipped = SplitFrames([]byte{0x02, 0xff, 0x4c, 0xb3, 0x02, 0xff, 0x4c, 0xb4})
+	assert.Equal(t, 0, skipped)
+	assert.Len(t, frames, 2)
+}
+
+// Returns the basic sequence in the order it is captured on the wire.
+func basicCapture(t *testing.T) []DecodedFrame {
+	rx, skipped := SplitFrames(basicReadBuffer)
+	require.Zero(t, skipped)
+	tx, skipped := SplitFrames(basicWrites)
+	require.Zero(t, skipped)
+	require.Len(t, rx, 28)
+	require.Len(t, tx, 26)
+
+	var order [][]byte
+	order = append(order, tx[0], rx[0], rx[1])
+	for i := 0; i < 15; i++ {
+		order = append(order, tx[1+i], rx[2+i])
+	}
+	order = append(order, rx[17])
+	for i := 0; i < 10; i++ {
+		order = append(order, tx[16+i], rx[18+i])
+	}
+
+	d := NewDecoder()
+	frames := make([]DecodedFrame, len(order))
+	for i, frame := range order {
+		frames[i] = d.Decode(DirectionUnknown, frame)
+	}
+	return frames
+}
+
+func fieldValue(f DecodedFrame, name string) (Field, bool) {
+	for _, field := range f.Fields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return Field{}, false
+}
+
+func TestDecoder(t *testing.T) {
+	frames := basicCapture(t)
+
+	expected := map[string]struct {
+		frame int
+		value string
+	}{
+		"battery_voltage":     {frame: 41, value: "14.410"},
+		"battery_current":     {frame: 41, value: "-0.400"},
+		"output_frequency":    {frame: 41, value: "50.026"},
+		"input_voltage":       {frame: 43, value: "226.980"},
+		"input_current":       {frame: 43, value: "1.710"},
+		"charge_state":        {frame: 49, value: "1.000"},
+		"battery_temperature": {frame: 53, value: "25.500"},
+	}
+	for name, e := range expected {
+		field, ok := fieldValue(frames[e.frame], name)
+		if assert.True(t, ok, "%s not decoded from %x", name, frames[e.frame].Frame) {
+			assert.Equal(t, e.value, field.Value, name)
+			assert.Contains(t, field.Scaling, "scale", name)
+		}
+	}
+
+	for i, f := range frames {
+		assert.True(t, f.ChecksumValid, "frame %d", i)
+		assert.Empty(t, f.Error, "frame %d", i)
+	}
+	// The set target reply equals the command, so its direction can not be
+	// told apart.
+	assert.Equal(t, DirectionSent, frames[0].Direction)
+	assert.Equal(t, DirectionReceived, frames[2].Direction)
+	assert.Equal(t, FrameTypeVersion, frames[2].Type)
+	assert.Equal(t, "read RAM variable info (0x36)", frames[3].Command)
+	assert.Equal(t, DirectionSent, frames[3].Direction)
+	assert.Equal(t, "RAM variable info (0x8e)", frames[4].Command)
+	assert.Equal(t, DirectionReceived, frames[4].Direction)
+
+	leds, _ := fieldValue(frames[45], "leds")
+	assert.Equal(t, "led_absorb=on led_mains=on", leds.Value)
+	input, _ := fieldValue(frames[47], "active_input")
+	assert.Equal(t, InputAC2.String(), input.Value)
+}
+
+func TestDecoderWithoutScales(t *testing.T) {
+	d := NewDecoder()
+	_, dc := buildFrame(0x20, 0xf3, 0x00, 0xc8, 0x02, 0x0c, 0xa1, 0x05, 0x00, 0x00, 0x00, 0x28, 0x00, 0x00, 0x88)
+	f := d.Decode(DirectionReceived, dc)
+	field, ok := fieldValue(f, "battery_voltage")
+	require.True(t, ok)
+	assert.Equal(t, "1441.000", field.Value)
+	assert.Equal(t, "unscaled", field.Scaling)
+
+	dc[3]++
+	f = d.Decode(DirectionReceived, dc)
+	assert.False(t, f.ChecksumValid)
+	assert.Empty(t, f.Fields)
+}
@@ -0,0 +1,28 @@
+package mk2driver
+
+// DerivedValues are computed from the measured values of a report once, so
+// that all consumers use the same numbers. They are zero until computed.
+type DerivedValues struct {
+	// Apparent power of the AC input and output in VA. The input power is
+	// negative while feeding back to the grid.
+	InPower  float64
+	OutPower float64
+
+	// Real power of the AC input and output in W, only set when RealPower is.
+	InRealPower  float64
+	OutRealPower float64
+	RealPower    bool
+
+	// Battery power in W, positive while charging.
+	BatPower float64
+
+	// Power drawn from the grid, the real input power in W when it is known
+	// and the apparent input power in VA otherwise. Negative while feeding
+	// back to the grid.
+	GridPower float64
+
+	// Power lost in the device: the power flowing in from the grid minus the
+	// power flowing out to the loads and the battery. Without the real powers
+	// this mixes VA and W and is only an estimate.
+	Losses float64
+}
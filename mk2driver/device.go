@@ -0,0 +1,67 @@
+package mk2driver
+
+// InterfaceType identifies the MK interface the driver is connected through.
+type InterfaceType int
+
+const (
+	InterfaceUnknown InterfaceType = iota
+	InterfaceMK2
+	InterfaceMK3
+)
+
+var InterfaceNames = map[InterfaceType]string{
+	InterfaceUnknown: "unknown",
+	InterfaceMK2:     "MK2",
+	InterfaceMK3:     "MK3",
+}
+
+func (i InterfaceType) String() string {
+	name, ok := InterfaceNames[i]
+	if !ok {
+		return InterfaceNames[InterfaceUnknown]
+	}
+	return name
+}
+
+// MarshalText encodes the interface type by name.
+func (i InterfaceType) MarshalText() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+// DeviceInfo describes the MK interface and the VE.Bus device behind it.
+type DeviceInfo struct {
+	// Interface type and firmware version as reported in the version frame.
+	Interface        InterfaceType
+	InterfaceVersion uint32
+
+	// VE.Bus firmware version, e.g. 2629467, zero if it is not known. The
+	// leading digits are the product code, the last three the firmware
+	// revision, as used in Victron firmware file names.
+	FirmwareVersion uint32
+	// Product code part of the firmware version. It identifies the firmware
+	// family, which is shared by several models, not the model itself.
+	ProductCode uint32
+}
+
+// FirmwareRevision returns the revision part of the VE.Bus firmware version.
+func (d DeviceInfo) FirmwareRevision() uint32 {
+	return d.FirmwareVersion % 1000
+}
+
+// Identifies the interface from the version number in the version frame.
+// MK2 interfaces report versions starting with 113, MK3 with 117.
+func interfaceType(version uint32) InterfaceType {
+	switch version / 10000 {
+	case 113:
+		return InterfaceMK2
+	case 117:
+		return InterfaceMK3
+	}
+	return InterfaceUnknown
+}
+
+// Sets the VE.Bus firmware version and the product code it contains.
+func (d *DeviceInfo) setFirmware(version uint32) {
+	d.FirmwareVersion = version
+	d.ProductCode = version / 1000
+}
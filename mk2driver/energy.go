@@ -0,0 +1,33 @@
+package mk2driver
+
+// EnergyCounters are amounts of energy in kWh.
+type EnergyCounters struct {
+	// Energy drawn from and fed back to the grid, only counted while the real
+	// powers are known.
+	GridImport float64
+	GridExport float64
+	// Energy supplied to the loads on the AC output, only counted while the
+	// real powers are known.
+	ACOutput float64
+	// Energy flowing into and out of the battery.
+	BatCharge    float64
+	BatDischarge float64
+}
+
+// Add adds the energy of o to c.
+func (c *EnergyCounters) Add(o EnergyCounters) {
+	c.GridImport += o.GridImport
+	c.GridExport += o.GridExport
+	c.ACOutput += o.ACOutput
+	c.BatCharge += o.BatCharge
+	c.BatDischarge += o.BatDischarge
+}
+
+// EnergyValues are the energy counters kept by the core. They are zero when
+// the core does not count energy.
+type EnergyValues struct {
+	// Energy since the counters were first started.
+	Total EnergyCounters
+	// Energy since local midnight.
+	Today EnergyCounters
+}
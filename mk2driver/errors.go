@@ -0,0 +1,61 @@
+package mk2driver
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors reported by the MK2 driver in Mk2Info.Errors. Use errors.Is to test
+// for them, as they are usually wrapped with more detail.
+var (
+	// ErrChecksum is reported when a received frame has an invalid checksum.
+	ErrChecksum = errors.New("invalid frame checksum")
+	// ErrRead is reported when reading from the device fails.
+	ErrRead = errors.New("read error")
+	// ErrWrite is reported when writing a command to the device fails.
+	ErrWrite = errors.New("write error")
+	// ErrTimeout is reported when the device does not reply to a command.
+	ErrTimeout = errors.New("timeout waiting for reply")
+	// ErrUnknownFrame is reported for frames the driver can not decode.
+	ErrUnknownFrame = errors.New("unknown frame")
+	// ErrShortFrame is reported for frames too short for their type.
+	ErrShortFrame = errors.New("frame too short")
+	// ErrLockLost is reported when the driver loses synchronisation with the
+	// incoming frames and has to lock onto the stream again.
+	ErrLockLost = errors.New("frame lock lost")
+
+	// ErrClosed is returned by commands when the driver was closed.
+	ErrClosed = errors.New("driver closed")
+	// ErrBusy is returned by commands when too many commands are waiting
+	// to be sent.
+	ErrBusy = errors.New("too many queued commands")
+	// ErrNotSupported is returned when the device does not support a command.
+	ErrNotSupported = errors.New("command not supported by device")
+	// ErrInvalidState is returned when a command is not allowed in the
+	// current device state.
+	ErrInvalidState = errors.New("command not allowed in current state")
+	// ErrOutOfRange is returned when a value is outside its allowed limits.
+	ErrOutOfRange = errors.New("value out of range")
+	// ErrInvalidCommand is returned when a raw command can not be sent.
+	ErrInvalidCommand = errors.New("invalid command")
+)
+
+// FrameError is an error related to a specific received frame.
+type FrameError struct {
+	Err   error
+	Frame []byte
+}
+
+func (e *FrameError) Error() string {
+	return fmt.Sprintf("%v: %x", e.Err, e.Frame)
+}
+
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+func newFrameError(err error, frame []byte) *FrameError {
+	f := make([]byte, len(frame))
+	copy(f, frame)
+	return &FrameError{Err: err, Frame: f}
+}
@@ -0,0 +1,21 @@
+package mk2driver
+
+import "time"
+
+// ESSState is the state of the ESS assistant grid power setpoint control.
+// Positive setpoints draw power from the grid, negative ones feed power into
+// it.
+type ESSState struct {
+	Enabled bool
+	// Setpoint last requested, in W.
+	Target float64
+	// Setpoint last written to the device, in W.
+	Applied float64
+	// Set while the safe setpoint is applied because the target was not
+	// updated in time.
+	WatchdogTripped bool
+	// Time the setpoint was last written, zero if it never was.
+	Timestamp time.Time
+	// Error of the last write, empty if it succeeded.
+	Error string
+}
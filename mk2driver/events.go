@@ -0,0 +1,45 @@
+package mk2driver
+
+import "time"
+
+// EventType identifies a driver event.
+type EventType int
+
+const (
+	// The device or MK interface rebooted.
+	EventBootup EventType = iota
+	// The driver synchronised to the incoming frames.
+	EventLockAcquired
+	// The driver lost synchronisation with the incoming frames.
+	EventLockLost
+	// The driver started reading the device configuration again.
+	EventRenegotiation
+)
+
+var EventNames = map[EventType]string{
+	EventBootup:        "bootup",
+	EventLockAcquired:  "lock_acquired",
+	EventLockLost:      "lock_lost",
+	EventRenegotiation: "renegotiation",
+}
+
+func (e EventType) String() string {
+	name, ok := EventNames[e]
+	if !ok {
+		return "unknown"
+	}
+	return name
+}
+
+// MarshalText encodes the event type by name.
+func (e EventType) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
+
+// Event is something that happened to the connection between two reports.
+type Event struct {
+	Type      EventType
+	Timestamp time.Time
+	// Optional description, such as the error that caused the lock loss.
+	Detail string
+}
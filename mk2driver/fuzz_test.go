@@ -0,0 +1,89 @@
+package mk2driver
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Adds the frames of all captures in testdata/captures to the seed corpus,
+// each frame from its header up to and including the checksum.
+func addCaptureFrames(f *testing.F) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "captures", "*.txt"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		for _, line := range readCapture(f, path) {
+			frames, _ := SplitFrames(line.data)
+			for _, frame := range frames {
+				f.Add(frame)
+			}
+		}
+	}
+}
+
+// Adds the received bytes of all captures in testdata/captures to the seed
+// corpus.
+func addCaptureStreams(f *testing.F) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "captures", "*.txt"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		f.Add(receivedStream(readCapture(f, path)))
+	}
+}
+
+// Returns a driver that still has to read the device configuration, without
+// a frame locker running.
+func newStartingMk2() *mk2Ser {
+	writeBuffer.Reset()
+	return &mk2Ser{
+		info:         &Mk2Info{},
+		p:            NewIOStub([]byte{}),
+		frameLock:    true,
+		scales:       make([]scaling, 0, scaleVarCount),
+		reads:        ramReads,
+		ramRead:      len(ramReads),
+		run:          make(chan struct{}),
+		transactions: make(chan *transaction, transactionQueueSize),
+	}
+}
+
+// Passes frames with a valid checksum to the driver, both while it reads the
+// device configuration and once it is polling.
+func FuzzHandleFrame(f *testing.F) {
+	addCaptureFrames(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) > 255 {
+			return
+		}
+		l, frame := buildFrame(data...)
+		for _, m := range []*mk2Ser{newStartingMk2(), newReadyMk2()} {
+			m.handleFrame(l, frame)
+		}
+	})
+}
+
+// Splits arbitrary bytes into frames and decodes them.
+func FuzzDecoder(f *testing.F) {
+	addCaptureStreams(f)
+	addCaptureFrames(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := NewDecoder()
+		frames, _ := SplitFrames(data)
+		for _, frame := range frames {
+			d.Decode(DirectionUnknown, frame)
+		}
+		d.Decode(DirectionSent, data)
+		d.Decode(DirectionReceived, data)
+	})
+}
+
+// Feeds arbitrary bytes to the frame locker of a driver.
+func FuzzFrameLocker(f *testing.F) {
+	addCaptureStreams(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		replayStream(t, data)
+	})
+}
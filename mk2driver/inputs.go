@@ -0,0 +1,109 @@
+package mk2driver
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// InputSource is an AC input of the device.
+type InputSource int
+
+const (
+	InputUnknown InputSource = iota
+	InputAC1
+	InputAC2
+)
+
+// MaxACInputs is the number of AC inputs tracked in Mk2Info.Inputs.
+const MaxACInputs = 2
+
+var InputSourceNames = map[InputSource]string{
+	InputUnknown: "unknown",
+	InputAC1:     "ac_in_1",
+	InputAC2:     "ac_in_2",
+}
+
+func (s InputSource) String() string {
+	return InputSourceNames[s]
+}
+
+// MarshalText encodes the input by name.
+func (s InputSource) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ACReading holds the measurements of one AC input. The device only measures
+// the active input, so the values of an inactive input are not available.
+type ACReading struct {
+	// Set while the input is active and its values are measured.
+	Available bool
+	Voltage   float64
+	Current   float64
+	Frequency float64
+	// Time the values were last received while the input was active, zero
+	// if it never was.
+	Timestamp time.Time
+}
+
+// Master multi LED frame data offsets, after the frame header and command.
+const (
+	masterLEDACInputConfig = 3
+	masterLEDLength        = 11
+)
+
+// Request the master multi LED frame, which holds the active AC input.
+func (m *mk2Ser) reqMasterLED() {
+	cmd := make([]byte, 2)
+	cmd[0] = infoReqFrame
+	cmd[1] = infoReqAddrMasterLED
+	m.masterLEDPending = true
+	m.sendCommand(cmd)
+}
+
+// Decode the master multi LED frame. The AC info frame holds the values of
+// the active input, so they are stored as the reading of that input. The
+// other inputs are not measured, their readings are marked unavailable and
+// only keep the time they were last active.
+func (m *mk2Ser) masterLEDDecode(frame []byte) {
+	m.masterLEDPending = false
+	active := decodeActiveInput(frame)
+	m.info.ActiveInput = active
+	for input := InputAC1; input <= MaxACInputs; input++ {
+		reading := &m.info.Inputs[input-InputAC1]
+		if input != active {
+			*reading = ACReading{Timestamp: reading.Timestamp}
+			continue
+		}
+		*reading = ACReading{
+			Available: true,
+			Voltage:   m.info.InVoltage,
+			Current:   m.info.InCurrent,
+			Frequency: m.info.InFrequency,
+			Timestamp: m.info.SampleTimes.AC,
+		}
+	}
+	m.startRAMReads()
+}
+
+// Returns the active AC input of the master multi LED frame.
+func decodeActiveInput(frame []byte) InputSource {
+	active := InputSource(frame[masterLEDACInputConfig]&0x0f) + InputAC1
+	if active > MaxACInputs {
+		logrus.Warnf("Unknown active AC input %d", frame[masterLEDACInputConfig])
+		return InputUnknown
+	}
+	return active
+}
+
+// Handles a master multi LED request that was not answered before the next
+// poll cycle. Devices that do not support it do not reply, so it is not
+// requested again until the device configuration is read again.
+func (m *mk2Ser) masterLEDTimeout() {
+	if !m.masterLEDPending {
+		return
+	}
+	logrus.Warn("VE.Bus device does not report the active AC input")
+	m.masterLEDPending = false
+	m.masterLEDUnsupported = true
+}
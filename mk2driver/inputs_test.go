@@ -0,0 +1,69 @@
+package mk2driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func masterLEDFrame(acInputConfig byte) (byte, []byte) {
+	return buildFrame(0xff, 0x41, 0x01, 0x00, 0x00, acInputConfig, 0x10, 0x00, 0xe8, 0x03, 0xe8, 0x03, 0x00)
+}
+
+func TestMasterLEDDecode(t *testing.T) {
+	m := newReadyMk2()
+	m.infochan = make(chan *Mk2Info, 2)
+	m.ramUnsupported = map[int]bool{0: true, 1: true, 2: true}
+
+	// Input 1 active
+	first := time.Now()
+	m.info.InVoltage = 230
+	m.info.InFrequency = 50
+	m.info.SampleTimes.AC = first
+	m.ledDecode([]byte{0x00, 0x00, 0x00, 0x00})
+	assert.Equal(t, []byte{0x03, 0xff, 0x46, 0x05, 0xb3}, writeBuffer.Bytes(), "Master LED not requested")
+	m.handleFrame(masterLEDFrame(0x00))
+	info := <-m.infochan
+	assert.Equal(t, InputAC1, info.ActiveInput)
+	assert.Equal(t, ACReading{Available: true, Voltage: 230, Frequency: 50, Timestamp: first}, info.Input(InputAC1))
+	assert.Equal(t, ACReading{}, info.Input(InputAC2), "Never active input available")
+
+	// Switched over to input 2
+	second := first.Add(time.Second)
+	m.info.InVoltage = 240
+	m.info.InCurrent = 1
+	m.info.SampleTimes.AC = second
+	m.ledDecode([]byte{0x00, 0x00, 0x00, 0x00})
+	m.handleFrame(masterLEDFrame(0x01))
+	info = <-m.infochan
+	assert.Equal(t, InputAC2, info.ActiveInput)
+	assert.Equal(t, ACReading{Available: true, Voltage: 240, Current: 1, Frequency: 50, Timestamp: second}, info.Input(InputAC2))
+	// The inactive input is not measured, its last values are not repeated.
+	assert.Equal(t, ACReading{Timestamp: first}, info.Input(InputAC1))
+	assert.Equal(t, ACReading{}, info.Input(InputUnknown))
+
+	// Back to input 1 with the values measured on it.
+	third := second.Add(time.Second)
+	m.info.InVoltage = 228
+	m.info.InCurrent = 2
+	m.info.SampleTimes.AC = third
+	m.ledDecode([]byte{0x00, 0x00, 0x00, 0x00})
+	m.handleFrame(masterLEDFrame(0x00))
+	info = <-m.infochan
+	assert.Equal(t, ACReading{Available: true, Voltage: 228, Current: 2, Frequency: 50, Timestamp: third}, info.Input(InputAC1))
+	assert.Equal(t, ACReading{Timestamp: second}, info.Input(InputAC2))
+}
+
+func TestMasterLEDNotSupported(t *testing.T) {
+	m := newReadyMk2()
+	m.ledDecode([]byte{0x00, 0x00, 0x00, 0x00})
+	// No reply before the next cycle
+	m.handleFrame(buildFrame(versionFrame...))
+	assert.True(t, m.masterLEDUnsupported)
+
+	writeBuffer.Reset()
+	m.ledDecode([]byte{0x00, 0x00, 0x00, 0x00})
+	assert.Equal(t, []byte{0x05, 0xff, 0x57, 0x30, 0x0d, 0x00, 0x68}, writeBuffer.Bytes(), "Master LED requested again")
+	assert.Equal(t, InputUnknown, m.info.ActiveInput)
+}
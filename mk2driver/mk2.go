@@ -1,7 +1,6 @@
 package mk2driver
 
 import (
-	"errors"
 	"fmt"
 	"io"
 	"sync"
@@ -40,6 +39,20 @@ const (
 	ramVarMaxOffset = 14
 )
 
+// RAM variables outside of the sequential range above.
+const (
+	ramVarBatTemperature = 0x1a
+)
+
+// Scale factors of these RAM variables are read after the sequential ones.
+var extraScaleVars = []byte{ramVarBatTemperature}
+
+// Index in mk2Ser.scales of the extra scale factors.
+const (
+	scaleBatTemperature = ramVarMaxOffset + iota
+	scaleVarCount
+)
+
 const (
 	infoFrameHeader   = 0x20
 	frameHeader       = 0xff
@@ -56,19 +69,50 @@ const (
 	winmonFrame    = 0x57
 )
 
+// Minimum lengths of received frames, from the header up to and including
+// the checksum.
+const (
+	minFrameLength      = 3
+	versionFrameLength  = 7
+	infoFrameTypeLength = 6
+	infoFrameLength     = 15
+	ledFrameLength      = 5
+	winmonReplyLength   = 4
+	firmwareReplyLength = 6
+)
+
 // info frame types
 const (
-	infoReqAddrDC   = 0x00
-	infoReqAddrACL1 = 0x01
+	infoReqAddrDC        = 0x00
+	infoReqAddrACL1      = 0x01
+	infoReqAddrMasterLED = 0x05
 )
 
 // winmon frame commands
 const (
-	commandReadRAMVar    = 0x30
-	commandGetRAMVarInfo = 0x36
-
-	commandReadRAMResponse       = 0x85
-	commandGetRAMVarInfoResponse = 0x8E
+	commandSendSoftwareVersionPart0 = 0x05
+	commandSendSoftwareVersionPart1 = 0x06
+	commandGetSetDeviceState        = 0x0E
+	commandReadRAMVar               = 0x30
+	commandReadSetting              = 0x31
+	commandWriteRAMVar              = 0x32
+	commandWriteSetting             = 0x33
+	commandWriteData                = 0x34
+	commandGetSettingInfo           = 0x35
+	commandGetRAMVarInfo            = 0x36
+
+	commandNotSupportedResponse         = 0x80
+	commandSoftwareVersionPart0Response = 0x82
+	commandSoftwareVersionPart1Response = 0x83
+	commandReadRAMResponse              = 0x85
+	commandReadSettingResponse          = 0x86
+	commandWriteRAMResponse             = 0x87
+	commandWriteSettingResponse         = 0x88
+	commandGetSettingInfoResponse       = 0x89
+	commandGetRAMVarInfoResponse        = 0x8E
+	commandVariableNotSupportedResponse = 0x90
+	commandSettingNotSupportedResponse  = 0x91
+	commandGetSetDeviceStateResponse    = 0x94
 )
 
 type mk2Ser struct {
@@ -80,6 +124,49 @@ type mk2Ser struct {
 	frameLock  bool
 	infochan   chan *Mk2Info
 	wg         sync.WaitGroup
+	stats      protocolStats
+	// Set once the first frame lock was acquired.
+	hasLocked bool
+
+	// Protects state read by the driver API.
+	stateLock sync.Mutex
+	device    DeviceInfo
+	// Status decoded from the last LED frame.
+	status Status
+	// Last virtual switch and relay state.
+	switches SwitchState
+	// Set once the VE.Bus firmware version was read or found to be unsupported.
+	firmwareDone bool
+	// Low part of the VE.Bus firmware version while reading the high part.
+	firmwareLow uint32
+
+	// Set while the master multi LED frame is requested, and once the device
+	// did not answer it.
+	masterLEDPending     bool
+	masterLEDUnsupported bool
+
+	// RAM variable groups read in the poll cycle, ramReads followed by the
+	// monitored assistant variables.
+	reads []ramRead
+	// Index in reads of the group being read in the poll cycle.
+	ramRead int
+	// Indexes in reads of groups the device does not support.
+	ramUnsupported map[int]bool
+
+	// Set once the assistants were read, and while a header is requested.
+	assistantsDone   bool
+	assistantPending bool
+	// RAM variable ID of the requested assistant header.
+	assistantNext byte
+	// Loaded assistants and the monitored assistant variables, protected by
+	// stateLock. readsChanged is set when reads has to be rebuilt.
+	assistants    []Assistant
+	assistantVars []AssistantVar
+	readsChanged  bool
+
+	// Commands queued through the driver API and the one awaiting a reply.
+	transactions chan *transaction
+	pending      *transaction
 }
 
 func NewMk2Connection(dev io.ReadWriter) (Mk2, error) {
@@ -88,10 +175,13 @@ func NewMk2Connection(dev io.ReadWriter) (Mk2, error) {
 	mk2.info = &Mk2Info{}
 	mk2.scaleCount = 0
 	mk2.frameLock = false
-	mk2.scales = make([]scaling, 0, ramVarMaxOffset)
+	mk2.scales = make([]scaling, 0, scaleVarCount)
+	mk2.reads = ramReads
+	mk2.ramRead = len(ramReads)
 	mk2.setTarget()
 	mk2.run = make(chan struct{})
 	mk2.infochan = make(chan *Mk2Info)
+	mk2.transactions = make(chan *transaction, transactionQueueSize)
 	mk2.wg.Add(1)
 	go mk2.frameLocker()
 	return mk2, nil
@@ -113,11 +203,9 @@ func (m *mk2Ser) frameLocker() {
 			frameLengthOffset := int(frameLength) + 1
 			l, err := io.ReadFull(m.p, frame[:frameLengthOffset])
 			if err != nil {
-				m.addError(fmt.Errorf("Read Error: %v", err))
-				m.frameLock = false
+				m.loseLock(fmt.Errorf("%w: %v", ErrRead, err))
 			} else if l != frameLengthOffset {
-				m.addError(errors.New("Read Length Error"))
-				m.frameLock = false
+				m.loseLock(fmt.Errorf("%w: short frame", ErrRead))
 			} else {
 				m.handleFrame(frameLength, frame[:frameLengthOffset])
 			}
@@ -127,14 +215,22 @@ func (m *mk2Ser) frameLocker() {
 			if tmp == frameHeader || tmp == infoFrameHeader {
 				l, err := io.ReadFull(m.p, frame[:frameLengthOffset])
 				if err != nil {
-					m.addError(fmt.Errorf("Read Error: %v", err))
-					time.Sleep(1 * time.Second)
+					m.addError(fmt.Errorf("%w: %v", ErrRead, err))
+					select {
+					case <-m.run:
+					case <-time.After(1 * time.Second):
+					}
 				} else if l != frameLengthOffset {
-					m.addError(errors.New("Read Length Error"))
+					m.addError(fmt.Errorf("%w: short frame", ErrRead))
 				} else {
 					if checkChecksum(frameLength, tmp, frame[:frameLengthOffset]) {
 						m.frameLock = true
-						logrus.Info("Locked")
+						if m.hasLocked {
+							m.stats.resync()
+						}
+						m.hasLocked = true
+						m.stats.commandAbort()
+						m.addEvent(EventLockAcquired, "")
 					}
 				}
 			}
@@ -143,12 +239,31 @@ func (m *mk2Ser) frameLocker() {
 	}
 }
 
+// Drops the frame lock so the frame locker synchronises to the stream again.
+func (m *mk2Ser) loseLock(err error) {
+	m.addError(fmt.Errorf("%w: %w", ErrLockLost, err))
+	m.addEvent(EventLockLost, err.Error())
+	m.frameLock = false
+}
+
 // Close Mk2
 func (m *mk2Ser) Close() {
 	close(m.run)
 	m.wg.Wait()
 }
 
+// Stats returns the protocol health counters of the connection.
+func (m *mk2Ser) Stats() Stats {
+	return m.stats.get()
+}
+
+// DeviceInfo returns what is known about the interface and VE.Bus device.
+func (m *mk2Ser) DeviceInfo() DeviceInfo {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	return m.device
+}
+
 func (m *mk2Ser) C() chan *Mk2Info {
 	return m.infochan
 }
@@ -157,7 +272,7 @@ func (m *mk2Ser) readByte() byte {
 	buffer := make([]byte, 1)
 	_, err := io.ReadFull(m.p, buffer)
 	if err != nil {
-		m.addError(fmt.Errorf("Read error: %v", err))
+		m.addError(fmt.Errorf("%w: %v", ErrRead, err))
 		return 0
 	}
 	return buffer[0]
@@ -173,59 +288,166 @@ func (m *mk2Ser) addError(err error) {
 	m.info.Valid = false
 }
 
+// Adds a command the device did not answer to the errors of the next report.
+// Unlike addError it does not invalidate the report, as the values that were
+// received are still current.
+func (m *mk2Ser) addTimeout(detail string) {
+	err := fmt.Errorf("%w: %s", ErrTimeout, detail)
+	logrus.Warnf("Mk2 timeout: %v", err)
+	m.info.Errors = append(m.info.Errors, err)
+}
+
+// Adds event to the next report.
+func (m *mk2Ser) addEvent(eventType EventType, detail string) {
+	if detail == "" {
+		logrus.Infof("Mk2 event: %v", eventType)
+	} else {
+		logrus.Infof("Mk2 event: %v: %s", eventType, detail)
+	}
+	m.info.Events = append(m.info.Events, Event{
+		Type:      eventType,
+		Timestamp: time.Now(),
+		Detail:    detail,
+	})
+}
+
 // Updates report.
 func (m *mk2Ser) updateReport() {
 	m.info.Timestamp = time.Now()
+	if m.scaleCount >= scaleVarCount {
+		m.info.Unsupported = m.unsupportedValues()
+	}
 	select {
 	case m.infochan <- m.info:
 	default:
 	}
-	m.info = &Mk2Info{}
+	// Start the next report from the last received values so that groups
+	// that are not refreshed keep their original sample times.
+	next := *m.info
+	next.Valid = false
+	next.Errors = nil
+	next.Events = nil
+	m.info = &next
 }
 
 // Checks for valid frame and chooses decoding.
 func (m *mk2Ser) handleFrame(l byte, frame []byte) {
 	logrus.Debugf("[handleFrame] frame %#v", frame)
 	if checkChecksum(l, frame[0], frame[1:]) {
+		m.stats.frame(frameTypeName(frame))
+		if m.handleTransactionReply(frame) {
+			m.startCycle()
+			return
+		}
 		switch frame[0] {
 		case bootupFrameHeader:
-			m.setTarget()
+			m.stats.bootup()
+			m.addEvent(EventBootup, "")
+			m.renegotiate()
 		case frameHeader:
+			if m.shortFrame(frame, minFrameLength) {
+				return
+			}
 			switch frame[1] {
 			case vFrame:
-				m.versionDecode(frame[2:])
+				if !m.shortFrame(frame, versionFrameLength) {
+					m.versionDecode(frame[2:])
+				}
+			case setTargetFrame:
+				m.stats.commandReply()
+				if len(frame) > 2+masterLEDLength {
+					m.masterLEDDecode(frame[2:])
+				}
 			case winmonFrame:
+				m.stats.commandReply()
+				if m.shortFrame(frame, winmonReplyLength) {
+					return
+				}
 				switch frame[2] {
 				case commandGetRAMVarInfoResponse:
 					m.scaleDecode(frame[2:])
 				case commandReadRAMResponse:
-					m.stateDecode(frame[2:])
+					if m.assistantPending {
+						m.assistantDecode(frame[2:])
+					} else {
+						m.ramDecode(frame[2:])
+					}
+				case commandVariableNotSupportedResponse:
+					m.notSupportedDecode(frame[2:])
+				case commandSoftwareVersionPart0Response:
+					if !m.shortFrame(frame, firmwareReplyLength) {
+						m.firmwareDecode(frame[2:], 0)
+					}
+				case commandSoftwareVersionPart1Response:
+					if !m.shortFrame(frame, firmwareReplyLength) {
+						m.firmwareDecode(frame[2:], 1)
+					}
+				case commandNotSupportedResponse:
+					m.notSupportedDecode(frame[2:])
 				default:
-					logrus.Warnf("[handleFrame] invalid winmonFrame %v", frame[2:])
+					logrus.Warnf("[handleFrame] invalid winmonFrame: %v", newFrameError(ErrUnknownFrame, frame))
 				}
 
 			case ledFrame:
-				m.ledDecode(frame[2:])
+				m.stats.commandReply()
+				if !m.shortFrame(frame, ledFrameLength) {
+					m.ledDecode(frame[2:])
+				}
 			default:
-				logrus.Warnf("[handleFrame] invalid frameHeader %v", frame[1])
+				logrus.Warnf("[handleFrame] invalid frameHeader: %v", newFrameError(ErrUnknownFrame, frame))
 			}
 
 		case infoFrameHeader:
+			if m.shortFrame(frame, infoFrameTypeLength) {
+				return
+			}
 			switch frame[5] {
 			case dcInfoFrame:
-				m.dcDecode(frame[1:])
+				m.stats.commandReply()
+				if !m.shortFrame(frame, infoFrameLength) {
+					m.dcDecode(frame[1:])
+				}
 			case acL1InfoFrame:
-				m.acDecode(frame[1:])
+				m.stats.commandReply()
+				if !m.shortFrame(frame, infoFrameLength) {
+					m.acDecode(frame[1:])
+				}
 			default:
-				logrus.Warnf("[handleFrame] invalid infoFrameHeader %v", frame[5])
+				logrus.Warnf("[handleFrame] invalid infoFrameHeader: %v", newFrameError(ErrUnknownFrame, frame))
 			}
 		default:
-			logrus.Warnf("[handleFrame] Invalid frame %v", frame[0])
+			logrus.Warnf("[handleFrame] Invalid frame: %v", newFrameError(ErrUnknownFrame, frame))
 		}
 	} else {
-		logrus.Errorf("[handleFrame] Invalid incoming frame checksum: %x", frame)
-		m.frameLock = false
+		m.stats.checksumError()
+		m.loseLock(newFrameError(ErrChecksum, frame))
+	}
+}
+
+// Reports frames shorter than length, the minimum length of their type, so
+// that they are not decoded.
+func (m *mk2Ser) shortFrame(frame []byte, length int) bool {
+	if len(frame) >= length {
+		return false
 	}
+	m.addError(newFrameError(ErrShortFrame, frame))
+	return true
+}
+
+// Starts reading the device configuration again after a bootup, as the
+// device may have been reconfigured or updated.
+func (m *mk2Ser) renegotiate() {
+	m.addEvent(EventRenegotiation, "")
+	m.scales = m.scales[:0]
+	m.scaleCount = 0
+	m.firmwareDone = false
+	m.ramUnsupported = nil
+	m.masterLEDUnsupported = false
+	m.assistantsDone = false
+	m.stateLock.Lock()
+	m.assistants = nil
+	m.stateLock.Unlock()
+	m.setTarget()
 }
 
 // Set the target VBus device.
@@ -237,15 +459,23 @@ func (m *mk2Ser) setTarget() {
 	m.sendCommand(cmd)
 }
 
-// Request the scaling factor for entry 'in'.
-func (m *mk2Ser) reqScaleFactor(in byte) {
+// Request the scaling factor for entry 'in' of m.scales.
+func (m *mk2Ser) reqScaleFactor(in int) {
 	cmd := make([]byte, 4)
 	cmd[0] = winmonFrame
 	cmd[1] = commandGetRAMVarInfo
-	cmd[2] = in
+	cmd[2] = scaleVar(in)
 	m.sendCommand(cmd)
 }
 
+// Returns the RAM variable of entry 'in' of m.scales.
+func scaleVar(in int) byte {
+	if in < ramVarMaxOffset {
+		return byte(in)
+	}
+	return extraScaleVars[in-ramVarMaxOffset]
+}
+
 func int16Abs(in int16) uint16 {
 	if in < 0 {
 		return uint16(-in)
@@ -255,68 +485,155 @@ func int16Abs(in int16) uint16 {
 
 // Decode the scale factor frame.
 func (m *mk2Ser) scaleDecode(frame []byte) {
-	tmp := scaling{}
 	logrus.Debugf("Scale frame(%d): 0x%x", len(frame), frame)
-	if len(frame) < 6 {
-		tmp.supported = false
+	tmp := parseScaling(frame)
+	if !tmp.supported {
 		logrus.Warnf("Skiping scaling factors for: %d", m.scaleCount)
-	} else {
-		tmp.supported = true
-		var scl int16
-		var ofs int16
-		if len(frame) == 6 {
-			scl = int16(frame[2])<<8 + int16(frame[1])
-			ofs = int16(uint16(frame[4])<<8 + uint16(frame[3]))
-		} else {
-			scl = int16(frame[2])<<8 + int16(frame[1])
-			ofs = int16(uint16(frame[5])<<8 + uint16(frame[4]))
-		}
-		if scl < 0 {
-			tmp.signed = true
-		}
-		tmp.offset = float64(ofs)
-		scale := int16Abs(scl)
-		if scale >= 0x4000 {
-			tmp.scale = 1 / (0x8000 - float64(scale))
-		} else {
-			tmp.scale = float64(scale)
-		}
 	}
 	logrus.Debugf("scalecount %v: %#v \n", m.scaleCount, tmp)
 	m.scales = append(m.scales, tmp)
 	m.scaleCount++
-	if m.scaleCount < ramVarMaxOffset {
-		m.reqScaleFactor(byte(m.scaleCount))
+	if m.scaleCount < scaleVarCount {
+		m.reqScaleFactor(m.scaleCount)
 	} else {
 		logrus.Info("Monitoring starting.")
 	}
 }
 
+// Parses a RAM variable info reply, starting at the response byte. Replies
+// too short to hold a scale factor are unsupported.
+func parseScaling(frame []byte) scaling {
+	tmp := scaling{}
+	if len(frame) < 6 {
+		return tmp
+	}
+	tmp.supported = true
+	var scl int16
+	var ofs int16
+	if len(frame) == 6 {
+		scl = int16(frame[2])<<8 + int16(frame[1])
+		ofs = int16(uint16(frame[4])<<8 + uint16(frame[3]))
+	} else {
+		scl = int16(frame[2])<<8 + int16(frame[1])
+		ofs = int16(uint16(frame[5])<<8 + uint16(frame[4]))
+	}
+	if scl < 0 {
+		tmp.signed = true
+	}
+	tmp.offset = float64(ofs)
+	scale := int16Abs(scl)
+	if scale >= 0x4000 {
+		tmp.scale = 1 / (0x8000 - float64(scale))
+	} else {
+		tmp.scale = float64(scale)
+	}
+	return tmp
+}
+
 // Decode the version number
 func (m *mk2Ser) versionDecode(frame []byte) {
 	logrus.Debugf("versiondecode %v", frame)
+	m.masterLEDTimeout()
+	m.assistantTimeout()
+	// A pending transaction reports its own timeout.
+	if m.stats.commandTimeout() && m.pending == nil {
+		m.addTimeout("previous poll cycle did not complete")
+	}
 	m.info.Version = 0
 	m.info.Valid = true
 	for i := 0; i < 4; i++ {
-		m.info.Version += uint32(frame[i]) << uint(i) * 8
+		m.info.Version += uint32(frame[i]) << (uint(i) * 8)
 	}
 
-	if m.scaleCount < ramVarMaxOffset {
+	m.stateLock.Lock()
+	m.device.InterfaceVersion = m.info.Version
+	m.device.Interface = interfaceType(m.info.Version)
+	m.info.Device = m.device
+	m.stateLock.Unlock()
+
+	m.startCycle()
+}
+
+// Starts a poll cycle. Queued transactions are sent first, the cycle starts
+// once they have been answered.
+func (m *mk2Ser) startCycle() {
+	if m.nextTransaction() {
+		return
+	}
+	if m.scaleCount < scaleVarCount {
 		logrus.Info("Get scaling factors.")
-		m.reqScaleFactor(byte(m.scaleCount))
+		m.reqScaleFactor(m.scaleCount)
+	} else if !m.firmwareDone {
+		m.reqFirmwareVersion(commandSendSoftwareVersionPart0)
+	} else if !m.assistantsDone {
+		m.reqAssistantHeader(assistantRAMStart)
 	} else {
-		// Send DC status request
-		cmd := make([]byte, 2)
-		cmd[0] = infoReqFrame
-		cmd[1] = infoReqAddrDC
-		m.sendCommand(cmd)
+		m.reqDCInfo()
 	}
 }
 
+// Request part of the VE.Bus firmware version.
+func (m *mk2Ser) reqFirmwareVersion(part byte) {
+	cmd := make([]byte, 4)
+	cmd[0] = winmonFrame
+	cmd[1] = part
+	m.sendCommand(cmd)
+}
+
+// Decode a part of the VE.Bus firmware version. Part 0 holds the low and
+// part 1 the high 16 bits.
+func (m *mk2Ser) firmwareDecode(frame []byte, part int) {
+	value := uint32(getUnsigned16(frame[1:3]))
+	if part == 0 {
+		m.firmwareLow = value
+		m.reqFirmwareVersion(commandSendSoftwareVersionPart1)
+		return
+	}
+	m.stateLock.Lock()
+	m.device.setFirmware(m.firmwareLow | value<<16)
+	m.info.Device = m.device
+	m.stateLock.Unlock()
+	m.firmwareDone = true
+	logrus.Infof("VE.Bus device product %d firmware %d", m.info.Device.ProductCode, m.info.Device.FirmwareVersion)
+	m.startCycle()
+}
+
+// Handles a reply to a winmon command the device does not support.
+func (m *mk2Ser) notSupportedDecode(frame []byte) {
+	if m.scaleCount < scaleVarCount {
+		// Too short to hold a scale factor, so it is marked unsupported.
+		m.scaleDecode(frame[:1])
+		return
+	}
+	if !m.firmwareDone {
+		logrus.Warn("VE.Bus device does not report its firmware version")
+		m.firmwareDone = true
+		m.startCycle()
+		return
+	}
+	if m.assistantPending {
+		m.assistantsDiscovered()
+		return
+	}
+	if m.ramRead < len(m.reads) {
+		m.ramNotSupported()
+		return
+	}
+	logrus.Warn("[handleFrame] winmon command not supported")
+}
+
+// Request the DC info frame which starts a poll cycle.
+func (m *mk2Ser) reqDCInfo() {
+	cmd := make([]byte, 2)
+	cmd[0] = infoReqFrame
+	cmd[1] = infoReqAddrDC
+	m.sendCommand(cmd)
+}
+
 // Decode with correct signedness and apply scale
 func (m *mk2Ser) applyScaleAndSign(data []byte, scale int) float64 {
 	var value float64
-	if !m.scales[scale].supported {
+	if scale >= len(m.scales) || !m.scales[scale].supported {
 		return 0
 	}
 	if m.scales[scale].signed {
@@ -329,7 +646,7 @@ func (m *mk2Ser) applyScaleAndSign(data []byte, scale int) float64 {
 
 // Apply scaling to float
 func (m *mk2Ser) applyScale(value float64, scale int) float64 {
-	if !m.scales[scale].supported {
+	if scale >= len(m.scales) || !m.scales[scale].supported {
 		return value
 	}
 	return m.scales[scale].scale * (value + m.scales[scale].offset)
@@ -352,36 +669,51 @@ func getUnsigned(data []byte) float64 {
 
 // Decodes DC frame.
 func (m *mk2Ser) dcDecode(frame []byte) {
+	m.decodeDC(frame)
+
+	// Send L1 status request
+	cmd := make([]byte, 2)
+	cmd[0] = infoReqFrame
+	cmd[1] = infoReqAddrACL1
+	m.sendCommand(cmd)
+}
+
+// Decodes the values of the DC frame.
+func (m *mk2Ser) decodeDC(frame []byte) {
 	m.info.BatVoltage = m.applyScaleAndSign(frame[5:7], ramVarVBat)
 
 	usedC := m.applyScale(getUnsigned(frame[7:10]), ramVarIBat)
 	chargeC := m.applyScale(getUnsigned(frame[10:13]), ramVarIBat)
 	m.info.BatCurrent = usedC - chargeC
+	m.info.BatInverterCurrent = usedC
+	m.info.BatChargerCurrent = chargeC
 
 	m.info.OutFrequency = m.calcFreq(frame[13], ramVarInverterPeriod)
+	m.info.InverterPeriod = m.calcPeriod(frame[13], ramVarInverterPeriod)
+	m.info.SampleTimes.DC = time.Now()
 	logrus.Debugf("dcDecode %#v", m.info)
-
-	// Send L1 status request
-	cmd := make([]byte, 2)
-	cmd[0] = infoReqFrame
-	cmd[1] = infoReqAddrACL1
-	m.sendCommand(cmd)
 }
 
 // Decodes AC frame.
 func (m *mk2Ser) acDecode(frame []byte) {
+	m.decodeAC(frame)
+
+	// Send status request
+	cmd := make([]byte, 1)
+	cmd[0] = ledFrame
+	m.sendCommand(cmd)
+}
+
+// Decodes the values of the AC frame.
+func (m *mk2Ser) decodeAC(frame []byte) {
 	m.info.InVoltage = m.applyScale(getSigned(frame[5:7]), ramVarVMains)
 	m.info.InCurrent = m.applyScale(getSigned(frame[7:9]), ramVarIMains)
 	m.info.OutVoltage = m.applyScale(getSigned(frame[9:11]), ramVarVInverter)
 	m.info.OutCurrent = m.applyScale(getSigned(frame[11:13]), ramVarIInverter)
 	m.info.InFrequency = m.calcFreq(frame[13], ramVarMainPeriod)
+	m.info.SampleTimes.AC = time.Now()
 
 	logrus.Debugf("acDecode %#v", m.info)
-
-	// Send status request
-	cmd := make([]byte, 1)
-	cmd[0] = ledFrame
-	m.sendCommand(cmd)
 }
 
 func (m *mk2Ser) calcFreq(data byte, scaleIndex int) float64 {
@@ -391,23 +723,40 @@ func (m *mk2Ser) calcFreq(data byte, scaleIndex int) float64 {
 	return 10 / (m.applyScale(float64(data), scaleIndex))
 }
 
+// Returns the period in seconds.
+func (m *mk2Ser) calcPeriod(data byte, scaleIndex int) float64 {
+	if data == 0xff || data == 0x00 {
+		return 0
+	}
+	return m.applyScale(float64(data), scaleIndex) / 10
+}
+
 // Decode charge state of battery.
-func (m *mk2Ser) stateDecode(frame []byte) {
-	m.info.ChargeState = m.applyScaleAndSign(frame[1:3], ramVarChargeState)
+func (m *mk2Ser) stateDecode(data []byte) {
+	m.info.ChargeState = m.applyScaleAndSign(data[0:2], ramVarChargeState)
+	m.info.SampleTimes.ChargeState = time.Now()
 	logrus.Debugf("battery state decode %#v", m.info)
-	m.updateReport()
+}
+
+// Decode the battery temperature.
+func (m *mk2Ser) temperatureDecode(data []byte) {
+	m.info.BatTemperature = m.applyScaleAndSign(data[0:2], scaleBatTemperature)
 }
 
 // Decode the LED state frame.
 func (m *mk2Ser) ledDecode(frame []byte) {
 
 	m.info.LEDs = getLEDs(frame[0], frame[1])
-	// Send charge state request
-	cmd := make([]byte, 4)
-	cmd[0] = winmonFrame
-	cmd[1] = commandReadRAMVar
-	cmd[2] = ramVarChargeState
-	m.sendCommand(cmd)
+	m.info.Status = DecodeStatus(m.info.LEDs)
+	m.stateLock.Lock()
+	m.status = m.info.Status
+	m.stateLock.Unlock()
+	m.info.SampleTimes.LEDs = time.Now()
+	if m.masterLEDUnsupported {
+		m.startRAMReads()
+		return
+	}
+	m.reqMasterLED()
 }
 
 // Adds active LEDs to list.
@@ -430,22 +779,28 @@ func getLEDs(ledsOn, ledsBlink byte) map[Led]LEDstate {
 
 // Adds header and trailing crc for frame to send.
 func (m *mk2Ser) sendCommand(data []byte) {
-	l := len(data)
-	dataOut := make([]byte, l+3)
-	dataOut[0] = byte(l + 1)
-	dataOut[1] = frameHeader
-	cr := -dataOut[0] - dataOut[1]
-	for i := 0; i < len(data); i++ {
-		cr = cr - data[i]
-		dataOut[i+2] = data[i]
-	}
-	dataOut[l+2] = cr
+	dataOut := append([]byte{byte(len(data) + 1)}, CommandFrame(data)...)
 
 	logrus.Debugf("sendCommand %#v", dataOut)
+	m.stats.commandStart()
 	_, err := m.p.Write(dataOut)
 	if err != nil {
-		m.addError(fmt.Errorf("Write error: %v", err))
+		m.addError(fmt.Errorf("%w: %v", ErrWrite, err))
+	}
+}
+
+// CommandFrame returns the frame sent for data, the command after the 0xff
+// header, from its header up to and including the checksum.
+func CommandFrame(data []byte) []byte {
+	frame := make([]byte, len(data)+2)
+	frame[0] = frameHeader
+	cr := -byte(len(data)+1) - frameHeader
+	for i, d := range data {
+		cr -= d
+		frame[i+1] = d
 	}
+	frame[len(data)+1] = cr
+	return frame
 }
 
 // Checks the frame crc.
@@ -2,8 +2,10 @@ package mk2driver
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -24,10 +26,17 @@ var knownWrites = []byte{
 	0x05, 0xff, 0x57, 0x36, 0x0b, 0x00, 0x64,
 	0x05, 0xff, 0x57, 0x36, 0x0c, 0x00, 0x63,
 	0x05, 0xff, 0x57, 0x36, 0x0d, 0x00, 0x62,
+	0x05, 0xff, 0x57, 0x36, 0x1a, 0x00, 0x55,
+	0x05, 0xff, 0x57, 0x05, 0x00, 0x00, 0xa0,
+	0x05, 0xff, 0x57, 0x06, 0x00, 0x00, 0x9f,
+	0x05, 0xff, 0x57, 0x30, 0x80, 0x00, 0xf5,
 	0x03, 0xff, 0x46, 0x00, 0xb8,
 	0x03, 0xff, 0x46, 0x01, 0xb7,
 	0x02, 0xff, 0x4c, 0xb3,
+	0x03, 0xff, 0x46, 0x05, 0xb3,
 	0x05, 0xff, 0x57, 0x30, 0x0d, 0x00, 0x68,
+	0x05, 0xff, 0x57, 0x30, 0x0a, 0x0c, 0x5f,
+	0x05, 0xff, 0x57, 0x30, 0x1a, 0x00, 0x5b,
 }
 
 var writeBuffer = bytes.NewBuffer(nil)
@@ -48,6 +57,118 @@ func NewIOStub(readBuffer []byte) io.ReadWriter {
 	}
 }
 
+// Returns a driver that finished reading its configuration, without a frame
+// locker running. Frames are passed to handleFrame directly and writes end
+// up in writeBuffer.
+func newReadyMk2() *mk2Ser {
+	writeBuffer.Reset()
+	scales := make([]scaling, scaleVarCount)
+	for i := range scales {
+		scales[i] = scaling{scale: 1, supported: true}
+	}
+	return &mk2Ser{
+		info:           &Mk2Info{},
+		p:              NewIOStub([]byte{}),
+		frameLock:      true,
+		scales:         scales,
+		scaleCount:     scaleVarCount,
+		firmwareDone:   true,
+		assistantsDone: true,
+		reads:          ramReads,
+		ramRead:        len(ramReads),
+		run:            make(chan struct{}),
+		transactions:   make(chan *transaction, transactionQueueSize),
+	}
+}
+
+// Waits until a transaction was queued on m.
+func waitQueued(t *testing.T, m *mk2Ser) {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		if len(m.transactions) > 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("No transaction queued")
+}
+
+// Builds a frame with length and checksum from its header and data.
+func buildFrame(data ...byte) (byte, []byte) {
+	l := byte(len(data))
+	frame := append([]byte{}, data...)
+	cr := -l
+	for _, b := range data {
+		cr -= b
+	}
+	return l, append(frame, cr)
+}
+
+var versionFrame = []byte{0xff, 0x56, 0x96, 0x3e, 0x11, 0x00, 0x00}
+
+// Frames received from and sent to a device while the driver reads its
+// configuration and completes a poll cycle.
+var basicReadBuffer = []byte{
+	//Len  Cmd
+	0x04, 0xff, 0x41, 0x01, 0x00, 0xbb,
+	0x07, 0xff, 0x56, 0x96, 0x3e, 0x11, 0x00, 0x00, 0xbf,
+	0x08, 0xff, 0x57, 0x8e, 0x9c, 0x7f, 0x8f, 0x00, 0x00, 0x6a,
+	0x08, 0xff, 0x57, 0x8e, 0x64, 0x80, 0x8f, 0x00, 0x00, 0xa1,
+	0x08, 0xff, 0x57, 0x8e, 0x9c, 0x7f, 0x8f, 0x00, 0x00, 0x6a,
+	0x08, 0xff, 0x57, 0x8e, 0x9c, 0x7f, 0x8f, 0x00, 0x00, 0x6a,
+	0x08, 0xff, 0x57, 0x8e, 0x9c, 0x7f, 0x8f, 0x00, 0x00, 0x6a,
+	0x08, 0xff, 0x57, 0x8e, 0x64, 0x80, 0x8f, 0x00, 0x00, 0xa1,
+	0x08, 0xff, 0x57, 0x8e, 0x9c, 0x7f, 0x8f, 0x00, 0x00, 0x6a,
+	0x08, 0xff, 0x57, 0x8e, 0x57, 0x78, 0x8f, 0x00, 0x01, 0xb5,
+	0x08, 0xff, 0x57, 0x8e, 0x2f, 0x7c, 0x8f, 0x00, 0x00, 0xda,
+	0x08, 0xff, 0x57, 0x8e, 0x64, 0x80, 0x8f, 0x00, 0x00, 0xa1,
+	0x08, 0xff, 0x57, 0x8e, 0x04, 0x00, 0x8f, 0x00, 0x80, 0x01,
+	0x08, 0xff, 0x57, 0x8e, 0x01, 0x00, 0x8f, 0x00, 0x80, 0x04,
+	0x08, 0xff, 0x57, 0x8e, 0x02, 0x00, 0x8f, 0x00, 0x80, 0x03,
+	0x08, 0xff, 0x57, 0x8e, 0x38, 0x7f, 0x8f, 0x00, 0x00, 0xce,
+	0x08, 0xff, 0x57, 0x8e, 0x64, 0x80, 0x8f, 0x00, 0x00, 0xa1, // battery temperature
+	0x07, 0xff, 0x56, 0x96, 0x3e, 0x11, 0x00, 0x00, 0xbf,
+	0x05, 0xff, 0x57, 0x82, 0x5b, 0x1f, 0xa9, // firmware part 0
+	0x05, 0xff, 0x57, 0x83, 0x28, 0x00, 0xfa, // firmware part 1
+	0x05, 0xff, 0x57, 0x85, 0x00, 0x00, 0x20, // no assistants
+	0x0f, 0x20, 0xf3, 0x00, 0xc8, 0x02, 0x0c, 0xa1, 0x05, 0x00, 0x00, 0x00, 0x28, 0x00, 0x00, 0x88, 0xb2,
+	0x0f, 0x20, 0x01, 0x01, 0xca, 0x09, 0x08, 0xaa, 0x58, 0xab, 0x00, 0xaa, 0x58, 0x9a, 0x00, 0xc3, 0xe8,
+	0x06, 0xff, 0x4c, 0x03, 0x00, 0x00, 0x00, 0xac,
+	0x0d, 0xff, 0x41, 0x03, 0x00, 0x00, 0x01, 0x10, 0x00, 0xe8, 0x03, 0xe8, 0x03, 0x00, 0xc9, // master LED
+	0x05, 0xff, 0x57, 0x85, 0xc8, 0x00, 0x58,
+	0x07, 0xff, 0x57, 0x85, 0x01, 0x00, 0x00, 0x00, 0x1d, // switches
+	0x05, 0xff, 0x57, 0x85, 0xf6, 0x09, 0x21, // battery temperature
+}
+
+var basicWrites = []byte{
+	0x04, 0xff, 0x41, 0x01, 0x00, 0xbb,
+	0x05, 0xff, 0x57, 0x36, 0x00, 0x00, 0x6f,
+	0x05, 0xff, 0x57, 0x36, 0x01, 0x00, 0x6e,
+	0x05, 0xff, 0x57, 0x36, 0x02, 0x00, 0x6d,
+	0x05, 0xff, 0x57, 0x36, 0x03, 0x00, 0x6c,
+	0x05, 0xff, 0x57, 0x36, 0x04, 0x00, 0x6b,
+	0x05, 0xff, 0x57, 0x36, 0x05, 0x00, 0x6a,
+	0x05, 0xff, 0x57, 0x36, 0x06, 0x00, 0x69,
+	0x05, 0xff, 0x57, 0x36, 0x07, 0x00, 0x68,
+	0x05, 0xff, 0x57, 0x36, 0x08, 0x00, 0x67,
+	0x05, 0xff, 0x57, 0x36, 0x09, 0x00, 0x66,
+	0x05, 0xff, 0x57, 0x36, 0x0a, 0x00, 0x65,
+	0x05, 0xff, 0x57, 0x36, 0x0b, 0x00, 0x64,
+	0x05, 0xff, 0x57, 0x36, 0x0c, 0x00, 0x63,
+	0x05, 0xff, 0x57, 0x36, 0x0d, 0x00, 0x62,
+	0x05, 0xff, 0x57, 0x36, 0x1a, 0x00, 0x55,
+	0x05, 0xff, 0x57, 0x05, 0x00, 0x00, 0xa0,
+	0x05, 0xff, 0x57, 0x06, 0x00, 0x00, 0x9f,
+	0x05, 0xff, 0x57, 0x30, 0x80, 0x00, 0xf5,
+	0x03, 0xff, 0x46, 0x00, 0xb8,
+	0x03, 0xff, 0x46, 0x01, 0xb7,
+	0x02, 0xff, 0x4c, 0xb3,
+	0x03, 0xff, 0x46, 0x05, 0xb3,
+	0x05, 0xff, 0x57, 0x30, 0x0d, 0x00, 0x68,
+	0x05, 0xff, 0x57, 0x30, 0x0a, 0x0c, 0x5f,
+	0x05, 0xff, 0x57, 0x30, 0x1a, 0x00, 0x5b,
+}
+
 // Test a know sequence as reference as extracted from Mk2
 func TestSync(t *testing.T) {
 	tests := []struct {
@@ -57,63 +178,29 @@ func TestSync(t *testing.T) {
 		result          Mk2Info
 	}{
 		{
-			name: "basic",
-			knownReadBuffer: []byte{
-				//Len  Cmd
-				0x04, 0xff, 0x41, 0x01, 0x00, 0xbb,
-				0x07, 0xff, 0x56, 0x96, 0x3e, 0x11, 0x00, 0x00, 0xbf,
-				0x08, 0xff, 0x57, 0x8e, 0x9c, 0x7f, 0x8f, 0x00, 0x00, 0x6a,
-				0x08, 0xff, 0x57, 0x8e, 0x64, 0x80, 0x8f, 0x00, 0x00, 0xa1,
-				0x08, 0xff, 0x57, 0x8e, 0x9c, 0x7f, 0x8f, 0x00, 0x00, 0x6a,
-				0x08, 0xff, 0x57, 0x8e, 0x9c, 0x7f, 0x8f, 0x00, 0x00, 0x6a,
-				0x08, 0xff, 0x57, 0x8e, 0x9c, 0x7f, 0x8f, 0x00, 0x00, 0x6a,
-				0x08, 0xff, 0x57, 0x8e, 0x64, 0x80, 0x8f, 0x00, 0x00, 0xa1,
-				0x08, 0xff, 0x57, 0x8e, 0x9c, 0x7f, 0x8f, 0x00, 0x00, 0x6a,
-				0x08, 0xff, 0x57, 0x8e, 0x57, 0x78, 0x8f, 0x00, 0x01, 0xb5,
-				0x08, 0xff, 0x57, 0x8e, 0x2f, 0x7c, 0x8f, 0x00, 0x00, 0xda,
-				0x08, 0xff, 0x57, 0x8e, 0x64, 0x80, 0x8f, 0x00, 0x00, 0xa1,
-				0x08, 0xff, 0x57, 0x8e, 0x04, 0x00, 0x8f, 0x00, 0x80, 0x01,
-				0x08, 0xff, 0x57, 0x8e, 0x01, 0x00, 0x8f, 0x00, 0x80, 0x04,
-				0x08, 0xff, 0x57, 0x8e, 0x02, 0x00, 0x8f, 0x00, 0x80, 0x03,
-				0x08, 0xff, 0x57, 0x8e, 0x38, 0x7f, 0x8f, 0x00, 0x00, 0xce,
-				0x07, 0xff, 0x56, 0x96, 0x3e, 0x11, 0x00, 0x00, 0xbf,
-				0x0f, 0x20, 0xf3, 0x00, 0xc8, 0x02, 0x0c, 0xa1, 0x05, 0x00, 0x00, 0x00, 0x28, 0x00, 0x00, 0x88, 0xb2,
-				0x0f, 0x20, 0x01, 0x01, 0xca, 0x09, 0x08, 0xaa, 0x58, 0xab, 0x00, 0xaa, 0x58, 0x9a, 0x00, 0xc3, 0xe8,
-				0x06, 0xff, 0x4c, 0x03, 0x00, 0x00, 0x00, 0xac,
-				0x05, 0xff, 0x57, 0x85, 0xc8, 0x00, 0x58,
-			},
-			knownWrites: []byte{
-				0x04, 0xff, 0x41, 0x01, 0x00, 0xbb,
-				0x05, 0xff, 0x57, 0x36, 0x00, 0x00, 0x6f,
-				0x05, 0xff, 0x57, 0x36, 0x01, 0x00, 0x6e,
-				0x05, 0xff, 0x57, 0x36, 0x02, 0x00, 0x6d,
-				0x05, 0xff, 0x57, 0x36, 0x03, 0x00, 0x6c,
-				0x05, 0xff, 0x57, 0x36, 0x04, 0x00, 0x6b,
-				0x05, 0xff, 0x57, 0x36, 0x05, 0x00, 0x6a,
-				0x05, 0xff, 0x57, 0x36, 0x06, 0x00, 0x69,
-				0x05, 0xff, 0x57, 0x36, 0x07, 0x00, 0x68,
-				0x05, 0xff, 0x57, 0x36, 0x08, 0x00, 0x67,
-				0x05, 0xff, 0x57, 0x36, 0x09, 0x00, 0x66,
-				0x05, 0xff, 0x57, 0x36, 0x0a, 0x00, 0x65,
-				0x05, 0xff, 0x57, 0x36, 0x0b, 0x00, 0x64,
-				0x05, 0xff, 0x57, 0x36, 0x0c, 0x00, 0x63,
-				0x05, 0xff, 0x57, 0x36, 0x0d, 0x00, 0x62,
-				0x03, 0xff, 0x46, 0x00, 0xb8,
-				0x03, 0xff, 0x46, 0x01, 0xb7,
-				0x02, 0xff, 0x4c, 0xb3,
-				0x05, 0xff, 0x57, 0x30, 0x0d, 0x00, 0x68,
-			},
+			name:            "basic",
+			knownReadBuffer: basicReadBuffer,
+			knownWrites:     basicWrites,
 			result: Mk2Info{
-				Version:      uint32(2736),
-				BatVoltage:   14.41,
-				BatCurrent:   -0.4,
-				InVoltage:    226.98,
-				InCurrent:    1.71,
-				InFrequency:  50.10256410256411,
-				OutVoltage:   226.980,
-				OutCurrent:   1.54,
-				OutFrequency: 50.025510204081634,
-				ChargeState:  1,
+				Version: uint32(1130134),
+				Device: DeviceInfo{
+					Interface:        InterfaceMK2,
+					InterfaceVersion: 1130134,
+					FirmwareVersion:  2629467,
+					ProductCode:      2629,
+				},
+				BatVoltage:     14.41,
+				BatCurrent:     -0.4,
+				BatTemperature: 25.5,
+				InVoltage:      226.98,
+				InCurrent:      1.71,
+				InFrequency:    50.10256410256411,
+				ActiveInput:    InputAC2,
+				OutVoltage:     226.980,
+				OutCurrent:     1.54,
+				OutFrequency:   50.025510204081634,
+				ChargeState:    1,
+				Unsupported:    []string{},
 				LEDs: map[Led]LEDstate{
 					LedMain:        LedOn,
 					LedAbsorption:  LedOn,
@@ -124,6 +211,14 @@ func TestSync(t *testing.T) {
 					LedLowBattery:  LedOff,
 					LedTemperature: LedOff,
 				},
+				Status: Status{
+					ChargerStage: ChargerAbsorption,
+					ACInput:      ACInputAccepted,
+				},
+				Switches: SwitchState{
+					Supported:     true,
+					VirtualSwitch: true,
+				},
 			},
 		},
 		{
@@ -146,24 +241,35 @@ func TestSync(t *testing.T) {
 				0x08, 0xff, 0x57, 0x8e, 0x1, 0x0, 0x8f, 0x0, 0x80, 0x4, // scale 11
 				0x08, 0xff, 0x57, 0x8e, 0x6, 0x0, 0x8f, 0x0, 0x80, 0xff, // scale 12
 				0x08, 0xff, 0x57, 0x8e, 0x38, 0x7f, 0x8f, 0x0, 0x0, 0xce, // scale 13
+				0x05, 0xff, 0x57, 0x90, 0x00, 0x00, 0x15, // battery temperature not supported
 				0x07, 0xff, 0x56, 0x98, 0x3e, 0x11, 0x0, 0x0, 0xbd, // version
+				0x05, 0xff, 0x57, 0x80, 0x00, 0x00, 0x25, // firmware version not supported
+				0x05, 0xff, 0x57, 0x90, 0x00, 0x00, 0x15, // assistants not supported
 				0x0f, 0x20, 0xb6, 0x89, 0x6d, 0xb7, 0xc, 0x4e, 0xa, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x88, 0x82, // dc info
 				0x0f, 0x20, 0x1, 0x1, 0x6d, 0xb7, 0x8, 0x77, 0x5b, 0x21, 0x0, 0x77, 0x5b, 0xfe, 0xff, 0xc3, 0x1e, // ac info
 				0x08, 0xff, 0x4c, 0x9, 0x0, 0x0, 0x0, 0x3, 0x0, 0xa1,
+				0x0d, 0xff, 0x41, 0x09, 0x00, 0x00, 0x00, 0x10, 0x00, 0xe8, 0x03, 0xe8, 0x03, 0x00, 0xc4, // master LED
 				0x05, 0xff, 0x57, 0x85, 0xc8, 0x0, 0x58,
+				0x05, 0xff, 0x57, 0x90, 0x00, 0x00, 0x15, // switches not supported
 			},
 			knownWrites: []byte{},
 			result: Mk2Info{
-				Version:      0xac0,
+				Version: 1130136,
+				Device: DeviceInfo{
+					Interface:        InterfaceMK2,
+					InterfaceVersion: 1130136,
+				},
 				BatVoltage:   26.38,
 				BatCurrent:   0,
 				InVoltage:    234.15,
 				InCurrent:    0.33,
 				InFrequency:  50.1025641025641,
+				ActiveInput:  InputAC1,
 				OutVoltage:   234.15,
 				OutCurrent:   -0.02,
 				OutFrequency: 50.025510204081634,
 				ChargeState:  1,
+				Unsupported:  []string{ValueBatTemperature},
 				LEDs: map[Led]LEDstate{
 					LedMain:        LedOn,
 					LedAbsorption:  LedOff,
@@ -174,12 +280,17 @@ func TestSync(t *testing.T) {
 					LedLowBattery:  LedOff,
 					LedTemperature: LedOff,
 				},
+				Status: Status{
+					ChargerStage: ChargerFloat,
+					ACInput:      ACInputAccepted,
+				},
 			},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			writeBuffer.Reset()
 			testIO := NewIOStub(tt.knownReadBuffer)
 			mk2, err := NewMk2Connection(testIO)
 			assert.NoError(t, err, "Could not open MK2")
@@ -188,26 +299,157 @@ func TestSync(t *testing.T) {
 			mk2.Close()
 
 			if len(tt.knownWrites) > 0 {
-				assert.Equal(t, 0, bytes.Compare(writeBuffer.Bytes(), knownWrites), "Expected writes did not match received writes")
+				assert.Equal(t, knownWrites, writeBuffer.Bytes(), "Expected writes did not match received writes")
 			}
 			assert.True(t, event.Valid, "data not valid")
 			assert.Equal(t, tt.result.Version, event.Version, "Invalid version decoded")
+			assert.Equal(t, tt.result.Device, event.Device, "Invalid device info decoded")
+			assert.Equal(t, tt.result.Device, mk2.(DeviceInfoSource).DeviceInfo(), "Invalid device info reported")
 			assert.Equal(t, 0, len(event.Errors), "Reported errors not empty")
 			assert.Equal(t, tt.result.LEDs, event.LEDs, "Reported LEDs incorrect")
+			assert.Equal(t, tt.result.Status, event.Status, "Reported status incorrect")
+			assert.Equal(t, tt.result.Switches.Supported, event.Switches.Supported, "Switch support incorrect")
+			assert.Equal(t, tt.result.Switches.VirtualSwitch, event.Switches.VirtualSwitch, "Virtual switch incorrect")
+			assert.Equal(t, tt.result.Switches.Relay, event.Switches.Relay, "Relay incorrect")
 
 			assert.InDelta(t, tt.result.BatVoltage, event.BatVoltage, testDelta, "BatVoltage conversion failed")
 			assert.InDelta(t, tt.result.BatCurrent, event.BatCurrent, testDelta, "BatCurrent conversion failed")
+			assert.InDelta(t, event.BatCurrent, event.BatInverterCurrent-event.BatChargerCurrent, testDelta, "BatCurrent split incorrect")
+			assert.InDelta(t, tt.result.BatTemperature, event.BatTemperature, testDelta, "BatTemperature conversion failed")
+			assert.Equal(t, tt.result.Unsupported, event.Unsupported, "Unsupported values incorrect")
 			assert.InDelta(t, tt.result.InVoltage, event.InVoltage, testDelta, "InVoltage conversion failed")
 			assert.InDelta(t, tt.result.InCurrent, event.InCurrent, testDelta, "InCurrent conversion failed")
 			assert.InDelta(t, tt.result.InFrequency, event.InFrequency, testDelta, "InFrequency conversion failed")
 			assert.InDelta(t, tt.result.OutVoltage, event.OutVoltage, testDelta, "OutVoltage conversion failed")
 			assert.InDelta(t, tt.result.OutCurrent, event.OutCurrent, testDelta, "OutCurrent conversion failed")
 			assert.InDelta(t, tt.result.OutFrequency, event.OutFrequency, testDelta, "OutFrequency conversion failed")
+			assert.InDelta(t, 1/tt.result.OutFrequency, event.InverterPeriod, testDelta, "InverterPeriod conversion failed")
 			assert.InDelta(t, tt.result.ChargeState, event.ChargeState, testDelta, "ChargeState conversion failed")
+			assert.Equal(t, tt.result.ActiveInput, event.ActiveInput, "Active input incorrect")
+			active := event.Input(tt.result.ActiveInput)
+			assert.InDelta(t, tt.result.InVoltage, active.Voltage, testDelta, "Active input voltage incorrect")
+			assert.InDelta(t, tt.result.InCurrent, active.Current, testDelta, "Active input current incorrect")
+			assert.InDelta(t, tt.result.InFrequency, active.Frequency, testDelta, "Active input frequency incorrect")
+			assert.Equal(t, event.SampleTimes.AC, active.Timestamp, "Active input time incorrect")
+			assert.Empty(t, event.StaleGroups(), "Reported sample groups stale")
+			if assert.NotEmpty(t, event.Events, "Lock event not reported") {
+				assert.Equal(t, EventLockAcquired, event.Events[0].Type)
+			}
+
+			stats := mk2.(StatsSource).Stats()
+			assert.Equal(t, uint64(0), stats.ChecksumErrors)
+			assert.Equal(t, uint64(1), stats.FramesByType[FrameTypeDCInfo])
+			assert.Equal(t, uint64(1), stats.FramesByType[FrameTypeACInfo])
+			assert.Equal(t, uint64(1), stats.FramesByType[FrameTypeLED])
+			assert.Equal(t, uint64(1), stats.FramesByType[FrameTypeMasterLED])
+			assert.Equal(t, stats.FramesByType[FrameTypeWinmon]+4, stats.CommandReplies)
+		})
+	}
+}
+
+func Test_mk2Ser_handleFrameChecksum(t *testing.T) {
+	m := &mk2Ser{
+		info:      &Mk2Info{Valid: true},
+		p:         NewIOStub([]byte{}),
+		frameLock: true,
+	}
+	// LED frame with its last byte corrupted
+	m.handleFrame(0x06, []byte{0xff, 0x4c, 0x03, 0x00, 0x00, 0x00, 0xad})
+
+	assert.False(t, m.frameLock, "Frame lock not dropped")
+	assert.False(t, m.info.Valid)
+	assert.Len(t, m.info.Errors, 1)
+	assert.True(t, errors.Is(m.info.Errors[0], ErrChecksum))
+	assert.True(t, errors.Is(m.info.Errors[0], ErrLockLost))
+	var frameErr *FrameError
+	assert.True(t, errors.As(m.info.Errors[0], &frameErr))
+	assert.Equal(t, uint64(1), m.Stats().ChecksumErrors)
+	assert.Equal(t, uint64(0), m.Stats().FramesReceived)
+}
+
+func Test_mk2Ser_handleFrameShort(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"header only", []byte{0xff}},
+		{"version", []byte{0xff, 0x56, 0x96, 0x3e}},
+		{"info without type", []byte{0x20, 0x01, 0x02, 0x03}},
+		{"dc info", []byte{0x20, 0x01, 0x02, 0x03, 0x04, 0x0c, 0x00}},
+		{"ac info", []byte{0x20, 0x01, 0x02, 0x03, 0x04, 0x08, 0x00, 0x00, 0x00}},
+		{"led", []byte{0xff, 0x4c, 0x03}},
+		{"winmon", []byte{0xff, 0x57}},
+		{"firmware", []byte{0xff, 0x57, 0x82, 0x10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newReadyMk2()
+			m.handleFrame(buildFrame(tt.data...))
+
+			assert.True(t, m.frameLock, "Frame lock dropped")
+			assert.Len(t, m.info.Errors, 1)
+			assert.True(t, errors.Is(m.info.Errors[0], ErrShortFrame))
+			assert.Empty(t, writeBuffer.Bytes(), "Frame should not be answered")
 		})
 	}
 }
 
+func Test_mk2Ser_handleFrameBootup(t *testing.T) {
+	writeBuffer.Reset()
+	m := &mk2Ser{
+		info:         &Mk2Info{},
+		p:            NewIOStub([]byte{}),
+		frameLock:    true,
+		scales:       make([]scaling, scaleVarCount),
+		scaleCount:   scaleVarCount,
+		firmwareDone: true,
+	}
+	m.handleFrame(0x02, []byte{bootupFrameHeader, 0x00, 0xfe})
+
+	assert.Equal(t, uint64(1), m.Stats().Bootups)
+	assert.Len(t, m.info.Events, 2)
+	assert.Equal(t, EventBootup, m.info.Events[0].Type)
+	assert.Equal(t, EventRenegotiation, m.info.Events[1].Type)
+	assert.Equal(t, 0, m.scaleCount, "Scaling factors not read again")
+	assert.Len(t, m.scales, 0)
+	assert.False(t, m.firmwareDone)
+	assert.Equal(t, []byte{0x04, 0xff, 0x41, 0x01, 0x00, 0xbb}, writeBuffer.Bytes(), "Target not set again")
+}
+
+func Test_interfaceType(t *testing.T) {
+	assert.Equal(t, InterfaceMK2, interfaceType(1130134))
+	assert.Equal(t, InterfaceMK3, interfaceType(1170212))
+	assert.Equal(t, InterfaceUnknown, interfaceType(2736))
+}
+
+func TestDeviceInfo_setFirmware(t *testing.T) {
+	var d DeviceInfo
+	d.setFirmware(2660488)
+	assert.Equal(t, uint32(2660488), d.FirmwareVersion)
+	assert.Equal(t, uint32(2660), d.ProductCode)
+	assert.Equal(t, uint32(488), d.FirmwareRevision())
+}
+
+func TestMk2Info_Stale(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	info := &Mk2Info{
+		Timestamp: now,
+		SampleTimes: SampleTimes{
+			DC:   now.Add(-500 * time.Millisecond),
+			AC:   now.Add(-MaxSampleAge - time.Second),
+			LEDs: now,
+		},
+	}
+
+	assert.Equal(t, 500*time.Millisecond, info.SampleAge(SampleDC))
+	assert.False(t, info.Stale(SampleDC))
+	assert.True(t, info.Stale(SampleAC))
+	assert.False(t, info.Stale(SampleLEDs))
+	assert.Equal(t, time.Duration(0), info.SampleAge(SampleChargeState))
+	assert.True(t, info.Stale(SampleChargeState))
+	assert.Equal(t, []string{"ac", "charge_state"}, info.StaleGroups())
+}
+
 func Test_mk2Ser_scaleDecode(t *testing.T) {
 	tests := []struct {
 		name            string
@@ -40,39 +40,272 @@ var StateNames = map[LEDstate]string{
 	LedBlink: "blink",
 }
 
+// SampleGroup identifies a group of Mk2Info fields that are received from the
+// device in the same frame and therefore share a sample time.
+type SampleGroup int
+
+const (
+	SampleDC SampleGroup = iota
+	SampleAC
+	SampleLEDs
+	SampleChargeState
+)
+
+var SampleGroupNames = map[SampleGroup]string{
+	SampleDC:          "dc",
+	SampleAC:          "ac",
+	SampleLEDs:        "leds",
+	SampleChargeState: "charge_state",
+}
+
+// MaxSampleAge is the age, relative to the report timestamp, after which a
+// sample group is considered stale.
+const MaxSampleAge = 5 * time.Second
+
+// SampleTimes holds the time at which each sample group was last received.
+type SampleTimes struct {
+	// BatVoltage, BatCurrent and OutFrequency
+	DC time.Time
+	// InVoltage, InCurrent, InFrequency, OutVoltage and OutCurrent
+	AC time.Time
+	// LEDs
+	LEDs time.Time
+	// ChargeState
+	ChargeState time.Time
+}
+
+// Get returns the sample time of the given group.
+func (s SampleTimes) Get(group SampleGroup) time.Time {
+	switch group {
+	case SampleDC:
+		return s.DC
+	case SampleAC:
+		return s.AC
+	case SampleLEDs:
+		return s.LEDs
+	case SampleChargeState:
+		return s.ChargeState
+	}
+	return time.Time{}
+}
+
 type Mk2Info struct {
 	// Will be marked as false if an error is detected.
 	Valid bool
 
+	// Version reported by the MK interface
 	Version uint32
 
+	// Interface and VE.Bus device details
+	Device DeviceInfo
+
 	BatVoltage float64
 	// Positive current == charging
 	// Negative current == discharging
 	BatCurrent float64
+	// DC current drawn by the inverter and supplied by the charger, both
+	// positive. BatCurrent is their difference.
+	BatInverterCurrent float64
+	BatChargerCurrent  float64
+	// Battery temperature in degrees Celsius from the temperature sensor.
+	// Refreshed with the ChargeState group.
+	BatTemperature float64
 
 	// Input AC parameters
 	InVoltage   float64
 	InCurrent   float64
 	InFrequency float64
 
+	// AC input the In values are measured on, InputUnknown when the device
+	// does not report it
+	ActiveInput InputSource
+	// Readings of each AC input indexed by InputSource - InputAC1. Only the
+	// active input is measured, the others are not available.
+	Inputs [MaxACInputs]ACReading
+
 	// Output AC parameters
 	OutVoltage   float64
 	OutCurrent   float64
 	OutFrequency float64
+	// Inverter period in seconds
+	InverterPeriod float64
 
 	// Charge state 0.0 to 1.0
 	ChargeState float64
 
+	// Powers computed from the values above, set by the core.
+	Derived DerivedValues
+
+	// Energy counted from the powers above, set by the core.
+	Energy EnergyValues
+
+	// Names of the values the device does not report, see the Value
+	// constants. Empty until the device configuration was read.
+	Unsupported []string
+
+	// Raw signed values of the monitored assistant RAM variables by name.
+	AssistantValues map[string]float64
+
+	// ESS grid setpoint control, only enabled when the setpoint is
+	// controlled by invertergui.
+	ESS ESSState
+
 	// List LEDs
 	LEDs map[Led]LEDstate
 
+	// Charger stage, AC input status and warnings derived from the LEDs
+	Status Status
+
+	// Last state reported by the device in reply to a device state command
+	DeviceState DeviceState
+
+	// Virtual switch and multi-function relay state
+	Switches SwitchState
+
 	Errors []error
 
+	// Events that happened since the previous report.
+	Events []Event
+
+	// Time at which the report was sent.
 	Timestamp time.Time
+
+	// Time at which each group of fields was received. Fields that were not
+	// refreshed in the latest poll cycle keep their previous values and times.
+	SampleTimes SampleTimes
 }
 
+// Copy returns a deep copy of the report that shares no maps or slices with
+// it.
+func (m *Mk2Info) Copy() *Mk2Info {
+	c := *m
+	if m.Unsupported != nil {
+		c.Unsupported = append([]string{}, m.Unsupported...)
+	}
+	if m.AssistantValues != nil {
+		c.AssistantValues = make(map[string]float64, len(m.AssistantValues))
+		for name, value := range m.AssistantValues {
+			c.AssistantValues[name] = value
+		}
+	}
+	if m.LEDs != nil {
+		c.LEDs = make(map[Led]LEDstate, len(m.LEDs))
+		for led, state := range m.LEDs {
+			c.LEDs[led] = state
+		}
+	}
+	if m.Errors != nil {
+		c.Errors = append([]error{}, m.Errors...)
+	}
+	if m.Events != nil {
+		c.Events = append([]Event{}, m.Events...)
+	}
+	return &c
+}
+
+// Supported reports whether the device reports the named value, see the
+// Value constants.
+func (m *Mk2Info) Supported(value string) bool {
+	for _, name := range m.Unsupported {
+		if name == value {
+			return false
+		}
+	}
+	return true
+}
+
+// Input returns the reading of an AC input.
+func (m *Mk2Info) Input(source InputSource) ACReading {
+	if source < InputAC1 || source > MaxACInputs {
+		return ACReading{}
+	}
+	return m.Inputs[source-InputAC1]
+}
+
+// SampleAge returns how old the values of a sample group were when the report
+// was sent. A group that was never received has an age of zero.
+func (m *Mk2Info) SampleAge(group SampleGroup) time.Duration {
+	t := m.SampleTimes.Get(group)
+	if t.IsZero() {
+		return 0
+	}
+	return m.Timestamp.Sub(t)
+}
+
+// Stale reports whether the values of a sample group should not be trusted,
+// either because they were never received or are older than MaxSampleAge.
+func (m *Mk2Info) Stale(group SampleGroup) bool {
+	if m.SampleTimes.Get(group).IsZero() {
+		return true
+	}
+	return m.SampleAge(group) > MaxSampleAge
+}
+
+// StaleGroups returns the names of all stale sample groups.
+func (m *Mk2Info) StaleGroups() []string {
+	stale := []string{}
+	for _, group := range []SampleGroup{SampleDC, SampleAC, SampleLEDs, SampleChargeState} {
+		if m.Stale(group) {
+			stale = append(stale, SampleGroupNames[group])
+		}
+	}
+	return stale
+}
+
+// Mk2 is a source of reports. A report sent on C belongs to the receiver: the
+// sender does not change or keep it after sending, so the receiver may change
+// it. A receiver that hands a report on to others gives up ownership too, or
+// hands on a Copy.
 type Mk2 interface {
 	C() chan *Mk2Info
 	Close()
 }
+
+// DeviceInfoSource is implemented by drivers that can identify the device.
+type DeviceInfoSource interface {
+	DeviceInfo() DeviceInfo
+}
+
+// ChargerController is implemented by drivers that can force the charger
+// into a specific mode.
+type ChargerController interface {
+	SetChargerMode(mode ChargerMode) (DeviceState, error)
+}
+
+// SwitchController is implemented by drivers that can set the virtual switch
+// and the multi-function relay.
+type SwitchController interface {
+	SetSwitch(sw Switch, on bool) (SwitchState, error)
+}
+
+// AssistantMonitor is implemented by drivers that can read the RAM variables
+// of the assistants loaded in the device.
+type AssistantMonitor interface {
+	Assistants() []Assistant
+	MonitorAssistantVars(vars []AssistantVar)
+}
+
+// AssistantWriter is implemented by drivers that can write the RAM variables
+// of the assistants loaded in the device.
+type AssistantWriter interface {
+	WriteAssistantVar(v AssistantVar, value int16) error
+}
+
+// SettingsController is implemented by drivers that can read and write the
+// settings stored in the device.
+type SettingsController interface {
+	ReadSettingInfo(id uint16) (SettingInfo, error)
+	ReadSetting(id uint16) (uint16, error)
+	WriteSetting(id uint16, value uint16) error
+}
+
+// RawTransactor is implemented by drivers that can send raw commands for
+// diagnostics.
+type RawTransactor interface {
+	RawTransaction(cmd []byte) ([]byte, error)
+}
+
+// StatsSource is implemented by drivers that keep protocol health counters.
+type StatsSource interface {
+	Stats() Stats
+}
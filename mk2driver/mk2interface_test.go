@@ -0,0 +1,37 @@
+package mk2driver
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMk2InfoCopy(t *testing.T) {
+	info := &Mk2Info{
+		Valid:           true,
+		BatVoltage:      12.5,
+		Unsupported:     []string{ValueBatTemperature},
+		AssistantValues: map[string]float64{"setpoint": -100},
+		LEDs:            map[Led]LEDstate{LedMain: LedOn},
+		Errors:          []error{errors.New("test")},
+		Events:          []Event{{Type: EventLockLost}},
+		Timestamp:       time.Unix(10, 0),
+	}
+	c := info.Copy()
+	assert.Equal(t, info, c)
+
+	c.Unsupported[0] = ValueInverterPeriod
+	c.AssistantValues["setpoint"] = 100
+	c.LEDs[LedMain] = LedOff
+	c.Errors[0] = nil
+	c.Events[0].Type = EventBootup
+	assert.Equal(t, []string{ValueBatTemperature}, info.Unsupported)
+	assert.Equal(t, -100.0, info.AssistantValues["setpoint"])
+	assert.Equal(t, LedOn, info.LEDs[LedMain])
+	assert.EqualError(t, info.Errors[0], "test")
+	assert.Equal(t, EventLockLost, info.Events[0].Type)
+
+	assert.Equal(t, &Mk2Info{}, (&Mk2Info{}).Copy())
+}
@@ -0,0 +1,74 @@
+package mk2test
+
+import (
+	"sync"
+)
+
+// Device simulates the port of an MK2 interface. Commands written to it are
+// answered with the replies registered for them, and whenever there is no
+// reply left to read the idle frame is sent, the way an MK2 interface sends
+// its version frame. Frames are written and read with their length byte.
+type Device struct {
+	lock    sync.Mutex
+	idle    []byte
+	replies map[string][][]byte
+	// Written bytes that do not form a complete command yet.
+	in  []byte
+	out []byte
+
+	commands int
+}
+
+// NewDevice returns a Device that sends idle when it has nothing else to send.
+func NewDevice(idle []byte) *Device {
+	return &Device{
+		idle:    append([]byte(nil), idle...),
+		replies: map[string][][]byte{},
+	}
+}
+
+// Reply registers the frames sent in reply to command. Commands without a
+// reply are ignored.
+func (d *Device) Reply(command []byte, replies ...[]byte) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var frames [][]byte
+	for _, reply := range replies {
+		frames = append(frames, append([]byte(nil), reply...))
+	}
+	d.replies[string(command)] = frames
+}
+
+// Commands returns the number of complete commands written to the device.
+func (d *Device) Commands() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.commands
+}
+
+// Read never blocks and never fails.
+func (d *Device) Read(b []byte) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if len(d.out) == 0 {
+		d.out = append(d.out, d.idle...)
+	}
+	n := copy(b, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+func (d *Device) Write(b []byte) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.in = append(d.in, b...)
+	for len(d.in) > 0 && len(d.in) >= int(d.in[0])+2 {
+		command := d.in[:int(d.in[0])+2]
+		d.in = d.in[len(command):]
+		d.commands++
+		for _, reply := range d.replies[string(command)] {
+			d.out = append(d.out, reply...)
+		}
+	}
+	return len(b), nil
+}
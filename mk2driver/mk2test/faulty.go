@@ -0,0 +1,168 @@
+// Package mk2test provides a simulated MK2 device and a transport that
+// injects faults, for testing how drivers cope with an unreliable link.
+package mk2test
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrTransient is returned by FaultyPort reads scheduled to fail.
+var ErrTransient = errors.New("mk2test: transient read error")
+
+// defaultMaxGarbage is the maximum number of garbage bytes inserted before a
+// frame when Faults.MaxGarbage is not set.
+const defaultMaxGarbage = 16
+
+// Faults configures the faults injected by a FaultyPort. Probabilities range
+// from 0, never, to 1, always.
+type Faults struct {
+	// Seed of the random source deciding which faults are injected.
+	Seed int64
+	// Probability that a received byte is replaced by another value.
+	CorruptByte float64
+	// Probability that a received byte is dropped.
+	DropByte float64
+	// Probability that a whole received frame is dropped.
+	DropFrame float64
+	// Probability that garbage is inserted before a received frame, and
+	// the maximum number of garbage bytes inserted.
+	Garbage    float64
+	MaxGarbage int
+	// Delay before every read returns.
+	ReadDelay time.Duration
+	// Every ErrorEvery-th read fails with ErrTransient, zero disables
+	// read errors.
+	ErrorEvery int
+}
+
+// FaultStats counts the faults injected by a FaultyPort.
+type FaultStats struct {
+	// Frames read from the wrapped port.
+	Frames         int
+	CorruptedBytes int
+	DroppedBytes   int
+	DroppedFrames  int
+	GarbageBytes   int
+	ReadErrors     int
+}
+
+// FaultyPort wraps the port of an MK2 device and injects faults into the
+// frames read from it. Writes are passed on unchanged.
+type FaultyPort struct {
+	rw io.ReadWriter
+
+	lock    sync.Mutex
+	faults  Faults
+	rand    *rand.Rand
+	pending []byte
+	reads   int
+	stats   FaultStats
+}
+
+// NewFaultyPort returns a FaultyPort injecting faults into the frames read
+// from rw.
+func NewFaultyPort(rw io.ReadWriter, faults Faults) *FaultyPort {
+	return &FaultyPort{
+		rw:     rw,
+		faults: faults,
+		rand:   rand.New(rand.NewSource(faults.Seed)),
+	}
+}
+
+// SetFaults changes the injected faults. The random source is kept, so that
+// a run stays reproducible.
+func (p *FaultyPort) SetFaults(faults Faults) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.faults = faults
+}
+
+// Stats returns the number of faults injected so far.
+func (p *FaultyPort) Stats() FaultStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.stats
+}
+
+func (p *FaultyPort) Read(b []byte) (int, error) {
+	p.lock.Lock()
+	delay := p.faults.ReadDelay
+	n, err := p.read(b)
+	p.lock.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return n, err
+}
+
+func (p *FaultyPort) read(b []byte) (int, error) {
+	p.reads++
+	if p.faults.ErrorEvery > 0 && p.reads%p.faults.ErrorEvery == 0 {
+		p.stats.ReadErrors++
+		return 0, ErrTransient
+	}
+	for len(p.pending) == 0 {
+		if err := p.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+func (p *FaultyPort) Write(b []byte) (int, error) {
+	return p.rw.Write(b)
+}
+
+// Reads the next frame, its length byte followed by the frame and checksum,
+// from the wrapped port and queues it with faults applied. The bytes of a
+// frame cut short by a read error are passed on as they are.
+func (p *FaultyPort) nextFrame() error {
+	frame := make([]byte, 1, 257)
+	if _, err := io.ReadFull(p.rw, frame); err != nil {
+		return err
+	}
+	frame = frame[:int(frame[0])+2]
+	if n, err := io.ReadFull(p.rw, frame[1:]); err != nil {
+		p.pending = append(p.pending, frame[:1+n]...)
+		return nil
+	}
+	p.stats.Frames++
+
+	f := p.faults
+	if p.chance(f.DropFrame) {
+		p.stats.DroppedFrames++
+		return nil
+	}
+	if p.chance(f.Garbage) {
+		max := f.MaxGarbage
+		if max <= 0 {
+			max = defaultMaxGarbage
+		}
+		garbage := make([]byte, 1+p.rand.Intn(max))
+		p.rand.Read(garbage)
+		p.pending = append(p.pending, garbage...)
+		p.stats.GarbageBytes += len(garbage)
+	}
+	for _, c := range frame {
+		if p.chance(f.DropByte) {
+			p.stats.DroppedBytes++
+			continue
+		}
+		if p.chance(f.CorruptByte) {
+			c ^= byte(1 + p.rand.Intn(255))
+			p.stats.CorruptedBytes++
+		}
+		p.pending = append(p.pending, c)
+	}
+	return nil
+}
+
+func (p *FaultyPort) chance(probability float64) bool {
+	return probability > 0 && p.rand.Float64() < probability
+}
@@ -0,0 +1,148 @@
+package mk2test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	versionFrame = []byte{0x07, 0xff, 0x56, 0x96, 0x3e, 0x11, 0x00, 0x00, 0xbf}
+	ledRequest   = []byte{0x02, 0xff, 0x4c, 0xb3}
+	ledFrame     = []byte{0x08, 0xff, 0x4c, 0x09, 0x00, 0x00, 0x00, 0x03, 0x00, 0x9e}
+)
+
+type readWriter struct {
+	io.Reader
+	io.Writer
+}
+
+func frames(n int) []byte {
+	var stream []byte
+	for i := 0; i < n; i++ {
+		stream = append(stream, versionFrame...)
+	}
+	return stream
+}
+
+func TestDevice(t *testing.T) {
+	d := NewDevice(versionFrame)
+	d.Reply(ledRequest, ledFrame)
+
+	buf := make([]byte, len(versionFrame))
+	_, err := io.ReadFull(d, buf)
+	require.NoError(t, err)
+	assert.Equal(t, versionFrame, buf)
+
+	// Commands may be written in parts.
+	_, err = d.Write(ledRequest[:2])
+	require.NoError(t, err)
+	_, err = d.Write(ledRequest[2:])
+	require.NoError(t, err)
+	assert.Equal(t, 1, d.Commands())
+
+	buf = make([]byte, len(ledFrame)+len(versionFrame))
+	_, err = io.ReadFull(d, buf)
+	require.NoError(t, err)
+	assert.Equal(t, append(append([]byte{}, ledFrame...), versionFrame...), buf)
+}
+
+func TestFaultyPortPassThrough(t *testing.T) {
+	var written bytes.Buffer
+	p := NewFaultyPort(&readWriter{Reader: bytes.NewReader(frames(3)), Writer: &written}, Faults{})
+
+	got, err := io.ReadAll(p)
+	require.NoError(t, err)
+	assert.Equal(t, frames(3), got)
+	assert.Equal(t, FaultStats{Frames: 3}, p.Stats())
+
+	_, err = p.Write(ledRequest)
+	require.NoError(t, err)
+	assert.Equal(t, ledRequest, written.Bytes())
+}
+
+func TestFaultyPortFaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		faults Faults
+		check  func(t *testing.T, got []byte, stats FaultStats)
+	}{
+		{
+			name:   "drop frames",
+			faults: Faults{DropFrame: 0.5},
+			check: func(t *testing.T, got []byte, stats FaultStats) {
+				assert.NotZero(t, stats.DroppedFrames)
+				assert.Equal(t, frames(100-stats.DroppedFrames), got)
+			},
+		},
+		{
+			name:   "drop bytes",
+			faults: Faults{DropByte: 0.1},
+			check: func(t *testing.T, got []byte, stats FaultStats) {
+				assert.NotZero(t, stats.DroppedBytes)
+				assert.Len(t, got, 100*len(versionFrame)-stats.DroppedBytes)
+			},
+		},
+		{
+			name:   "corrupt bytes",
+			faults: Faults{CorruptByte: 0.1},
+			check: func(t *testing.T, got []byte, stats FaultStats) {
+				assert.NotZero(t, stats.CorruptedBytes)
+				assert.Len(t, got, 100*len(versionFrame))
+				changed := 0
+				for i, c := range frames(100) {
+					if got[i] != c {
+						changed++
+					}
+				}
+				assert.Equal(t, stats.CorruptedBytes, changed)
+			},
+		},
+		{
+			name:   "garbage",
+			faults: Faults{Garbage: 0.5, MaxGarbage: 4},
+			check: func(t *testing.T, got []byte, stats FaultStats) {
+				assert.NotZero(t, stats.GarbageBytes)
+				assert.Len(t, got, 100*len(versionFrame)+stats.GarbageBytes)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.faults.Seed = 1
+			p := NewFaultyPort(&readWriter{Reader: bytes.NewReader(frames(100))}, tt.faults)
+			got, err := io.ReadAll(p)
+			require.NoError(t, err)
+			stats := p.Stats()
+			assert.Equal(t, 100, stats.Frames)
+			tt.check(t, got, stats)
+		})
+	}
+}
+
+func TestFaultyPortReadErrors(t *testing.T) {
+	p := NewFaultyPort(NewDevice(versionFrame), Faults{ErrorEvery: 3})
+	buf := make([]byte, 1)
+	for i := 1; i <= 9; i++ {
+		_, err := p.Read(buf)
+		if i%3 == 0 {
+			assert.ErrorIs(t, err, ErrTransient)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+	assert.Equal(t, 3, p.Stats().ReadErrors)
+}
+
+func TestFaultyPortSameSeed(t *testing.T) {
+	faults := Faults{Seed: 42, CorruptByte: 0.1, DropByte: 0.05, DropFrame: 0.1, Garbage: 0.1}
+	read := func() []byte {
+		got, err := io.ReadAll(NewFaultyPort(&readWriter{Reader: bytes.NewReader(frames(50))}, faults))
+		require.NoError(t, err)
+		return got
+	}
+	assert.Equal(t, read(), read())
+}
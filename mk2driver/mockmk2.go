@@ -5,12 +5,19 @@ import (
 )
 
 type mock struct {
-	c chan *Mk2Info
+	c        chan *Mk2Info
+	interval time.Duration
 }
 
 func NewMk2Mock() Mk2 {
+	return NewMk2MockInterval(time.Second)
+}
+
+// NewMk2MockInterval returns a mock that sends a report every interval.
+func NewMk2MockInterval(interval time.Duration) Mk2 {
 	tmp := &mock{
-		c: make(chan *Mk2Info, 1),
+		c:        make(chan *Mk2Info, 1),
+		interval: interval,
 	}
 	go tmp.genMockValues()
 	return tmp
@@ -41,6 +48,7 @@ func (m *mock) genMockValues() {
 	mult := 1.0
 	ledState := LedOff
 	for {
+		now := time.Now()
 		input := &Mk2Info{
 			OutCurrent:   2.0 * mult,
 			InCurrent:    2.3 * mult,
@@ -52,11 +60,40 @@ func (m *mock) genMockValues() {
 			OutFrequency: 50 * mult,
 			ChargeState:  1 * mult,
 			Errors:       nil,
-			Timestamp:    time.Now(),
+			Timestamp:    now,
 			Valid:        true,
 			LEDs:         genBaseLeds(ledState),
+			Version:      1170212,
+			Device: DeviceInfo{
+				Interface:        InterfaceMK3,
+				InterfaceVersion: 1170212,
+				FirmwareVersion:  2629467,
+				ProductCode:      2629,
+			},
+			ActiveInput: InputAC1,
+			Inputs: [MaxACInputs]ACReading{
+				{Available: true, Voltage: 230.1 * mult, Current: 2.3 * mult, Frequency: 50 * mult, Timestamp: now},
+			},
+			Switches: SwitchState{
+				Supported:     true,
+				VirtualSwitch: ledState == LedOn,
+				Relay:         ledState == LedOn,
+				Timestamp:     now,
+			},
+			SampleTimes: SampleTimes{
+				DC:          now,
+				AC:          now,
+				LEDs:        now,
+				ChargeState: now,
+			},
+			BatChargerCurrent: 10 * mult,
+			BatTemperature:    25 * mult,
+			InverterPeriod:    0.02 * mult,
+			Unsupported:       []string{},
 		}
 
+		input.Status = DecodeStatus(input.LEDs)
+
 		ledState = (ledState + 1) % 3
 
 		mult = mult - 0.1
@@ -64,6 +101,6 @@ func (m *mock) genMockValues() {
 			mult = 1.0
 		}
 		m.c <- input
-		time.Sleep(1 * time.Second)
+		time.Sleep(m.interval)
 	}
 }
@@ -0,0 +1,136 @@
+package mk2driver
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// ramRead is a group of RAM variables read with one command at the end of a
+// poll cycle.
+type ramRead struct {
+	ids []byte
+	// Index in mk2Ser.scales of the scale factor the values need, the read
+	// is skipped when it is not supported.
+	scale      int
+	needsScale bool
+	// Names of the Mk2Info values decoded from the variables.
+	values []string
+	// Decodes the reply data, two bytes per variable in ids.
+	decode func(m *mk2Ser, data []byte)
+}
+
+// RAM variables read in every poll cycle, after the LED frame. The reads of
+// monitored assistant variables follow them in mk2Ser.reads.
+var ramReads = []ramRead{
+	{ids: []byte{ramVarChargeState}, values: []string{ValueChargeState}, decode: (*mk2Ser).stateDecode},
+	{ids: []byte{ramVarVirSwitchPos, ramVarMultiFuncRelay}, decode: (*mk2Ser).switchDecode},
+	{
+		ids:        []byte{ramVarBatTemperature},
+		scale:      scaleBatTemperature,
+		needsScale: true,
+		values:     []string{ValueBatTemperature},
+		decode:     (*mk2Ser).temperatureDecode,
+	},
+}
+
+// Builds the command to read the RAM variables ids.
+func readRAMCommand(ids []byte) []byte {
+	cmd := []byte{winmonFrame, commandReadRAMVar}
+	cmd = append(cmd, ids...)
+	// Winmon commands carry at least two data bytes.
+	for len(cmd) < 4 {
+		cmd = append(cmd, 0x00)
+	}
+	return cmd
+}
+
+// Starts reading the RAM variables of the poll cycle.
+func (m *mk2Ser) startRAMReads() {
+	m.updateReads()
+	m.ramRead = 0
+	m.reqNextRAMRead()
+}
+
+// Requests the next group of RAM variables not known to be unsupported, or
+// sends the report once all groups were read.
+func (m *mk2Ser) reqNextRAMRead() {
+	for m.ramRead < len(m.reads) && !m.ramReadSupported(m.ramRead) {
+		m.ramRead++
+	}
+	if m.ramRead >= len(m.reads) {
+		m.updateReport()
+		return
+	}
+	m.sendCommand(readRAMCommand(m.reads[m.ramRead].ids))
+}
+
+func (m *mk2Ser) ramReadSupported(i int) bool {
+	if m.ramUnsupported[i] {
+		return false
+	}
+	read := m.reads[i]
+	return !read.needsScale || m.scaleSupported(read.scale)
+}
+
+// Decodes the reply to a RAM variable read of the poll cycle.
+func (m *mk2Ser) ramDecode(frame []byte) {
+	if m.ramRead >= len(m.reads) {
+		logrus.Warnf("[handleFrame] unexpected RAM variable reply: %x", frame)
+		return
+	}
+	read := m.reads[m.ramRead]
+	// Response byte, two bytes per variable and the checksum.
+	if len(frame) < 2+2*len(read.ids) {
+		m.addError(newFrameError(ErrShortFrame, frame))
+	} else {
+		read.decode(m, frame[1:])
+	}
+	m.ramRead++
+	m.reqNextRAMRead()
+}
+
+// Handles a RAM variable read the device does not support. The variables
+// are not requested again until the device configuration is read again.
+func (m *mk2Ser) ramNotSupported() {
+	logrus.Warnf("VE.Bus device does not support RAM variables %v", m.reads[m.ramRead].ids)
+	if m.ramUnsupported == nil {
+		m.ramUnsupported = map[int]bool{}
+	}
+	m.ramUnsupported[m.ramRead] = true
+	m.ramRead++
+	m.reqNextRAMRead()
+}
+
+// Writes value to the RAM variable id.
+func (m *mk2Ser) writeRAMVar(id byte, value uint16) error {
+	cmd := []byte{winmonFrame, commandWriteRAMVar, id, 0x00}
+	reply, err := m.transact(matchWinmonReply(cmd), nil,
+		cmd,
+		[]byte{winmonFrame, commandWriteData, byte(value), byte(value >> 8)},
+	)
+	if err != nil {
+		return err
+	}
+	if reply[2] != commandWriteRAMResponse {
+		return newFrameError(ErrNotSupported, reply)
+	}
+	return nil
+}
+
+// Reads the RAM variables ids outside of the poll cycle and returns their
+// data, two bytes per variable. apply is called by the frame locker with the
+// same data to update the driver state.
+func (m *mk2Ser) readRAMVars(ids []byte, apply func(data []byte)) ([]byte, error) {
+	cmd := readRAMCommand(ids)
+	reply, err := m.transact(matchWinmonReply(cmd), func(frame []byte) {
+		if frame[2] == commandReadRAMResponse && len(frame) >= 4+2*len(ids) {
+			apply(frame[3:])
+		}
+	}, cmd)
+	if err != nil {
+		return nil, err
+	}
+	if reply[2] != commandReadRAMResponse || len(reply) < 4+2*len(ids) {
+		return nil, newFrameError(ErrNotSupported, reply)
+	}
+	return reply[3:], nil
+}
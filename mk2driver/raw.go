@@ -0,0 +1,79 @@
+package mk2driver
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Maximum length of a raw command, without the frame header and checksum.
+const maxRawCommandLength = 32
+
+// FrameType returns the type name of a frame starting at its header, one of
+// the FrameType constants.
+func FrameType(frame []byte) string {
+	if len(frame) == 0 {
+		return FrameTypeUnknown
+	}
+	return frameTypeName(frame)
+}
+
+// Returns a matcher for the reply to cmd, by the frame type and response
+// byte the command is answered with. Commands the driver does not know are
+// matched by frame type only.
+func matchRaw(cmd []byte) func(frame []byte) bool {
+	switch cmd[0] {
+	case winmonFrame:
+		if len(cmd) < 2 {
+			return matchWinmon
+		}
+		return matchWinmonReply(cmd)
+	case infoReqFrame:
+		return func(frame []byte) bool {
+			if len(cmd) < 2 {
+				return frame[0] == infoFrameHeader
+			}
+			switch cmd[1] {
+			case infoReqAddrDC:
+				return FrameType(frame) == FrameTypeDCInfo
+			case infoReqAddrMasterLED:
+				return FrameType(frame) == FrameTypeMasterLED
+			}
+			// AC info frames of phase 1 to 4 are of type 0x08 down to 0x05.
+			return len(frame) >= infoFrameTypeLength && frame[0] == infoFrameHeader && frame[5] == acL1InfoFrame+infoReqAddrACL1-cmd[1]
+		}
+	case setTargetFrame:
+		return func(frame []byte) bool {
+			switch FrameType(frame) {
+			case FrameTypeSetTarget, FrameTypeMasterLED:
+				return true
+			}
+			return false
+		}
+	}
+	// Other commands are answered with a frame of the same command letter.
+	return func(frame []byte) bool {
+		return len(frame) >= minFrameLength && frame[0] == frameHeader && frame[1] == cmd[0]
+	}
+}
+
+// RawTransaction sends cmd between poll cycles and returns its reply, from
+// its header up to and including the checksum. The reply is the first frame
+// received after cmd of the type and, for winmon commands, with the response
+// the command is answered with. cmd is the frame data after the 0xff header,
+// starting with the command letter, the length, header and checksum are
+// added by the driver.
+//
+// The driver does not interpret the command, a command that changes the
+// device state can confuse the poll cycle until the device configuration is
+// read again.
+func (m *mk2Ser) RawTransaction(cmd []byte) ([]byte, error) {
+	if len(cmd) == 0 || len(cmd) > maxRawCommandLength {
+		return nil, fmt.Errorf("%w: length must be 1 to %d bytes", ErrInvalidCommand, maxRawCommandLength)
+	}
+	if cmd[0] == vFrame {
+		return nil, fmt.Errorf("%w: version frames can not be matched as reply", ErrInvalidCommand)
+	}
+	logrus.Infof("Sending raw command %x", cmd)
+	return m.transact(matchRaw(cmd), nil, append([]byte(nil), cmd...))
+}
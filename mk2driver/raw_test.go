@@ -0,0 +1,98 @@
+package mk2driver
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawTransaction(t *testing.T) {
+	m := newReadyMk2()
+
+	var reply []byte
+	var err error
+	done := make(chan struct{})
+	go func() {
+		reply, err = m.RawTransaction([]byte{0x57, 0x30, 0x0d, 0x00})
+		close(done)
+	}()
+	waitQueued(t, m)
+	writeBuffer.Reset()
+	m.handleFrame(buildFrame(versionFrame...))
+	assert.Equal(t, []byte{0x05, 0xff, 0x57, 0x30, 0x0d, 0x00, 0x68}, writeBuffer.Bytes())
+	_, frame := buildFrame(0xff, 0x57, 0x85, 0x10, 0x00)
+	m.handleFrame(buildFrame(0xff, 0x57, 0x85, 0x10, 0x00))
+	<-done
+
+	assert.NoError(t, err)
+	assert.Equal(t, frame, reply)
+}
+
+func TestRawTransactionInvalid(t *testing.T) {
+	m := newReadyMk2()
+	for _, cmd := range [][]byte{nil, make([]byte, maxRawCommandLength+1), {vFrame}} {
+		_, err := m.RawTransaction(cmd)
+		assert.True(t, errors.Is(err, ErrInvalidCommand), "Unexpected error %v for %x", err, cmd)
+	}
+}
+
+func TestMatchRaw(t *testing.T) {
+	bootup := []byte{0x00, 0x00}
+	version := []byte{0xff, 0x56, 0x24, 0xdb, 0x11, 0x00, 0x42}
+	led := []byte{0xff, 0x4c, 0x00, 0x00, 0x00}
+	dcInfo := []byte{0x20, 0x00, 0x00, 0x00, 0x00, 0x0c}
+	acL1Info := []byte{0x20, 0x00, 0x00, 0x00, 0x00, 0x08}
+	ramRead := []byte{0xff, 0x57, 0x85, 0x10, 0x00, 0x10}
+	notSupported := []byte{0xff, 0x57, 0x90, 0x00, 0x00, 0x00}
+	writeRAM := []byte{0xff, 0x57, 0x87, 0x00, 0x00, 0x00}
+
+	tests := []struct {
+		name  string
+		cmd   []byte
+		match [][]byte
+		other [][]byte
+	}{
+		{
+			name:  "read RAM variable",
+			cmd:   []byte{0x57, 0x30, 0x0d, 0x00},
+			match: [][]byte{ramRead, notSupported},
+			other: [][]byte{bootup, version, led, dcInfo, writeRAM},
+		},
+		{
+			name:  "unknown winmon command",
+			cmd:   []byte{0x57, 0x70},
+			match: [][]byte{ramRead, writeRAM},
+			other: [][]byte{version, led, dcInfo},
+		},
+		{
+			name:  "DC info",
+			cmd:   []byte{0x46, 0x00},
+			match: [][]byte{dcInfo},
+			other: [][]byte{bootup, version, led, acL1Info, ramRead},
+		},
+		{
+			name:  "AC info",
+			cmd:   []byte{0x46, 0x01},
+			match: [][]byte{acL1Info},
+			other: [][]byte{dcInfo, ramRead},
+		},
+		{
+			name:  "LEDs",
+			cmd:   []byte{0x4c},
+			match: [][]byte{led},
+			other: [][]byte{bootup, version, dcInfo, ramRead},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match := matchRaw(tt.cmd)
+			for _, frame := range tt.match {
+				assert.True(t, match(frame), "Reply %x not matched", frame)
+			}
+			for _, frame := range tt.other {
+				assert.False(t, match(frame), "Frame %x matched", frame)
+			}
+		})
+	}
+}
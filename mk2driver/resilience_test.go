@@ -0,0 +1,166 @@
+package mk2driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2driver/mk2test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Prepends the length byte to a frame returned by SplitFrames.
+func wireFrame(frame []byte) []byte {
+	return append([]byte{byte(len(frame) - 1)}, frame...)
+}
+
+// Returns a simulated device that answers the commands of the basic capture
+// with the replies it recorded.
+func newBasicDevice(t *testing.T) *mk2test.Device {
+	t.Helper()
+	rx, _ := SplitFrames(basicReadBuffer)
+	tx, _ := SplitFrames(basicWrites)
+	require.Len(t, rx, 28)
+	require.Len(t, tx, 26)
+
+	d := mk2test.NewDevice(wireFrame(rx[1]))
+	// The first version frame follows the reply to setting the target.
+	d.Reply(wireFrame(tx[0]), wireFrame(rx[0]))
+	for i := 0; i < 15; i++ {
+		d.Reply(wireFrame(tx[1+i]), wireFrame(rx[2+i]))
+	}
+	for i := 0; i < 10; i++ {
+		d.Reply(wireFrame(tx[16+i]), wireFrame(rx[18+i]))
+	}
+	return d
+}
+
+// Waits for n valid reports and checks their values against the basic
+// capture.
+func waitValidReports(t *testing.T, mk2 Mk2, n int) {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for valid := 0; valid < n; {
+		select {
+		case info := <-mk2.C():
+			if !info.Valid {
+				continue
+			}
+			valid++
+			assert.InDelta(t, 14.41, info.BatVoltage, testDelta)
+			assert.InDelta(t, 226.98, info.InVoltage, testDelta)
+			assert.InDelta(t, 1, info.ChargeState, testDelta)
+			assert.Equal(t, InputAC2, info.ActiveInput)
+		case <-timeout:
+			t.Fatalf("Received %d of %d valid reports", valid, n)
+		}
+	}
+}
+
+func TestResilienceNoFaults(t *testing.T) {
+	port := mk2test.NewFaultyPort(newBasicDevice(t), mk2test.Faults{})
+	mk2, err := NewMk2Connection(port)
+	require.NoError(t, err)
+	defer mk2.Close()
+
+	waitValidReports(t, mk2, 5)
+	stats := mk2.(StatsSource).Stats()
+	assert.Zero(t, stats.ChecksumErrors)
+	assert.Zero(t, stats.Resyncs)
+}
+
+func TestResilienceFaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		faults mk2test.Faults
+		// Checks that the faults were noticed by the driver.
+		check func(t *testing.T, stats Stats, faults mk2test.FaultStats)
+	}{
+		{
+			name:   "corrupt bytes",
+			faults: mk2test.Faults{CorruptByte: 0.002},
+			check: func(t *testing.T, stats Stats, faults mk2test.FaultStats) {
+				assert.NotZero(t, faults.CorruptedBytes)
+				assert.NotZero(t, stats.Resyncs, "Frame lock not regained")
+			},
+		},
+		{
+			name:   "drop bytes",
+			faults: mk2test.Faults{DropByte: 0.002},
+			check: func(t *testing.T, stats Stats, faults mk2test.FaultStats) {
+				assert.NotZero(t, faults.DroppedBytes)
+				assert.NotZero(t, stats.Resyncs, "Frame lock not regained")
+			},
+		},
+		{
+			name:   "drop frames",
+			faults: mk2test.Faults{DropFrame: 0.02},
+			check: func(t *testing.T, stats Stats, faults mk2test.FaultStats) {
+				assert.NotZero(t, faults.DroppedFrames)
+				assert.NotZero(t, stats.Timeouts, "Unanswered commands not counted")
+			},
+		},
+		{
+			name:   "garbage between frames",
+			faults: mk2test.Faults{Garbage: 0.01},
+			check: func(t *testing.T, stats Stats, faults mk2test.FaultStats) {
+				assert.NotZero(t, faults.GarbageBytes)
+				assert.NotZero(t, stats.Resyncs, "Frame lock not regained")
+			},
+		},
+		{
+			name:   "read errors",
+			faults: mk2test.Faults{ErrorEvery: 500},
+			check: func(t *testing.T, stats Stats, faults mk2test.FaultStats) {
+				assert.NotZero(t, faults.ReadErrors)
+				assert.NotZero(t, stats.Resyncs, "Frame lock not regained")
+			},
+		},
+		{
+			name:   "slow reads",
+			faults: mk2test.Faults{ReadDelay: 10 * time.Microsecond},
+			check:  func(t *testing.T, stats Stats, faults mk2test.FaultStats) {},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.faults.Seed = 1
+			port := mk2test.NewFaultyPort(newBasicDevice(t), tt.faults)
+			mk2, err := NewMk2Connection(port)
+			require.NoError(t, err)
+
+			// Faults are injected from the start, so the configuration has
+			// to be read through them too.
+			waitValidReports(t, mk2, 20)
+			mk2.Close()
+			tt.check(t, mk2.(StatsSource).Stats(), port.Stats())
+		})
+	}
+}
+
+// Runs the driver through heavy combined faults and checks that the poll
+// cycle recovers once the link is clean again.
+func TestResilienceRecovery(t *testing.T) {
+	for seed := int64(1); seed <= 3; seed++ {
+		port := mk2test.NewFaultyPort(newBasicDevice(t), mk2test.Faults{
+			Seed:        seed,
+			CorruptByte: 0.05,
+			DropByte:    0.05,
+			DropFrame:   0.1,
+			Garbage:     0.2,
+			ErrorEvery:  251,
+		})
+		mk2, err := NewMk2Connection(port)
+		require.NoError(t, err)
+
+		for port.Stats().Frames < 500 {
+			select {
+			case <-mk2.C():
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		port.SetFaults(mk2test.Faults{})
+		waitValidReports(t, mk2, 5)
+		mk2.Close()
+	}
+}
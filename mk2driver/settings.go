@@ -0,0 +1,116 @@
+package mk2driver
+
+import (
+	"fmt"
+)
+
+// Length of the setting info reply data.
+const settingInfoLength = 11
+
+// SettingInfo is the metadata the device reports for a setting. Values are
+// raw, as stored by the device.
+type SettingInfo struct {
+	ID uint16
+	// Scale factor of the value, negative if the value is signed.
+	Scale  int16
+	Offset int16
+	// Default, minimum and maximum raw value.
+	Default     uint16
+	Min         uint16
+	Max         uint16
+	AccessLevel byte
+}
+
+// Signed reports whether the raw values are signed.
+func (s SettingInfo) Signed() bool {
+	return s.Scale < 0
+}
+
+// InRange reports whether value is within the minimum and maximum of the
+// setting.
+func (s SettingInfo) InRange(value uint16) bool {
+	if s.Signed() {
+		return int16(value) >= int16(s.Min) && int16(value) <= int16(s.Max)
+	}
+	return value >= s.Min && value <= s.Max
+}
+
+func settingCommand(command byte, id uint16) []byte {
+	return []byte{winmonFrame, command, byte(id), byte(id >> 8)}
+}
+
+// Sends the setting commands and returns the reply data after the response
+// byte. The reply is matched by the responses of the first command.
+// ErrNotSupported is returned if the device does not know the setting or the
+// command.
+func (m *mk2Ser) settingTransaction(response byte, cmds ...[]byte) ([]byte, error) {
+	reply, err := m.transact(matchWinmonReply(cmds[0]), nil, cmds...)
+	if err != nil {
+		return nil, err
+	}
+	switch reply[2] {
+	case response:
+	case commandSettingNotSupportedResponse, commandNotSupportedResponse:
+		return nil, newFrameError(ErrNotSupported, reply)
+	default:
+		return nil, newFrameError(ErrUnknownFrame, reply)
+	}
+	// Strip the header, response byte and checksum.
+	return reply[3 : len(reply)-1], nil
+}
+
+// ReadSettingInfo reads the metadata of setting id. It returns
+// ErrNotSupported if the device does not have the setting.
+func (m *mk2Ser) ReadSettingInfo(id uint16) (SettingInfo, error) {
+	data, err := m.settingTransaction(commandGetSettingInfoResponse, settingCommand(commandGetSettingInfo, id))
+	if err != nil {
+		return SettingInfo{}, err
+	}
+	return decodeSettingInfo(id, data)
+}
+
+// Decodes the setting info reply data of setting id.
+func decodeSettingInfo(id uint16, data []byte) (SettingInfo, error) {
+	if len(data) < settingInfoLength {
+		return SettingInfo{}, newFrameError(ErrShortFrame, data)
+	}
+	return SettingInfo{
+		ID:          id,
+		Scale:       int16(getSigned(data[0:2])),
+		Offset:      int16(getSigned(data[2:4])),
+		Default:     uint16(getUnsigned16(data[4:6])),
+		Min:         uint16(getUnsigned16(data[6:8])),
+		Max:         uint16(getUnsigned16(data[8:10])),
+		AccessLevel: data[10],
+	}, nil
+}
+
+// ReadSetting reads the raw value of setting id.
+func (m *mk2Ser) ReadSetting(id uint16) (uint16, error) {
+	data, err := m.settingTransaction(commandReadSettingResponse, settingCommand(commandReadSetting, id))
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < 2 {
+		return 0, newFrameError(ErrShortFrame, data)
+	}
+	return uint16(getUnsigned16(data[0:2])), nil
+}
+
+// WriteSetting writes the raw value of setting id. The setting info is read
+// first and ErrOutOfRange is returned without writing if value is outside
+// the reported minimum and maximum.
+func (m *mk2Ser) WriteSetting(id uint16, value uint16) error {
+	info, err := m.ReadSettingInfo(id)
+	if err != nil {
+		return err
+	}
+	if !info.InRange(value) {
+		return fmt.Errorf("%w: setting %d value %d outside %d to %d", ErrOutOfRange, id, value, info.Min, info.Max)
+	}
+	_, err = m.settingTransaction(commandWriteSettingResponse,
+		settingCommand(commandWriteSetting, id),
+		[]byte{winmonFrame, commandWriteData, byte(value), byte(value >> 8)},
+	)
+	return err
+}
@@ -0,0 +1,111 @@
+package mk2driver
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Setting info reply with scale 1, offset 0, default 50, min 10 and max 100.
+var settingInfoReply = []byte{0xff, 0x57, 0x89, 0x01, 0x00, 0x00, 0x00, 0x32, 0x00, 0x0a, 0x00, 0x64, 0x00, 0x01}
+
+func TestReadSettingInfo(t *testing.T) {
+	m := newReadyMk2()
+
+	var info SettingInfo
+	var err error
+	done := make(chan struct{})
+	go func() {
+		info, err = m.ReadSettingInfo(0x0102)
+		close(done)
+	}()
+	waitQueued(t, m)
+	writeBuffer.Reset()
+	m.handleFrame(buildFrame(versionFrame...))
+	assert.Equal(t, []byte{0x05, 0xff, 0x57, 0x35, 0x02, 0x01, 0x6d}, writeBuffer.Bytes())
+	m.handleFrame(buildFrame(settingInfoReply...))
+	<-done
+
+	assert.NoError(t, err)
+	assert.Equal(t, SettingInfo{ID: 0x0102, Scale: 1, Default: 50, Min: 10, Max: 100, AccessLevel: 1}, info)
+}
+
+func TestReadSettingNotSupported(t *testing.T) {
+	m := newReadyMk2()
+
+	var err error
+	done := make(chan struct{})
+	go func() {
+		_, err = m.ReadSetting(200)
+		close(done)
+	}()
+	waitQueued(t, m)
+	writeBuffer.Reset()
+	m.handleFrame(buildFrame(versionFrame...))
+	assert.Equal(t, []byte{0x05, 0xff, 0x57, 0x31, 0xc8, 0x00, 0xac}, writeBuffer.Bytes())
+	m.handleFrame(buildFrame(0xff, 0x57, 0x91, 0x00, 0x00))
+	<-done
+
+	assert.True(t, errors.Is(err, ErrNotSupported), "Unexpected error %v", err)
+}
+
+func TestWriteSetting(t *testing.T) {
+	m := newReadyMk2()
+
+	var err error
+	done := make(chan struct{})
+	go func() {
+		err = m.WriteSetting(3, 60)
+		close(done)
+	}()
+	waitQueued(t, m)
+	m.handleFrame(buildFrame(versionFrame...))
+	m.handleFrame(buildFrame(settingInfoReply...))
+
+	waitQueued(t, m)
+	writeBuffer.Reset()
+	m.handleFrame(buildFrame(versionFrame...))
+	l, write := buildFrame(0xff, 0x57, 0x33, 0x03, 0x00)
+	ld, data := buildFrame(0xff, 0x57, 0x34, 0x3c, 0x00)
+	expected := append(append([]byte{l}, write...), append([]byte{ld}, data...)...)
+	assert.Equal(t, expected, writeBuffer.Bytes())
+	m.handleFrame(buildFrame(0xff, 0x57, 0x88, 0x00, 0x00))
+	<-done
+
+	assert.NoError(t, err)
+}
+
+func TestWriteSettingOutOfRange(t *testing.T) {
+	m := newReadyMk2()
+
+	var err error
+	done := make(chan struct{})
+	go func() {
+		err = m.WriteSetting(3, 101)
+		close(done)
+	}()
+	waitQueued(t, m)
+	m.handleFrame(buildFrame(versionFrame...))
+	writeBuffer.Reset()
+	m.handleFrame(buildFrame(settingInfoReply...))
+	<-done
+
+	assert.True(t, errors.Is(err, ErrOutOfRange), "Unexpected error %v", err)
+	assert.NotContains(t, writeBuffer.Bytes(), byte(commandWriteSetting), "Setting written")
+}
+
+func TestSettingInfoInRange(t *testing.T) {
+	unsigned := SettingInfo{Scale: 1, Min: 10, Max: 100}
+	assert.True(t, unsigned.InRange(10))
+	assert.True(t, unsigned.InRange(100))
+	assert.False(t, unsigned.InRange(9))
+	assert.False(t, unsigned.InRange(0xfff6))
+
+	minusTen := int16(-10)
+	signed := SettingInfo{Scale: -1, Min: uint16(minusTen), Max: 10}
+	assert.True(t, signed.InRange(uint16(minusTen)))
+	assert.True(t, signed.InRange(0))
+	assert.False(t, signed.InRange(11))
+	assert.False(t, signed.InRange(uint16(minusTen-1)))
+}
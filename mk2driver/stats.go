@@ -0,0 +1,169 @@
+package mk2driver
+
+import (
+	"sync"
+	"time"
+)
+
+// Frame type names used as keys in Stats.FramesByType.
+const (
+	FrameTypeBootup    = "bootup"
+	FrameTypeVersion   = "version"
+	FrameTypeSetTarget = "set_target"
+	FrameTypeMasterLED = "master_led"
+	FrameTypeWinmon    = "winmon"
+	FrameTypeLED       = "led"
+	FrameTypeDCInfo    = "dc_info"
+	FrameTypeACInfo    = "ac_info"
+	FrameTypeUnknown   = "unknown"
+)
+
+// Returns the frame type name of a frame starting at its header.
+func frameTypeName(frame []byte) string {
+	switch frame[0] {
+	case bootupFrameHeader:
+		return FrameTypeBootup
+	case frameHeader:
+		if len(frame) < 2 {
+			return FrameTypeUnknown
+		}
+		switch frame[1] {
+		case vFrame:
+			return FrameTypeVersion
+		case setTargetFrame:
+			if len(frame) > 2+masterLEDLength {
+				return FrameTypeMasterLED
+			}
+			return FrameTypeSetTarget
+		case winmonFrame:
+			return FrameTypeWinmon
+		case ledFrame:
+			return FrameTypeLED
+		}
+	case infoFrameHeader:
+		if len(frame) < 6 {
+			return FrameTypeUnknown
+		}
+		switch frame[5] {
+		case dcInfoFrame:
+			return FrameTypeDCInfo
+		case acL1InfoFrame:
+			return FrameTypeACInfo
+		}
+	}
+	return FrameTypeUnknown
+}
+
+// Stats holds cumulative protocol health counters of a driver.
+type Stats struct {
+	// Frames with a valid checksum received while locked.
+	FramesReceived uint64
+	// Received frames counted by frame type name.
+	FramesByType map[string]uint64
+	// Frames dropped because of an invalid checksum.
+	ChecksumErrors uint64
+	// Number of times the frame lock was regained after being lost.
+	Resyncs uint64
+	// Number of device bootups seen.
+	Bootups uint64
+	// Commands that were not answered before the next poll cycle started.
+	Timeouts uint64
+
+	// Number of command replies received and the total and last round-trip
+	// time from sending a command to receiving its reply.
+	CommandReplies     uint64
+	CommandLatencySum  time.Duration
+	CommandLatencyLast time.Duration
+}
+
+// protocolStats collects Stats from the frame locker while allowing
+// concurrent readers.
+type protocolStats struct {
+	lock  sync.Mutex
+	stats Stats
+
+	// Time the last command was sent, zero once it has been answered.
+	commandSent time.Time
+}
+
+func (p *protocolStats) frame(frameType string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.stats.FramesReceived++
+	if p.stats.FramesByType == nil {
+		p.stats.FramesByType = map[string]uint64{}
+	}
+	p.stats.FramesByType[frameType]++
+}
+
+func (p *protocolStats) checksumError() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.stats.ChecksumErrors++
+}
+
+func (p *protocolStats) resync() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.stats.Resyncs++
+}
+
+func (p *protocolStats) bootup() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.stats.Bootups++
+}
+
+// commandStart records the time a command was written to the device.
+func (p *protocolStats) commandStart() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.commandSent = time.Now()
+}
+
+// commandAbort forgets the outstanding command, as its reply can no longer be
+// matched.
+func (p *protocolStats) commandAbort() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.commandSent = time.Time{}
+}
+
+// commandReply records the round-trip time of the outstanding command.
+func (p *protocolStats) commandReply() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.commandSent.IsZero() {
+		return
+	}
+	latency := time.Since(p.commandSent)
+	p.commandSent = time.Time{}
+	p.stats.CommandReplies++
+	p.stats.CommandLatencySum += latency
+	p.stats.CommandLatencyLast = latency
+}
+
+// commandTimeout counts the outstanding command as unanswered and reports
+// whether there was one.
+func (p *protocolStats) commandTimeout() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.commandSent.IsZero() {
+		return false
+	}
+	p.commandSent = time.Time{}
+	p.stats.Timeouts++
+	return true
+}
+
+// get returns a copy of the current counters.
+func (p *protocolStats) get() Stats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	s := p.stats
+	s.FramesByType = make(map[string]uint64, len(p.stats.FramesByType))
+	for k, v := range p.stats.FramesByType {
+		s.FramesByType[k] = v
+	}
+	return s
+}
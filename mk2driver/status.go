@@ -0,0 +1,160 @@
+package mk2driver
+
+import "fmt"
+
+// ChargerStage is the charger state shown by the LEDs.
+type ChargerStage int
+
+const (
+	ChargerOff ChargerStage = iota
+	ChargerBulk
+	ChargerAbsorption
+	ChargerFloat
+	ChargerEqualize
+)
+
+var ChargerStageNames = map[ChargerStage]string{
+	ChargerOff:        "off",
+	ChargerBulk:       "bulk",
+	ChargerAbsorption: "absorption",
+	ChargerFloat:      "float",
+	ChargerEqualize:   "equalize",
+}
+
+func (c ChargerStage) String() string {
+	name, ok := ChargerStageNames[c]
+	if !ok {
+		return fmt.Sprintf("unknown(%d)", int(c))
+	}
+	return name
+}
+
+// MarshalText encodes the charger stage by name.
+func (c ChargerStage) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// ACInputStatus is the state of the AC input shown by the mains LED.
+type ACInputStatus int
+
+const (
+	// No AC input available.
+	ACInputNone ACInputStatus = iota
+	// AC input present but not accepted yet.
+	ACInputPresent
+	// AC input accepted and connected.
+	ACInputAccepted
+)
+
+var ACInputStatusNames = map[ACInputStatus]string{
+	ACInputNone:     "none",
+	ACInputPresent:  "present",
+	ACInputAccepted: "accepted",
+}
+
+func (a ACInputStatus) String() string {
+	name, ok := ACInputStatusNames[a]
+	if !ok {
+		return fmt.Sprintf("unknown(%d)", int(a))
+	}
+	return name
+}
+
+// MarshalText encodes the AC input status by name.
+func (a ACInputStatus) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// Severity of a warning LED. A blinking LED is a pre-alarm warning, a
+// continuously lit LED an alarm that shut the inverter down.
+type Severity int
+
+const (
+	SeverityNone Severity = iota
+	SeverityWarning
+	SeverityAlarm
+)
+
+var SeverityNames = map[Severity]string{
+	SeverityNone:    "none",
+	SeverityWarning: "warning",
+	SeverityAlarm:   "alarm",
+}
+
+func (s Severity) String() string {
+	name, ok := SeverityNames[s]
+	if !ok {
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+	return name
+}
+
+// MarshalText encodes the severity by name.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Status is the device state derived from the combination of LEDs.
+type Status struct {
+	ChargerStage ChargerStage
+	ACInput      ACInputStatus
+
+	Overload    Severity
+	LowBattery  Severity
+	Temperature Severity
+}
+
+// Severity returns the highest severity of all warning LEDs.
+func (s Status) Severity() Severity {
+	severity := s.Overload
+	if s.LowBattery > severity {
+		severity = s.LowBattery
+	}
+	if s.Temperature > severity {
+		severity = s.Temperature
+	}
+	return severity
+}
+
+func ledSeverity(state LEDstate) Severity {
+	switch state {
+	case LedBlink:
+		return SeverityWarning
+	case LedOn:
+		return SeverityAlarm
+	}
+	return SeverityNone
+}
+
+// DecodeStatus derives the device status from the LED states. Missing LEDs
+// are treated as off.
+func DecodeStatus(leds map[Led]LEDstate) Status {
+	status := Status{
+		Overload:    ledSeverity(leds[LedOverload]),
+		LowBattery:  ledSeverity(leds[LedLowBattery]),
+		Temperature: ledSeverity(leds[LedTemperature]),
+	}
+
+	switch leds[LedMain] {
+	case LedOn:
+		status.ACInput = ACInputAccepted
+	case LedBlink:
+		status.ACInput = ACInputPresent
+	}
+
+	switch {
+	case leds[LedAbsorption] == LedBlink && leds[LedFloat] == LedBlink:
+		status.ChargerStage = ChargerEqualize
+	case leds[LedBulk] == LedBlink && leds[LedLowBattery] != LedOff:
+		// A blinking bulk LED together with the low battery LED is a
+		// low battery warning or alarm and not a charger stage. The
+		// severity is the one of the low battery LED.
+	case leds[LedBulk] != LedOff:
+		status.ChargerStage = ChargerBulk
+	case leds[LedAbsorption] != LedOff:
+		status.ChargerStage = ChargerAbsorption
+	case leds[LedFloat] != LedOff:
+		status.ChargerStage = ChargerFloat
+	}
+	return status
+}
@@ -0,0 +1,128 @@
+package mk2driver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		leds     map[Led]LEDstate
+		expected Status
+		severity Severity
+	}{
+		{
+			name:     "all off",
+			leds:     genBaseLeds(LedOff),
+			expected: Status{},
+		},
+		{
+			name: "mains accepted with absorption",
+			leds: map[Led]LEDstate{
+				LedMain:       LedOn,
+				LedAbsorption: LedOn,
+			},
+			expected: Status{
+				ChargerStage: ChargerAbsorption,
+				ACInput:      ACInputAccepted,
+			},
+		},
+		{
+			name: "mains present but not accepted",
+			leds: map[Led]LEDstate{
+				LedMain:     LedBlink,
+				LedInverter: LedOn,
+			},
+			expected: Status{
+				ACInput: ACInputPresent,
+			},
+		},
+		{
+			name: "equalize",
+			leds: map[Led]LEDstate{
+				LedMain:       LedOn,
+				LedAbsorption: LedBlink,
+				LedFloat:      LedBlink,
+			},
+			expected: Status{
+				ChargerStage: ChargerEqualize,
+				ACInput:      ACInputAccepted,
+			},
+		},
+		{
+			name: "float",
+			leds: map[Led]LEDstate{
+				LedMain:  LedOn,
+				LedFloat: LedOn,
+			},
+			expected: Status{
+				ChargerStage: ChargerFloat,
+				ACInput:      ACInputAccepted,
+			},
+		},
+		{
+			name: "bulk",
+			leds: map[Led]LEDstate{
+				LedMain: LedOn,
+				LedBulk: LedOn,
+			},
+			expected: Status{
+				ChargerStage: ChargerBulk,
+				ACInput:      ACInputAccepted,
+			},
+		},
+		{
+			name: "low battery pre-alarm",
+			leds: map[Led]LEDstate{
+				LedInverter:   LedOn,
+				LedBulk:       LedBlink,
+				LedLowBattery: LedBlink,
+			},
+			expected: Status{
+				LowBattery: SeverityWarning,
+			},
+			severity: SeverityWarning,
+		},
+		{
+			name: "low battery alarm with blinking bulk",
+			leds: map[Led]LEDstate{
+				LedBulk:       LedBlink,
+				LedLowBattery: LedOn,
+			},
+			expected: Status{
+				LowBattery: SeverityAlarm,
+			},
+			severity: SeverityAlarm,
+		},
+		{
+			name: "overload alarm and temperature warning",
+			leds: map[Led]LEDstate{
+				LedOverload:    LedOn,
+				LedTemperature: LedBlink,
+			},
+			expected: Status{
+				Overload:    SeverityAlarm,
+				Temperature: SeverityWarning,
+			},
+			severity: SeverityAlarm,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := DecodeStatus(tt.leds)
+			assert.Equal(t, tt.expected, status)
+			assert.Equal(t, tt.severity, status.Severity())
+		})
+	}
+}
+
+func TestStatusNames(t *testing.T) {
+	assert.Equal(t, "bulk", ChargerBulk.String())
+	assert.Equal(t, "unknown(9)", ChargerStage(9).String())
+	assert.Equal(t, "accepted", ACInputAccepted.String())
+	assert.Equal(t, "unknown(9)", ACInputStatus(9).String())
+	assert.Equal(t, "alarm", SeverityAlarm.String())
+	assert.Equal(t, "unknown(9)", Severity(9).String())
+}
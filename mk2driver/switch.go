@@ -0,0 +1,124 @@
+package mk2driver
+
+import (
+	"fmt"
+	"time"
+)
+
+// SwitchState is the state of the virtual switch and the multi-function
+// relay.
+type SwitchState struct {
+	// Set once the device reported the switch variables.
+	Supported     bool
+	VirtualSwitch bool
+	Relay         bool
+	// Time the state was received, zero if it was never read.
+	Timestamp time.Time
+}
+
+func decodeSwitches(data []byte) SwitchState {
+	return SwitchState{
+		Supported:     true,
+		VirtualSwitch: getUnsigned16(data[0:2]) != 0,
+		Relay:         getUnsigned16(data[2:4]) != 0,
+		Timestamp:     time.Now(),
+	}
+}
+
+// Decode the virtual switch and relay state.
+func (m *mk2Ser) switchDecode(data []byte) {
+	m.setSwitches(decodeSwitches(data))
+}
+
+func (m *mk2Ser) setSwitches(state SwitchState) {
+	m.info.Switches = state
+	m.stateLock.Lock()
+	m.switches = state
+	m.stateLock.Unlock()
+}
+
+// Switch is a switch that can be set with SetSwitch.
+type Switch int
+
+const (
+	SwitchVirtual Switch = iota
+	SwitchRelay
+)
+
+var SwitchNames = map[Switch]string{
+	SwitchVirtual: "virtual_switch",
+	SwitchRelay:   "relay",
+}
+
+func (s Switch) String() string {
+	return SwitchNames[s]
+}
+
+// ParseSwitch returns the switch with the given name.
+func ParseSwitch(name string) (Switch, error) {
+	for s, n := range SwitchNames {
+		if n == name {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown switch %q", name)
+}
+
+// RAM variable and state field of each switch.
+var switchVars = map[Switch]struct {
+	ramVar byte
+	get    func(SwitchState) bool
+}{
+	SwitchVirtual: {ramVarVirSwitchPos, func(s SwitchState) bool { return s.VirtualSwitch }},
+	SwitchRelay:   {ramVarMultiFuncRelay, func(s SwitchState) bool { return s.Relay }},
+}
+
+// SetSwitch turns the virtual switch or the multi-function relay on or off.
+// The returned state is read back from the device after the change and is
+// also set in the next Mk2Info.
+func (m *mk2Ser) SetSwitch(sw Switch, on bool) (SwitchState, error) {
+	target, ok := switchVars[sw]
+	if !ok {
+		return SwitchState{}, fmt.Errorf("unknown switch %d", sw)
+	}
+	return m.setSwitch(target.ramVar, on, target.get)
+}
+
+// Writes a switch variable and reads the switches back. Assistants
+// configured on the device take precedence over the written value, so
+// ErrInvalidState is returned when the device did not keep it.
+func (m *mk2Ser) setSwitch(id byte, on bool, get func(SwitchState) bool) (SwitchState, error) {
+	m.stateLock.Lock()
+	supported := m.switches.Supported
+	m.stateLock.Unlock()
+	if !supported {
+		return SwitchState{}, fmt.Errorf("%w: switch state not reported by device", ErrNotSupported)
+	}
+
+	var value uint16
+	if on {
+		value = 1
+	}
+	if err := m.writeRAMVar(id, value); err != nil {
+		return SwitchState{}, err
+	}
+
+	data, err := m.readRAMVars([]byte{ramVarVirSwitchPos, ramVarMultiFuncRelay}, func(data []byte) {
+		m.setSwitches(decodeSwitches(data))
+	})
+	if err != nil {
+		return SwitchState{}, err
+	}
+	state := decodeSwitches(data)
+	if get(state) != on {
+		return state, fmt.Errorf("%w: device kept the switch %v, it is controlled by its configuration", ErrInvalidState, onOff(get(state)))
+	}
+	return state, nil
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
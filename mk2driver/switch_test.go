@@ -0,0 +1,113 @@
+package mk2driver
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var switchReadCommand = []byte{0x05, 0xff, 0x57, 0x30, 0x0a, 0x0c, 0x5f}
+
+func TestSetSwitch(t *testing.T) {
+	m := newReadyMk2()
+	m.switches = SwitchState{Supported: true}
+
+	var state SwitchState
+	var err error
+	done := make(chan struct{})
+	go func() {
+		state, err = m.SetSwitch(SwitchRelay, true)
+		close(done)
+	}()
+
+	// Write the relay variable
+	waitQueued(t, m)
+	m.handleFrame(buildFrame(versionFrame...))
+	assert.Equal(t, []byte{
+		0x05, 0xff, 0x57, 0x32, 0x0c, 0x00, 0x67,
+		0x05, 0xff, 0x57, 0x34, 0x01, 0x00, 0x70,
+	}, writeBuffer.Bytes())
+	m.handleFrame(buildFrame(0xff, 0x57, 0x87, 0x00, 0x00))
+
+	// Then read it back in the next cycle
+	waitQueued(t, m)
+	writeBuffer.Reset()
+	m.handleFrame(buildFrame(versionFrame...))
+	assert.Equal(t, switchReadCommand, writeBuffer.Bytes())
+	m.handleFrame(buildFrame(0xff, 0x57, 0x85, 0x00, 0x00, 0x01, 0x00))
+	<-done
+
+	assert.NoError(t, err)
+	assert.True(t, state.Relay)
+	assert.False(t, state.VirtualSwitch)
+	// Decoded separately from the same reply.
+	reported := m.info.Switches
+	assert.WithinDuration(t, state.Timestamp, reported.Timestamp, time.Second)
+	reported.Timestamp = state.Timestamp
+	assert.Equal(t, state, reported, "State not reported in Mk2Info")
+}
+
+func TestSetSwitchOverridden(t *testing.T) {
+	m := newReadyMk2()
+	m.switches = SwitchState{Supported: true}
+
+	var err error
+	done := make(chan struct{})
+	go func() {
+		_, err = m.SetSwitch(SwitchRelay, true)
+		close(done)
+	}()
+	waitQueued(t, m)
+	m.handleFrame(buildFrame(versionFrame...))
+	m.handleFrame(buildFrame(0xff, 0x57, 0x87, 0x00, 0x00))
+	waitQueued(t, m)
+	m.handleFrame(buildFrame(versionFrame...))
+	// An assistant switched the relay off again
+	m.handleFrame(buildFrame(0xff, 0x57, 0x85, 0x00, 0x00, 0x00, 0x00))
+	<-done
+
+	assert.True(t, errors.Is(err, ErrInvalidState), "Unexpected error %v", err)
+	assert.False(t, m.info.Switches.Relay)
+}
+
+func TestSetSwitchNotSupported(t *testing.T) {
+	m := newReadyMk2()
+	_, err := m.SetSwitch(SwitchVirtual, true)
+	assert.True(t, errors.Is(err, ErrNotSupported), "Unexpected error %v", err)
+	assert.Len(t, m.transactions, 0, "Command queued")
+}
+
+func TestRAMReadNotSupported(t *testing.T) {
+	m := newReadyMk2()
+	m.infochan = make(chan *Mk2Info, 2)
+	m.masterLEDUnsupported = true
+	m.scales[scaleBatTemperature].supported = false
+
+	m.ledDecode([]byte{0x00, 0x00, 0x00, 0x00})
+	writeBuffer.Reset()
+	m.handleFrame(buildFrame(0xff, 0x57, 0x85, 0xc8, 0x00))
+	assert.Equal(t, switchReadCommand, writeBuffer.Bytes(), "Switches not requested")
+	m.handleFrame(buildFrame(0xff, 0x57, 0x90, 0x00, 0x00))
+	assert.Len(t, m.infochan, 1, "Report not sent")
+
+	// Switches are not requested in the next cycle
+	m.ledDecode([]byte{0x00, 0x00, 0x00, 0x00})
+	writeBuffer.Reset()
+	m.handleFrame(buildFrame(0xff, 0x57, 0x85, 0xc8, 0x00))
+	assert.Empty(t, writeBuffer.Bytes(), "Unsupported variables requested")
+	assert.Len(t, m.infochan, 2, "Report not sent")
+
+	info := <-m.infochan
+	assert.False(t, info.Switches.Supported)
+}
+
+func TestParseSwitch(t *testing.T) {
+	sw, err := ParseSwitch("relay")
+	assert.NoError(t, err)
+	assert.Equal(t, SwitchRelay, sw)
+
+	_, err = ParseSwitch("fan")
+	assert.Error(t, err)
+}
@@ -0,0 +1,155 @@
+package mk2driver
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Number of transactions that can wait to be sent.
+	transactionQueueSize = 16
+	// Time a caller waits for the reply to a transaction.
+	transactionTimeout = 10 * time.Second
+)
+
+// transaction is a command queued through the driver API. Transactions are
+// sent one at a time at the start of a poll cycle, before the poll commands.
+type transaction struct {
+	// Commands sent back to back, only the last one is answered.
+	cmds [][]byte
+	// Reports whether a received frame, starting at the frame header, is
+	// the reply to the commands.
+	match func(frame []byte) bool
+	// Optional handler that is run by the frame locker with the reply before
+	// the caller is woken up, unless the caller stopped waiting. It may only
+	// touch driver state, the caller decodes the returned reply itself.
+	onReply func(frame []byte)
+
+	reply     chan transactionResult
+	cancelled atomic.Bool
+}
+
+type transactionResult struct {
+	frame []byte
+	err   error
+}
+
+func (t *transaction) finish(frame []byte, err error) {
+	var reply []byte
+	if frame != nil {
+		reply = make([]byte, len(frame))
+		copy(reply, frame)
+	}
+	t.reply <- transactionResult{frame: reply, err: err}
+}
+
+// Matches any winmon frame.
+func matchWinmon(frame []byte) bool {
+	return len(frame) >= winmonReplyLength && frame[0] == frameHeader && frame[1] == winmonFrame
+}
+
+// Responses winmon commands are answered with, including the not supported
+// response of the variable or setting they address. Any command can also be
+// answered with commandNotSupportedResponse.
+var winmonResponses = map[byte][]byte{
+	commandSendSoftwareVersionPart0: {commandSoftwareVersionPart0Response},
+	commandSendSoftwareVersionPart1: {commandSoftwareVersionPart1Response},
+	commandGetSetDeviceState:        {commandGetSetDeviceStateResponse},
+	commandReadRAMVar:               {commandReadRAMResponse, commandVariableNotSupportedResponse},
+	commandReadSetting:              {commandReadSettingResponse, commandSettingNotSupportedResponse},
+	commandWriteRAMVar:              {commandWriteRAMResponse, commandVariableNotSupportedResponse},
+	commandWriteSetting:             {commandWriteSettingResponse, commandSettingNotSupportedResponse},
+	commandWriteData: {
+		commandWriteRAMResponse, commandVariableNotSupportedResponse,
+		commandWriteSettingResponse, commandSettingNotSupportedResponse,
+	},
+	commandGetSettingInfo: {commandGetSettingInfoResponse, commandSettingNotSupportedResponse},
+	commandGetRAMVarInfo:  {commandGetRAMVarInfoResponse, commandVariableNotSupportedResponse},
+}
+
+// Returns a matcher for the reply to the winmon command cmd, a winmon frame
+// with one of the responses of the command. A late reply to another command,
+// such as a RAM read of the poll cycle, is not taken as the reply. Commands
+// without known responses are matched by frame type only.
+func matchWinmonReply(cmd []byte) func(frame []byte) bool {
+	responses, known := winmonResponses[cmd[1]]
+	return func(frame []byte) bool {
+		if !matchWinmon(frame) {
+			return false
+		}
+		return !known || frame[2] == commandNotSupportedResponse || bytes.IndexByte(responses, frame[2]) >= 0
+	}
+}
+
+// transact queues cmds and waits for the frame matched as their reply.
+func (m *mk2Ser) transact(match func([]byte) bool, onReply func([]byte), cmds ...[]byte) ([]byte, error) {
+	t := &transaction{
+		cmds:    cmds,
+		match:   match,
+		onReply: onReply,
+		reply:   make(chan transactionResult, 1),
+	}
+	select {
+	case m.transactions <- t:
+	case <-m.run:
+		return nil, ErrClosed
+	default:
+		return nil, ErrBusy
+	}
+
+	timeout := time.NewTimer(transactionTimeout)
+	defer timeout.Stop()
+	select {
+	case r := <-t.reply:
+		return r.frame, r.err
+	case <-timeout.C:
+		t.cancelled.Store(true)
+		return nil, ErrTimeout
+	case <-m.run:
+		t.cancelled.Store(true)
+		return nil, ErrClosed
+	}
+}
+
+// Sends the next queued transaction and reports whether there was one. A
+// transaction that is still waiting for a reply times out.
+func (m *mk2Ser) nextTransaction() bool {
+	if m.pending != nil {
+		m.addTimeout(fmt.Sprintf("transaction %x not answered", m.pending.cmds))
+		m.pending.finish(nil, ErrTimeout)
+		m.pending = nil
+	}
+	for {
+		select {
+		case t := <-m.transactions:
+			if t.cancelled.Load() {
+				continue
+			}
+			m.pending = t
+			for _, cmd := range t.cmds {
+				m.sendCommand(cmd)
+			}
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// Completes the pending transaction if frame is its reply and reports
+// whether it was.
+func (m *mk2Ser) handleTransactionReply(frame []byte) bool {
+	if m.pending == nil || !m.pending.match(frame) {
+		return false
+	}
+	t := m.pending
+	m.pending = nil
+	m.stats.commandReply()
+	if t.onReply != nil && !t.cancelled.Load() {
+		t.onReply(frame)
+	}
+	t.finish(frame, nil)
+	return true
+}
@@ -0,0 +1,61 @@
+package mk2driver
+
+// Names of the Mk2Info values that are listed in Mk2Info.Unsupported when
+// the device does not report them.
+const (
+	ValueBatVoltage         = "BatVoltage"
+	ValueBatCurrent         = "BatCurrent"
+	ValueBatChargerCurrent  = "BatChargerCurrent"
+	ValueBatInverterCurrent = "BatInverterCurrent"
+	ValueBatTemperature     = "BatTemperature"
+	ValueInVoltage          = "InVoltage"
+	ValueInCurrent          = "InCurrent"
+	ValueInFrequency        = "InFrequency"
+	ValueOutVoltage         = "OutVoltage"
+	ValueOutCurrent         = "OutCurrent"
+	ValueOutFrequency       = "OutFrequency"
+	ValueInverterPeriod     = "InverterPeriod"
+	ValueChargeState        = "ChargeState"
+)
+
+// Scale factor each value is decoded with, in the order they are reported.
+var valueScales = []struct {
+	name  string
+	scale int
+}{
+	{ValueBatVoltage, ramVarVBat},
+	{ValueBatCurrent, ramVarIBat},
+	{ValueBatChargerCurrent, ramVarIBat},
+	{ValueBatInverterCurrent, ramVarIBat},
+	{ValueBatTemperature, scaleBatTemperature},
+	{ValueInVoltage, ramVarVMains},
+	{ValueInCurrent, ramVarIMains},
+	{ValueInFrequency, ramVarMainPeriod},
+	{ValueOutVoltage, ramVarVInverter},
+	{ValueOutCurrent, ramVarIInverter},
+	{ValueOutFrequency, ramVarInverterPeriod},
+	{ValueInverterPeriod, ramVarInverterPeriod},
+	{ValueChargeState, ramVarChargeState},
+}
+
+func (m *mk2Ser) scaleSupported(scale int) bool {
+	return scale < len(m.scales) && m.scales[scale].supported
+}
+
+// Returns the names of values the device has no scale factor for, or that
+// are read from RAM variables the device rejected.
+func (m *mk2Ser) unsupportedValues() []string {
+	rejected := map[string]bool{}
+	for i, read := range ramReads {
+		for _, name := range read.values {
+			rejected[name] = rejected[name] || m.ramUnsupported[i]
+		}
+	}
+	unsupported := []string{}
+	for _, v := range valueScales {
+		if !m.scaleSupported(v.scale) || rejected[v.name] {
+			unsupported = append(unsupported, v.name)
+		}
+	}
+	return unsupported
+}
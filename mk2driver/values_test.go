@@ -0,0 +1,48 @@
+package mk2driver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnsupportedValues(t *testing.T) {
+	m := newReadyMk2()
+	assert.Empty(t, m.unsupportedValues())
+
+	m.scales[ramVarIBat].supported = false
+	m.ramUnsupported = map[int]bool{0: true}
+	unsupported := m.unsupportedValues()
+	assert.Equal(t, []string{ValueBatCurrent, ValueBatChargerCurrent, ValueBatInverterCurrent, ValueChargeState}, unsupported)
+
+	info := &Mk2Info{Unsupported: unsupported}
+	assert.False(t, info.Supported(ValueBatChargerCurrent))
+	assert.True(t, info.Supported(ValueBatTemperature))
+}
+
+func TestTemperatureScaleNotSupported(t *testing.T) {
+	m := newReadyMk2()
+	m.infochan = make(chan *Mk2Info, 1)
+	m.scales = m.scales[:scaleBatTemperature]
+	m.scaleCount = scaleBatTemperature
+	m.reqScaleFactor(m.scaleCount)
+	assert.Equal(t, []byte{0x05, 0xff, 0x57, 0x36, 0x1a, 0x00, 0x55}, writeBuffer.Bytes(), "Temperature scale not requested")
+	m.handleFrame(buildFrame(0xff, 0x57, 0x90, 0x00, 0x00))
+	assert.Equal(t, scaleVarCount, m.scaleCount)
+
+	m.masterLEDUnsupported = true
+	m.ramUnsupported = map[int]bool{0: true, 1: true}
+	writeBuffer.Reset()
+	m.ledDecode([]byte{0x00, 0x00, 0x00, 0x00})
+	assert.Empty(t, writeBuffer.Bytes(), "Temperature requested")
+	info := <-m.infochan
+	assert.False(t, info.Supported(ValueBatTemperature))
+	assert.Equal(t, 0.0, info.BatTemperature)
+}
+
+func Test_mk2Ser_calcPeriod(t *testing.T) {
+	m := &mk2Ser{scales: []scaling{{scale: 0.008, supported: true}}}
+	assert.InDelta(t, 0.02, m.calcPeriod(0x19, 0), testDelta)
+	assert.Equal(t, 0.0, m.calcPeriod(0x00, 0))
+	assert.Equal(t, 0.0, m.calcPeriod(0xff, 0))
+}
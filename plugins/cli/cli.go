@@ -27,12 +27,34 @@ func (c *Cli) run() {
 }
 
 func printInfo(info *mk2driver.Mk2Info) {
-	log.Infof("Version: %v", info.Version)
+	log.Infof("Interface: %v Version: %v", info.Device.Interface, info.Version)
+	if info.Device.FirmwareVersion != 0 {
+		log.Infof("Device: product %d Firmware: %v", info.Device.ProductCode, info.Device.FirmwareVersion)
+	}
 	log.Infof("Bat Volt: %.2fV Bat Cur: %.2fA", info.BatVoltage, info.BatCurrent)
+	log.Infof("Charger Cur: %.2fA Inverter Cur: %.2fA", info.BatChargerCurrent, info.BatInverterCurrent)
+	if info.Supported(mk2driver.ValueBatTemperature) {
+		log.Infof("Bat Temp: %.1fC", info.BatTemperature)
+	}
 	log.Infof("In Volt: %.2fV In Cur: %.2fA In Freq %.2fHz", info.InVoltage, info.InCurrent, info.InFrequency)
+	if info.ActiveInput != mk2driver.InputUnknown {
+		log.Infof("Active input: %v", info.ActiveInput)
+	}
 	log.Infof("Out Volt: %.2fV Out Cur: %.2fA Out Freq %.2fHz", info.OutVoltage, info.OutCurrent, info.OutFrequency)
-	log.Infof("In Power %.2fW Out Power %.2fW", info.InVoltage*info.InCurrent, info.OutVoltage*info.OutCurrent)
+	log.Infof("In Power %.2fVA Out Power %.2fVA Bat Power %.2fW", info.Derived.InPower, info.Derived.OutPower, info.Derived.BatPower)
+	if info.Derived.RealPower {
+		log.Infof("In Real Power %.2fW Out Real Power %.2fW", info.Derived.InRealPower, info.Derived.OutRealPower)
+	}
+	log.Infof("Grid Power %.2f Losses %.2f", info.Derived.GridPower, info.Derived.Losses)
 	log.Infof("Charge State: %.2f%%", info.ChargeState*100)
+	if stale := info.StaleGroups(); len(stale) != 0 {
+		log.Warnf("Stale values: %v", stale)
+	}
+	log.Infof("Charger: %v AC input: %v", info.Status.ChargerStage, info.Status.ACInput)
+	if info.Status.Severity() != mk2driver.SeverityNone {
+		log.Warnf("Overload: %v Low battery: %v Temperature: %v",
+			info.Status.Overload, info.Status.LowBattery, info.Status.Temperature)
+	}
 	log.Info("LEDs state:")
 	for k, v := range info.LEDs {
 		log.Infof(" %s %s", mk2driver.LedNames[k], mk2driver.StateNames[v])
@@ -2,6 +2,9 @@ package mqttclient
 
 import (
 	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/diebietse/invertergui/mk2driver"
@@ -20,36 +23,223 @@ type Config struct {
 	Topic    string
 	Username string
 	Password string
+	// Topic below which commands are received, commands are disabled when
+	// empty.
+	CommandTopic string
+}
+
+// Controls are the driver commands that can be sent over MQTT. Nil controls
+// are not available.
+type Controls struct {
+	Switches mk2driver.SwitchController
+	ESS      ESSController
+}
+
+// ESSController sets the target of the ESS grid setpoint control.
+type ESSController interface {
+	SetTarget(target float64) (mk2driver.ESSState, error)
+}
+
+// Topic below the command topic on which the ESS setpoint target is set.
+const essSetpointTopic = "ess_setpoint"
+
+// Topic below the command topic on which the results of commands are
+// published.
+const statusTopic = "status"
+
+// Number of received commands that can wait to be run.
+const commandQueueSize = 8
+
+// command is a command received over MQTT. Commands are run one at a time by
+// runCommands, as the driver can take seconds to answer and the message
+// handlers of the client must not block.
+type command struct {
+	// Topic and payload of the received message.
+	topic   string
+	payload string
+	run     func() (interface{}, error)
+}
+
+// commandResult is published on the status topic for every received command.
+type commandResult struct {
+	Topic   string      `json:"topic"`
+	Payload string      `json:"payload"`
+	State   interface{} `json:"state,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// payload is the JSON document published for every update. Stale lists the
+// sample groups whose values should be ignored by consumers.
+type payload struct {
+	*mk2driver.Mk2Info
+	Stale []string
 }
 
 // New creates an MQTT client that starts publishing MK2 data as it is received.
-func New(mk2 mk2driver.Mk2, config Config) error {
-	c := mqtt.NewClient(getOpts(config))
+// Commands for controls are received on CommandTopic.
+func New(mk2 mk2driver.Mk2, controls Controls, config Config) error {
+	commands := make(chan command, commandQueueSize)
+	c := mqtt.NewClient(getOpts(config, func(c mqtt.Client) {
+		subscribeCommands(c, controls, config.CommandTopic, commands)
+	}))
 	if token := c.Connect(); token.Wait() && token.Error() != nil {
 		return token.Error()
 	}
 
+	done := make(chan struct{})
+	if config.CommandTopic != "" {
+		go runCommands(c, commands, config.CommandTopic+"/"+statusTopic, done)
+	}
 	go func() {
-		for e := range mk2.C() {
-			if e.Valid {
-				data, err := json.Marshal(e)
-				if err != nil {
-					log.Errorf("Could not parse data source: %v", err)
-					continue
-				}
+		defer close(done)
+		Publish(mk2, c, config.Topic)
+	}()
+	return nil
+}
 
-				t := c.Publish(config.Topic, 0, false, data)
+// Publish publishes the valid updates of mk2 on topic, waiting for each to be
+// sent, and disconnects c once the channel of mk2 is closed.
+func Publish(mk2 mk2driver.Mk2, c mqtt.Client, topic string) {
+	for e := range mk2.C() {
+		if e.Valid {
+			data, err := json.Marshal(payload{Mk2Info: e, Stale: e.StaleGroups()})
+			if err != nil {
+				log.Errorf("Could not parse data source: %v", err)
+				continue
+			}
+
+			t := c.Publish(topic, 0, false, data)
+			t.Wait()
+			if t.Error() != nil {
+				log.Errorf("Could not publish data: %v", t.Error())
+			}
+		}
+	}
+	// Give pending work 250 ms to complete once the subscription closed.
+	c.Disconnect(250)
+}
+
+// subscribeCommands subscribes to the command topics of the available
+// controls and queues the received commands on commands. Switches are set
+// with "on" or "off" on <topic>/<switch name>, the ESS setpoint target in W on
+// <topic>/ess_setpoint.
+func subscribeCommands(c mqtt.Client, controls Controls, topic string, commands chan<- command) {
+	if topic == "" {
+		return
+	}
+	status := topic + "/" + statusTopic
+	if controls.Switches != nil {
+		subscribeSwitches(c, controls.Switches, topic, status, commands)
+	}
+	if controls.ESS != nil {
+		subscribeCommand(c, topic+"/"+essSetpointTopic, status, commands, func(payload []byte) (func() (interface{}, error), error) {
+			target, err := strconv.ParseFloat(strings.TrimSpace(string(payload)), 64)
+			if err != nil {
+				return nil, err
+			}
+			return func() (interface{}, error) {
+				return controls.ESS.SetTarget(target)
+			}, nil
+		})
+	}
+}
+
+func subscribeSwitches(c mqtt.Client, switches mk2driver.SwitchController, topic, status string, commands chan<- command) {
+	for sw, name := range mk2driver.SwitchNames {
+		sw := sw
+		subscribeCommand(c, topic+"/"+name, status, commands, func(payload []byte) (func() (interface{}, error), error) {
+			on, err := parseOnOff(payload)
+			if err != nil {
+				return nil, err
+			}
+			return func() (interface{}, error) {
+				return switches.SetSwitch(sw, on)
+			}, nil
+		})
+	}
+}
+
+// subscribeCommand subscribes to topic and queues the commands parse returns
+// for its messages. Invalid messages, and messages received while the queue
+// is full, are answered with an error on status right away.
+func subscribeCommand(c mqtt.Client, topic, status string, commands chan<- command, parse func([]byte) (func() (interface{}, error), error)) {
+	subscribe(c, topic, func(c mqtt.Client, msg mqtt.Message) {
+		cmd := command{topic: msg.Topic(), payload: string(msg.Payload())}
+		run, err := parse(msg.Payload())
+		if err != nil {
+			err = fmt.Errorf("invalid command: %w", err)
+		} else {
+			cmd.run = run
+			select {
+			case commands <- cmd:
+				return
+			default:
+				err = fmt.Errorf("too many queued commands")
+			}
+		}
+		log.Errorf("Command on %v rejected: %v", cmd.topic, err)
+		// Not waited for, as the handler must not block.
+		publishResult(c, status, commandResult{Topic: cmd.topic, Payload: cmd.payload, Error: err.Error()})
+	})
+}
+
+// runCommands runs the queued commands one at a time and publishes their
+// results on status, until done is closed.
+func runCommands(c mqtt.Client, commands <-chan command, status string, done <-chan struct{}) {
+	for {
+		select {
+		case cmd := <-commands:
+			result := commandResult{Topic: cmd.topic, Payload: cmd.payload}
+			state, err := cmd.run()
+			if err != nil {
+				log.Errorf("Command on %v failed: %v", cmd.topic, err)
+				result.Error = err.Error()
+			} else {
+				log.Infof("Command on %v: %s", cmd.topic, cmd.payload)
+				result.State = state
+			}
+			if t := publishResult(c, status, result); t != nil {
 				t.Wait()
 				if t.Error() != nil {
-					log.Errorf("Could not publish data: %v", t.Error())
+					log.Errorf("Could not publish command result: %v", t.Error())
 				}
 			}
+		case <-done:
+			return
 		}
-	}()
-	return nil
+	}
+}
+
+// publishResult publishes result on status, it returns nil if the result
+// could not be encoded.
+func publishResult(c mqtt.Client, status string, result commandResult) mqtt.Token {
+	data, err := json.Marshal(result)
+	if err != nil {
+		log.Errorf("Could not encode command result: %v", err)
+		return nil
+	}
+	return c.Publish(status, 1, false, data)
+}
+
+func subscribe(c mqtt.Client, topic string, handler mqtt.MessageHandler) {
+	t := c.Subscribe(topic, 1, handler)
+	t.Wait()
+	if t.Error() != nil {
+		log.Errorf("Could not subscribe to %v: %v", topic, t.Error())
+	}
+}
+
+func parseOnOff(payload []byte) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(string(payload))) {
+	case "on", "true", "1":
+		return true, nil
+	case "off", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected on or off, got %q", payload)
 }
 
-func getOpts(config Config) *mqtt.ClientOptions {
+func getOpts(config Config, onConnect func(mqtt.Client)) *mqtt.ClientOptions {
 	opts := mqtt.NewClientOptions()
 	opts.AddBroker(config.Broker)
 	opts.SetClientID(config.ClientID)
@@ -62,8 +252,10 @@ func getOpts(config Config) *mqtt.ClientOptions {
 	}
 	opts.SetKeepAlive(keepAlive)
 
-	opts.SetOnConnectHandler(func(mqtt.Client) {
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
 		log.Info("Client connected to broker")
+		// Subscriptions are lost when the connection drops.
+		go onConnect(c)
 	})
 	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
 		log.Errorf("Client connection to broker lost: %v", err)
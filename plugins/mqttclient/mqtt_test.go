@@ -0,0 +1,171 @@
+package mqttclient
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2driver"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// fakeToken is a completed token.
+type fakeToken struct{}
+
+func (fakeToken) Wait() bool                     { return true }
+func (fakeToken) WaitTimeout(time.Duration) bool { return true }
+func (fakeToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+func (fakeToken) Error() error { return nil }
+
+type fakeMessage struct {
+	mqtt.Message
+	topic   string
+	payload []byte
+}
+
+func (m fakeMessage) Topic() string   { return m.topic }
+func (m fakeMessage) Payload() []byte { return m.payload }
+
+// fakeClient records subscriptions and published messages.
+type fakeClient struct {
+	mqtt.Client
+	lock      sync.Mutex
+	handlers  map[string]mqtt.MessageHandler
+	published chan fakeMessage
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		handlers:  map[string]mqtt.MessageHandler{},
+		published: make(chan fakeMessage, 16),
+	}
+}
+
+func (c *fakeClient) Subscribe(topic string, _ byte, handler mqtt.MessageHandler) mqtt.Token {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.handlers[topic] = handler
+	return fakeToken{}
+}
+
+func (c *fakeClient) Publish(topic string, _ byte, _ bool, payload interface{}) mqtt.Token {
+	c.published <- fakeMessage{topic: topic, payload: payload.([]byte)}
+	return fakeToken{}
+}
+
+// receive delivers a message to the handler of topic like the client does.
+func (c *fakeClient) receive(t *testing.T, topic, payload string) {
+	t.Helper()
+	c.lock.Lock()
+	handler, ok := c.handlers[topic]
+	c.lock.Unlock()
+	if !ok {
+		t.Fatalf("Not subscribed to %v", topic)
+	}
+	handler(c, fakeMessage{topic: topic, payload: []byte(payload)})
+}
+
+func (c *fakeClient) result(t *testing.T) commandResult {
+	t.Helper()
+	select {
+	case msg := <-c.published:
+		if msg.topic != "commands/status" {
+			t.Errorf("Result published on %v", msg.topic)
+		}
+		var result commandResult
+		if err := json.Unmarshal(msg.payload, &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("No result published")
+	}
+	return commandResult{}
+}
+
+// blockingSwitches answers SetSwitch once released.
+type blockingSwitches struct {
+	release chan struct{}
+}
+
+func (s *blockingSwitches) SetSwitch(sw mk2driver.Switch, on bool) (mk2driver.SwitchState, error) {
+	<-s.release
+	if sw == mk2driver.SwitchVirtual {
+		return mk2driver.SwitchState{}, mk2driver.ErrInvalidState
+	}
+	return mk2driver.SwitchState{Supported: true, Relay: on}, nil
+}
+
+func TestCommands(t *testing.T) {
+	c := newFakeClient()
+	switches := &blockingSwitches{release: make(chan struct{})}
+	commands := make(chan command, commandQueueSize)
+	done := make(chan struct{})
+	defer close(done)
+	subscribeCommands(c, Controls{Switches: switches}, "commands", commands)
+	go runCommands(c, commands, "commands/status", done)
+
+	// The handler returns while the driver has not answered yet.
+	handled := make(chan struct{})
+	go func() {
+		c.receive(t, "commands/relay", "on")
+		close(handled)
+	}()
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Message handler blocked by the command")
+	}
+	close(switches.release)
+	result := c.result(t)
+	if result.Topic != "commands/relay" || result.Payload != "on" || result.Error != "" {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	state, _ := result.State.(map[string]interface{})
+	if state["Relay"] != true {
+		t.Errorf("Unexpected state %+v", result.State)
+	}
+
+	c.receive(t, "commands/virtual_switch", "on")
+	if result := c.result(t); result.Error == "" {
+		t.Errorf("Error not published: %+v", result)
+	}
+	c.receive(t, "commands/relay", "maybe")
+	if result := c.result(t); result.Error == "" {
+		t.Errorf("Invalid command not rejected: %+v", result)
+	}
+}
+
+type fakeESS struct {
+	target float64
+}
+
+func (f *fakeESS) SetTarget(target float64) (mk2driver.ESSState, error) {
+	f.target = target
+	return mk2driver.ESSState{Enabled: true, Target: target}, nil
+}
+
+func TestESSCommand(t *testing.T) {
+	c := newFakeClient()
+	ess := &fakeESS{}
+	commands := make(chan command, commandQueueSize)
+	done := make(chan struct{})
+	defer close(done)
+	subscribeCommands(c, Controls{ESS: ess}, "commands", commands)
+	go runCommands(c, commands, "commands/status", done)
+
+	c.receive(t, "commands/ess_setpoint", "-500")
+	result := c.result(t)
+	if result.Error != "" || ess.target != -500 {
+		t.Errorf("Setpoint not set: %+v", result)
+	}
+	c.receive(t, "commands/ess_setpoint", "lots")
+	if result := c.result(t); result.Error == "" {
+		t.Errorf("Invalid setpoint not rejected: %+v", result)
+	}
+}
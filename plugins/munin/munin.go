@@ -34,8 +34,10 @@ import (
 	"bytes"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/diebietse/invertergui/mk2core"
 	"github.com/diebietse/invertergui/mk2driver"
 	"github.com/sirupsen/logrus"
 )
@@ -44,7 +46,12 @@ var log = logrus.WithField("ctx", "inverter-gui-munin")
 
 type Munin struct {
 	mk2driver.Mk2
-	muninResponse chan muninData
+	// Set when the source keeps the latest update.
+	latest mk2core.LatestSource
+
+	// Values accumulated since the last request.
+	lock   sync.Mutex
+	values muninData
 }
 
 type muninData struct {
@@ -54,9 +61,9 @@ type muninData struct {
 
 func NewMunin(mk2 mk2driver.Mk2) *Munin {
 	m := &Munin{
-		Mk2:           mk2,
-		muninResponse: make(chan muninData),
+		Mk2: mk2,
 	}
+	m.latest, _ = mk2.(mk2core.LatestSource)
 
 	go m.run()
 
@@ -64,8 +71,8 @@ func NewMunin(mk2 mk2driver.Mk2) *Munin {
 }
 
 func (m *Munin) ServeMuninHTTP(rw http.ResponseWriter, _ *http.Request) {
-	muninDat := <-m.muninResponse
-	if muninDat.timesUpdated == 0 {
+	muninDat := m.takeValues()
+	if muninDat.timesUpdated == 0 && !m.useLatest(&muninDat) {
 		log.Error("No data returned")
 		rw.WriteHeader(500)
 		_, _ = rw.Write([]byte("No data to return.\n"))
@@ -82,6 +89,10 @@ func (m *Munin) ServeMuninHTTP(rw http.ResponseWriter, _ *http.Request) {
 	fmt.Fprintf(outputBuf, "charge.value %s\n", tmpInput.BatCharge)
 	fmt.Fprintf(outputBuf, "multigraph in_batcurrent\n")
 	fmt.Fprintf(outputBuf, "current.value %s\n", tmpInput.BatCurrent)
+	fmt.Fprintf(outputBuf, "chargercurrent.value %s\n", tmpInput.BatChargerCurrent)
+	fmt.Fprintf(outputBuf, "invertercurrent.value %s\n", tmpInput.BatInverterCurrent)
+	fmt.Fprintf(outputBuf, "multigraph in_battemp\n")
+	fmt.Fprintf(outputBuf, "temp.value %s\n", tmpInput.BatTemperature)
 	fmt.Fprintf(outputBuf, "multigraph in_batpower\n")
 	fmt.Fprintf(outputBuf, "power.value %s\n", tmpInput.BatPower)
 	fmt.Fprintf(outputBuf, "multigraph in_mainscurrent\n")
@@ -96,6 +107,8 @@ func (m *Munin) ServeMuninHTTP(rw http.ResponseWriter, _ *http.Request) {
 	fmt.Fprintf(outputBuf, "multigraph in_mainsfreq\n")
 	fmt.Fprintf(outputBuf, "freqin.value %s\n", tmpInput.InFreq)
 	fmt.Fprintf(outputBuf, "freqout.value %s\n", tmpInput.OutFreq)
+	fmt.Fprintf(outputBuf, "multigraph in_invperiod\n")
+	fmt.Fprintf(outputBuf, "period.value %s\n", tmpInput.InverterPeriod)
 
 	_, err := rw.Write(outputBuf.Bytes())
 	if err != nil {
@@ -112,46 +125,81 @@ func (m *Munin) ServeMuninConfigHTTP(rw http.ResponseWriter, _ *http.Request) {
 }
 
 func (m *Munin) run() {
-	muninValues := &muninData{
-		status: mk2driver.Mk2Info{},
-	}
-	for {
-		select {
-		case e := <-m.C():
-			if e.Valid {
-				calcMuninValues(muninValues, e)
-			}
-		case m.muninResponse <- *muninValues:
-			zeroMuninValues(muninValues)
+	for e := range m.C() {
+		if e.Valid {
+			m.lock.Lock()
+			calcMuninValues(&m.values, e)
+			m.lock.Unlock()
 		}
 	}
 }
 
+// takeValues returns the values accumulated since the last request and starts
+// accumulating anew.
+func (m *Munin) takeValues() muninData {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	values := m.values
+	zeroMuninValues(&m.values)
+	return values
+}
+
+// useLatest replaces the values with the latest update of the source when
+// there was no update since the last request, so that a request never fails
+// once data was received.
+func (m *Munin) useLatest(values *muninData) bool {
+	if m.latest == nil {
+		return false
+	}
+	info, _ := m.latest.Latest()
+	if info == nil {
+		return false
+	}
+	values.status = *info
+	values.timesUpdated = 1
+	return true
+}
+
 // Munin only samples once every 5 minutes so averages have to be calculated for some values.
 func calcMuninValues(m *muninData, newStatus *mk2driver.Mk2Info) {
 	m.timesUpdated++
 	m.status.OutCurrent += newStatus.OutCurrent
 	m.status.InCurrent += newStatus.InCurrent
 	m.status.BatCurrent += newStatus.BatCurrent
+	m.status.BatChargerCurrent += newStatus.BatChargerCurrent
+	m.status.BatInverterCurrent += newStatus.BatInverterCurrent
 
 	m.status.OutVoltage += newStatus.OutVoltage
 	m.status.InVoltage += newStatus.InVoltage
 	m.status.BatVoltage += newStatus.BatVoltage
 
+	m.status.Derived.InPower += newStatus.Derived.InPower
+	m.status.Derived.OutPower += newStatus.Derived.OutPower
+	m.status.Derived.BatPower += newStatus.Derived.BatPower
+
 	m.status.InFrequency = newStatus.InFrequency
 	m.status.OutFrequency = newStatus.OutFrequency
+	m.status.InverterPeriod = newStatus.InverterPeriod
 
 	m.status.ChargeState = newStatus.ChargeState
+	m.status.BatTemperature = newStatus.BatTemperature
+	m.status.Unsupported = newStatus.Unsupported
 }
 
 func calcMuninAverages(m *muninData) {
 	m.status.OutCurrent /= float64(m.timesUpdated)
 	m.status.InCurrent /= float64(m.timesUpdated)
 	m.status.BatCurrent /= float64(m.timesUpdated)
+	m.status.BatChargerCurrent /= float64(m.timesUpdated)
+	m.status.BatInverterCurrent /= float64(m.timesUpdated)
 
 	m.status.OutVoltage /= float64(m.timesUpdated)
 	m.status.InVoltage /= float64(m.timesUpdated)
 	m.status.BatVoltage /= float64(m.timesUpdated)
+
+	m.status.Derived.InPower /= float64(m.timesUpdated)
+	m.status.Derived.OutPower /= float64(m.timesUpdated)
+	m.status.Derived.BatPower /= float64(m.timesUpdated)
 }
 
 func zeroMuninValues(m *muninData) {
@@ -159,15 +207,21 @@ func zeroMuninValues(m *muninData) {
 	m.status.OutCurrent = 0
 	m.status.InCurrent = 0
 	m.status.BatCurrent = 0
+	m.status.BatChargerCurrent = 0
+	m.status.BatInverterCurrent = 0
 
 	m.status.OutVoltage = 0
 	m.status.InVoltage = 0
 	m.status.BatVoltage = 0
 
+	m.status.Derived = mk2driver.DerivedValues{}
+
 	m.status.InFrequency = 0
 	m.status.OutFrequency = 0
+	m.status.InverterPeriod = 0
 
 	m.status.ChargeState = 0
+	m.status.BatTemperature = 0
 }
 
 type templateInput struct {
@@ -188,13 +242,18 @@ type templateInput struct {
 	BatPower   string `json:"battery_power"`
 	BatCharge  string `json:"battery_charge"`
 
+	BatTemperature     string `json:"battery_temperature"`
+	BatChargerCurrent  string `json:"battery_charger_current"`
+	BatInverterCurrent string `json:"battery_inverter_current"`
+	InverterPeriod     string `json:"inverter_period"`
+
 	InFreq  string `json:"input_frequency"`
 	OutFreq string `json:"output_frequency"`
 }
 
 func buildTemplateInput(status *mk2driver.Mk2Info) *templateInput {
-	outPower := status.OutVoltage * status.OutCurrent
-	inPower := status.InCurrent * status.InVoltage
+	outPower := status.Derived.OutPower
+	inPower := status.Derived.InPower
 
 	newInput := &templateInput{
 		Date:       status.Timestamp.Format(time.RFC1123Z),
@@ -211,8 +270,21 @@ func buildTemplateInput(status *mk2driver.Mk2Info) *templateInput {
 
 		BatCurrent: fmt.Sprintf("%.2f", status.BatCurrent),
 		BatVoltage: fmt.Sprintf("%.2f", status.BatVoltage),
-		BatPower:   fmt.Sprintf("%.2f", status.BatVoltage*status.BatCurrent),
+		BatPower:   fmt.Sprintf("%.2f", status.Derived.BatPower),
 		BatCharge:  fmt.Sprintf("%.2f", status.ChargeState*100),
+
+		BatTemperature:     formatSupported(status, mk2driver.ValueBatTemperature, status.BatTemperature),
+		BatChargerCurrent:  formatSupported(status, mk2driver.ValueBatChargerCurrent, status.BatChargerCurrent),
+		BatInverterCurrent: formatSupported(status, mk2driver.ValueBatInverterCurrent, status.BatInverterCurrent),
+		InverterPeriod:     formatSupported(status, mk2driver.ValueInverterPeriod, status.InverterPeriod*1000),
 	}
 	return newInput
 }
+
+// Values the device does not report are sent as unknown.
+func formatSupported(status *mk2driver.Mk2Info, value string, v float64) string {
+	if !status.Supported(value) {
+		return "U"
+	}
+	return fmt.Sprintf("%.2f", v)
+}
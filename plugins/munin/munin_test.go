@@ -4,7 +4,9 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/diebietse/invertergui/mk2driver"
 )
@@ -27,3 +29,56 @@ func TestServer(_ *testing.T) {
 		log.Fatal(err)
 	}
 }
+
+type fakeLatest struct {
+	c    chan *mk2driver.Mk2Info
+	info *mk2driver.Mk2Info
+}
+
+func (f *fakeLatest) C() chan *mk2driver.Mk2Info {
+	return f.c
+}
+
+func (f *fakeLatest) Close() {}
+
+func (f *fakeLatest) Latest() (*mk2driver.Mk2Info, time.Duration) {
+	if f.info == nil {
+		return nil, 0
+	}
+	return f.info.Copy(), time.Second
+}
+
+func TestServerLatest(t *testing.T) {
+	source := &fakeLatest{c: make(chan *mk2driver.Mk2Info)}
+	muninServer := NewMunin(source)
+	defer close(source.c)
+
+	rec := httptest.NewRecorder()
+	muninServer.ServeMuninHTTP(rec, httptest.NewRequest(http.MethodGet, "/munin", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d without data, want %d", rec.Code, http.StatusInternalServerError)
+	}
+
+	// Without updates since the last request the latest update is reported.
+	source.info = &mk2driver.Mk2Info{Valid: true, BatVoltage: 12.5}
+	rec = httptest.NewRecorder()
+	muninServer.ServeMuninHTTP(rec, httptest.NewRequest(http.MethodGet, "/munin", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !strings.Contains(rec.Body.String(), "volt.value 12.50\n") {
+		t.Errorf("latest battery voltage missing from:\n%s", rec.Body.String())
+	}
+}
+
+// Powers are averaged, not computed from the averaged voltages and currents.
+func TestMuninPowerAverages(t *testing.T) {
+	values := &muninData{}
+	calcMuninValues(values, &mk2driver.Mk2Info{InVoltage: 200, InCurrent: 1, Derived: mk2driver.DerivedValues{InPower: 200}})
+	calcMuninValues(values, &mk2driver.Mk2Info{InVoltage: 100, InCurrent: 3, Derived: mk2driver.DerivedValues{InPower: 300}})
+	calcMuninAverages(values)
+	tmpInput := buildTemplateInput(&values.status)
+	if tmpInput.InPower != "250.00" {
+		t.Errorf("got input power %s, want 250.00", tmpInput.InPower)
+	}
+}
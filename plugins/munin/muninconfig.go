@@ -26,6 +26,19 @@ graph_info Battery current
 
 current.info Battery current
 current.label Battery current (A)
+chargercurrent.info DC current supplied by the charger
+chargercurrent.label Charger current (A)
+invertercurrent.info DC current drawn by the inverter
+invertercurrent.label Inverter current (A)
+
+multigraph in_battemp
+graph_title Battery Temperature
+graph_vlabel Temperature (C)
+graph_category inverter
+graph_info Battery temperature
+
+temp.info Battery temperature
+temp.label Battery temperature (C)
 
 multigraph in_batpower
 graph_title Battery Power
@@ -79,4 +92,13 @@ freqin.info In frequency
 freqin.label In frequency (Hz)
 freqout.info Out frequency
 freqout.label Out frequency (Hz)
+
+multigraph in_invperiod
+graph_title Inverter period
+graph_vlabel Period (ms)
+graph_category inverter
+graph_info Inverter output period
+
+period.info Inverter period
+period.label Inverter period (ms)
 `
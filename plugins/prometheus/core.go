@@ -0,0 +1,77 @@
+package prometheus
+
+import (
+	"github.com/diebietse/invertergui/mk2core"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SubscriptionStats exports the delivery counters of the subscriptions to the
+// core.
+type SubscriptionStats struct {
+	source    mk2core.StatsSource
+	delivered *prometheus.Desc
+	dropped   *prometheus.Desc
+	filtered  *prometheus.Desc
+	queued    *prometheus.Desc
+}
+
+// NewSubscriptionStats registers a collector that reads the subscription
+// counters of source on every scrape.
+func NewSubscriptionStats(source mk2core.StatsSource) {
+	tmp := &SubscriptionStats{
+		source: source,
+		delivered: prometheus.NewDesc(
+			"invertergui_subscription_updates_delivered_total",
+			"Updates handed to a subscription.",
+			[]string{"subscription"}, nil,
+		),
+		dropped: prometheus.NewDesc(
+			"invertergui_subscription_updates_dropped_total",
+			"Updates dropped because the subscriber was not ready for them.",
+			[]string{"subscription"}, nil,
+		),
+		filtered: prometheus.NewDesc(
+			"invertergui_subscription_updates_filtered_total",
+			"Updates skipped by the filter of a subscription.",
+			[]string{"subscription"}, nil,
+		),
+		queued: prometheus.NewDesc(
+			"invertergui_subscription_updates_queued",
+			"Updates waiting in the buffer of a subscription.",
+			[]string{"subscription"}, nil,
+		),
+	}
+	prometheus.MustRegister(tmp)
+}
+
+func (s *SubscriptionStats) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.delivered
+	ch <- s.dropped
+	ch <- s.filtered
+	ch <- s.queued
+}
+
+func (s *SubscriptionStats) Collect(ch chan<- prometheus.Metric) {
+	// Subscriptions sharing a name are exported as one.
+	var names []string
+	totals := map[string]*mk2core.SubscriptionStats{}
+	for _, stats := range s.source.SubscriptionStats() {
+		total, ok := totals[stats.Name]
+		if !ok {
+			total = &mk2core.SubscriptionStats{}
+			totals[stats.Name] = total
+			names = append(names, stats.Name)
+		}
+		total.Delivered += stats.Delivered
+		total.Dropped += stats.Dropped
+		total.Filtered += stats.Filtered
+		total.Queued += stats.Queued
+	}
+	for _, name := range names {
+		total := totals[name]
+		ch <- prometheus.MustNewConstMetric(s.delivered, prometheus.CounterValue, float64(total.Delivered), name)
+		ch <- prometheus.MustNewConstMetric(s.dropped, prometheus.CounterValue, float64(total.Dropped), name)
+		ch <- prometheus.MustNewConstMetric(s.filtered, prometheus.CounterValue, float64(total.Filtered), name)
+		ch <- prometheus.MustNewConstMetric(s.queued, prometheus.GaugeValue, float64(total.Queued), name)
+	}
+}
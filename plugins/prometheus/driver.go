@@ -0,0 +1,85 @@
+package prometheus
+
+import (
+	"github.com/diebietse/invertergui/mk2driver"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DriverStats exports the protocol health counters of an MK2 driver.
+type DriverStats struct {
+	source         mk2driver.StatsSource
+	framesReceived *prometheus.Desc
+	framesByType   *prometheus.Desc
+	checksumErrors *prometheus.Desc
+	resyncs        *prometheus.Desc
+	bootups        *prometheus.Desc
+	timeouts       *prometheus.Desc
+	commandLatency *prometheus.Desc
+}
+
+// NewDriverStats registers a collector that reads the counters of source on
+// every scrape.
+func NewDriverStats(source mk2driver.StatsSource) {
+	tmp := &DriverStats{
+		source: source,
+		framesReceived: prometheus.NewDesc(
+			"mk2_frames_received_total",
+			"Frames with a valid checksum received from the MK2 interface.",
+			nil, nil,
+		),
+		framesByType: prometheus.NewDesc(
+			"mk2_frames_by_type_total",
+			"Frames received from the MK2 interface by frame type.",
+			[]string{"type"}, nil,
+		),
+		checksumErrors: prometheus.NewDesc(
+			"mk2_checksum_errors_total",
+			"Frames dropped because of an invalid checksum.",
+			nil, nil,
+		),
+		resyncs: prometheus.NewDesc(
+			"mk2_resyncs_total",
+			"Number of times the frame lock was regained after being lost.",
+			nil, nil,
+		),
+		bootups: prometheus.NewDesc(
+			"mk2_bootups_total",
+			"Number of device bootups seen.",
+			nil, nil,
+		),
+		timeouts: prometheus.NewDesc(
+			"mk2_timeouts_total",
+			"Commands that were not answered by the device.",
+			nil, nil,
+		),
+		commandLatency: prometheus.NewDesc(
+			"mk2_command_latency_seconds",
+			"Round-trip time from sending a command to receiving its reply.",
+			nil, nil,
+		),
+	}
+	prometheus.MustRegister(tmp)
+}
+
+func (d *DriverStats) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.framesReceived
+	ch <- d.framesByType
+	ch <- d.checksumErrors
+	ch <- d.resyncs
+	ch <- d.bootups
+	ch <- d.timeouts
+	ch <- d.commandLatency
+}
+
+func (d *DriverStats) Collect(ch chan<- prometheus.Metric) {
+	s := d.source.Stats()
+	ch <- prometheus.MustNewConstMetric(d.framesReceived, prometheus.CounterValue, float64(s.FramesReceived))
+	for frameType, count := range s.FramesByType {
+		ch <- prometheus.MustNewConstMetric(d.framesByType, prometheus.CounterValue, float64(count), frameType)
+	}
+	ch <- prometheus.MustNewConstMetric(d.checksumErrors, prometheus.CounterValue, float64(s.ChecksumErrors))
+	ch <- prometheus.MustNewConstMetric(d.resyncs, prometheus.CounterValue, float64(s.Resyncs))
+	ch <- prometheus.MustNewConstMetric(d.bootups, prometheus.CounterValue, float64(s.Bootups))
+	ch <- prometheus.MustNewConstMetric(d.timeouts, prometheus.CounterValue, float64(s.Timeouts))
+	ch <- prometheus.MustNewConstSummary(d.commandLatency, s.CommandReplies, s.CommandLatencySum.Seconds(), nil)
+}
@@ -0,0 +1,68 @@
+package prometheus
+
+import (
+	"github.com/diebietse/invertergui/mk2core"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Energy exports the energy counters of the core.
+type Energy struct {
+	source       mk2core.EnergySource
+	gridImport   *prometheus.Desc
+	gridExport   *prometheus.Desc
+	acOutput     *prometheus.Desc
+	batCharge    *prometheus.Desc
+	batDischarge *prometheus.Desc
+}
+
+// NewEnergy registers a collector that reads the energy counters of source on
+// every scrape. The counters continue from their restored values after a
+// restart.
+func NewEnergy(source mk2core.EnergySource) {
+	tmp := &Energy{
+		source: source,
+		gridImport: prometheus.NewDesc(
+			"energy_grid_import_kwh_total",
+			"Energy drawn from the grid in kWh.",
+			nil, nil,
+		),
+		gridExport: prometheus.NewDesc(
+			"energy_grid_export_kwh_total",
+			"Energy fed back to the grid in kWh.",
+			nil, nil,
+		),
+		acOutput: prometheus.NewDesc(
+			"energy_ac_output_kwh_total",
+			"Energy supplied to the AC output in kWh.",
+			nil, nil,
+		),
+		batCharge: prometheus.NewDesc(
+			"energy_battery_charge_kwh_total",
+			"Energy charged into the battery in kWh.",
+			nil, nil,
+		),
+		batDischarge: prometheus.NewDesc(
+			"energy_battery_discharge_kwh_total",
+			"Energy discharged from the battery in kWh.",
+			nil, nil,
+		),
+	}
+	prometheus.MustRegister(tmp)
+}
+
+func (e *Energy) Describe(ch chan<- *prometheus.Desc) {
+	ch <- e.gridImport
+	ch <- e.gridExport
+	ch <- e.acOutput
+	ch <- e.batCharge
+	ch <- e.batDischarge
+}
+
+func (e *Energy) Collect(ch chan<- prometheus.Metric) {
+	total := e.source.Total()
+	ch <- prometheus.MustNewConstMetric(e.gridImport, prometheus.CounterValue, total.GridImport)
+	ch <- prometheus.MustNewConstMetric(e.gridExport, prometheus.CounterValue, total.GridExport)
+	ch <- prometheus.MustNewConstMetric(e.acOutput, prometheus.CounterValue, total.ACOutput)
+	ch <- prometheus.MustNewConstMetric(e.batCharge, prometheus.CounterValue, total.BatCharge)
+	ch <- prometheus.MustNewConstMetric(e.batDischarge, prometheus.CounterValue, total.BatDischarge)
+}
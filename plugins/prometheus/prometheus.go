@@ -31,6 +31,8 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package prometheus
 
 import (
+	"strconv"
+
 	"github.com/diebietse/invertergui/mk2driver"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -41,14 +43,40 @@ type Prometheus struct {
 	batteryCharge   prometheus.Gauge
 	batteryCurrent  prometheus.Gauge
 	batteryPower    prometheus.Gauge
+	batteryTemp     prometheus.Gauge
+	chargerCurrent  prometheus.Gauge
+	inverterCurrent prometheus.Gauge
+	inverterPeriod  prometheus.Gauge
 	mainsCurrentIn  prometheus.Gauge
 	mainsCurrentOut prometheus.Gauge
 	mainsVoltageIn  prometheus.Gauge
 	mainsVoltageOut prometheus.Gauge
 	mainsPowerIn    prometheus.Gauge
 	mainsPowerOut   prometheus.Gauge
+	realPowerIn     prometheus.Gauge
+	realPowerOut    prometheus.Gauge
+	gridPowerVA     *prometheus.GaugeVec
+	gridPowerW      *prometheus.GaugeVec
+	lossesVA        *prometheus.GaugeVec
+	lossesW         *prometheus.GaugeVec
 	mainsFreqIn     prometheus.Gauge
 	mainsFreqOut    prometheus.Gauge
+	sampleTime      *prometheus.GaugeVec
+	deviceInfo      *prometheus.GaugeVec
+	events          *prometheus.CounterVec
+	chargerStage    *prometheus.GaugeVec
+	acInput         *prometheus.GaugeVec
+	warnings        *prometheus.GaugeVec
+	activeInput     *prometheus.GaugeVec
+	inputVoltage    *prometheus.GaugeVec
+	inputCurrent    *prometheus.GaugeVec
+	inputFreq       *prometheus.GaugeVec
+	assistantValues *prometheus.GaugeVec
+	essTarget       prometheus.Gauge
+	essApplied      prometheus.Gauge
+	essWatchdog     prometheus.Gauge
+
+	device mk2driver.DeviceInfo
 }
 
 func NewPrometheus(mk2 mk2driver.Mk2) {
@@ -70,6 +98,22 @@ func NewPrometheus(mk2 mk2driver.Mk2) {
 			Name: "battery_power_w",
 			Help: "Battery power.",
 		}),
+		batteryTemp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "battery_temperature_c",
+			Help: "Battery temperature, only set if the device has a temperature sensor.",
+		}),
+		chargerCurrent: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "battery_charger_current_a",
+			Help: "DC current supplied to the battery by the charger.",
+		}),
+		inverterCurrent: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "battery_inverter_current_a",
+			Help: "DC current drawn from the battery by the inverter.",
+		}),
+		inverterPeriod: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "inverter_period_s",
+			Help: "Inverter output period.",
+		}),
 		mainsCurrentIn: prometheus.NewGauge(prometheus.GaugeOpts{
 			Name: "mains_current_in_a",
 			Help: "Mains current flowing into inverter",
@@ -88,12 +132,36 @@ func NewPrometheus(mk2 mk2driver.Mk2) {
 		}),
 		mainsPowerIn: prometheus.NewGauge(prometheus.GaugeOpts{
 			Name: "mains_power_in_va",
-			Help: "Mains power in",
+			Help: "Apparent mains power in",
 		}),
 		mainsPowerOut: prometheus.NewGauge(prometheus.GaugeOpts{
 			Name: "mains_power_out_va",
-			Help: "Mains power out",
+			Help: "Apparent mains power out",
+		}),
+		realPowerIn: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "mains_real_power_in_w",
+			Help: "Real mains power in, only set when the real powers are polled.",
 		}),
+		realPowerOut: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "mains_real_power_out_w",
+			Help: "Real mains power out, only set when the real powers are polled.",
+		}),
+		gridPowerVA: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "grid_power_va",
+			Help: "Apparent power drawn from the grid, only set when the real powers are not polled.",
+		}, []string{}),
+		gridPowerW: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "grid_power_w",
+			Help: "Real power drawn from the grid, only set when the real powers are polled.",
+		}, []string{}),
+		lossesVA: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "power_losses_va",
+			Help: "Power lost in the device, an estimate from the apparent powers, only set when the real powers are not polled.",
+		}, []string{}),
+		lossesW: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "power_losses_w",
+			Help: "Real power lost in the device, only set when the real powers are polled.",
+		}, []string{}),
 		mainsFreqIn: prometheus.NewGauge(prometheus.GaugeOpts{
 			Name: "mains_freq_in_hz",
 			Help: "Mains frequency at inverter input",
@@ -102,20 +170,100 @@ func NewPrometheus(mk2 mk2driver.Mk2) {
 			Name: "mains_freq_out_hz",
 			Help: "Mains frequency at inverter output",
 		}),
+		sampleTime: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "sample_timestamp_seconds",
+			Help: "Unix time at which a group of values was last received from the inverter.",
+		}, []string{"group"}),
+		deviceInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "device_info",
+			Help: "MK interface and VE.Bus device details, always 1.",
+		}, []string{"interface", "interface_version", "product_code", "firmware"}),
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "driver_events_total",
+			Help: "Driver events such as device bootups and frame lock changes.",
+		}, []string{"type"}),
+		chargerStage: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "charger_stage",
+			Help: "Charger stage shown by the LEDs, 1 for the active stage.",
+		}, []string{"stage"}),
+		acInput: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ac_input_status",
+			Help: "AC input status shown by the mains LED, 1 for the active status.",
+		}, []string{"status"}),
+		warnings: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "warning_severity",
+			Help: "Severity of the warning LEDs, 0 none, 1 pre-alarm warning, 2 alarm.",
+		}, []string{"warning"}),
+		activeInput: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ac_input_active",
+			Help: "AC input the mains in values are measured on, 1 for the active input.",
+		}, []string{"input"}),
+		inputVoltage: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ac_input_voltage_v",
+			Help: "Voltage of each AC input, last measured while it was active.",
+		}, []string{"input"}),
+		inputCurrent: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ac_input_current_a",
+			Help: "Current of each AC input, last measured while it was active.",
+		}, []string{"input"}),
+		inputFreq: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ac_input_freq_hz",
+			Help: "Frequency of each AC input, last measured while it was active.",
+		}, []string{"input"}),
+		assistantValues: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "assistant_value",
+			Help: "Raw value of the monitored assistant RAM variables.",
+		}, []string{"name"}),
+		essTarget: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ess_setpoint_target_w",
+			Help: "Requested ESS grid setpoint.",
+		}),
+		essApplied: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ess_setpoint_applied_w",
+			Help: "ESS grid setpoint last written to the device.",
+		}),
+		essWatchdog: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ess_watchdog_tripped",
+			Help: "1 while the safe ESS grid setpoint is applied because the target was not updated.",
+		}),
 	}
 	prometheus.MustRegister(
 		tmp.batteryVoltage,
 		tmp.batteryCharge,
 		tmp.batteryCurrent,
 		tmp.batteryPower,
+		tmp.batteryTemp,
+		tmp.chargerCurrent,
+		tmp.inverterCurrent,
+		tmp.inverterPeriod,
 		tmp.mainsCurrentIn,
 		tmp.mainsCurrentOut,
 		tmp.mainsVoltageIn,
 		tmp.mainsVoltageOut,
 		tmp.mainsPowerIn,
 		tmp.mainsPowerOut,
+		tmp.realPowerIn,
+		tmp.realPowerOut,
+		tmp.gridPowerVA,
+		tmp.gridPowerW,
+		tmp.lossesVA,
+		tmp.lossesW,
 		tmp.mainsFreqIn,
 		tmp.mainsFreqOut,
+		tmp.sampleTime,
+		tmp.deviceInfo,
+		tmp.events,
+		tmp.chargerStage,
+		tmp.acInput,
+		tmp.warnings,
+		tmp.activeInput,
+		tmp.inputVoltage,
+		tmp.inputCurrent,
+		tmp.inputFreq,
+		tmp.assistantValues,
+		tmp.essTarget,
+		tmp.essApplied,
+		tmp.essWatchdog,
 	)
 
 	go tmp.run()
@@ -123,24 +271,120 @@ func NewPrometheus(mk2 mk2driver.Mk2) {
 
 func (p *Prometheus) run() {
 	for e := range p.C() {
+		for _, event := range e.Events {
+			p.events.WithLabelValues(event.Type.String()).Inc()
+		}
 		if e.Valid {
 			p.updatePrometheus(e)
 		}
 	}
 }
 
+// updatePrometheus only updates the gauges of sample groups that are not stale,
+// so stale values keep their last good value and sample timestamp.
 func (p *Prometheus) updatePrometheus(newStatus *mk2driver.Mk2Info) {
 	s := newStatus
-	p.batteryVoltage.Set(s.BatVoltage)
-	p.batteryCharge.Set(newStatus.ChargeState * 100)
-	p.batteryCurrent.Set(s.BatCurrent)
-	p.batteryPower.Set(s.BatVoltage * s.BatCurrent)
-	p.mainsCurrentIn.Set(s.InCurrent)
-	p.mainsCurrentOut.Set(s.OutCurrent)
-	p.mainsVoltageIn.Set(s.InVoltage)
-	p.mainsVoltageOut.Set(s.OutVoltage)
-	p.mainsPowerIn.Set(s.InVoltage * s.InCurrent)
-	p.mainsPowerOut.Set(s.OutVoltage * s.OutCurrent)
-	p.mainsFreqIn.Set(s.InFrequency)
-	p.mainsFreqOut.Set(s.OutFrequency)
+	if s.Device != p.device {
+		p.device = s.Device
+		p.deviceInfo.Reset()
+		p.deviceInfo.WithLabelValues(
+			s.Device.Interface.String(),
+			strconv.FormatUint(uint64(s.Device.InterfaceVersion), 10),
+			strconv.FormatUint(uint64(s.Device.ProductCode), 10),
+			strconv.FormatUint(uint64(s.Device.FirmwareVersion), 10),
+		).Set(1)
+	}
+	for group, name := range mk2driver.SampleGroupNames {
+		if t := s.SampleTimes.Get(group); !t.IsZero() {
+			p.sampleTime.WithLabelValues(name).Set(float64(t.UnixNano()) / 1e9)
+		}
+	}
+	if !s.Stale(mk2driver.SampleLEDs) {
+		for stage, name := range mk2driver.ChargerStageNames {
+			p.chargerStage.WithLabelValues(name).Set(boolToFloat(stage == s.Status.ChargerStage))
+		}
+		for status, name := range mk2driver.ACInputStatusNames {
+			p.acInput.WithLabelValues(name).Set(boolToFloat(status == s.Status.ACInput))
+		}
+		p.warnings.WithLabelValues("overload").Set(float64(s.Status.Overload))
+		p.warnings.WithLabelValues("low_battery").Set(float64(s.Status.LowBattery))
+		p.warnings.WithLabelValues("temperature").Set(float64(s.Status.Temperature))
+	}
+	if !s.Stale(mk2driver.SampleDC) {
+		p.batteryVoltage.Set(s.BatVoltage)
+		p.batteryCurrent.Set(s.BatCurrent)
+		p.batteryPower.Set(s.Derived.BatPower)
+		p.mainsFreqOut.Set(s.OutFrequency)
+		setSupported(s, mk2driver.ValueBatChargerCurrent, p.chargerCurrent, s.BatChargerCurrent)
+		setSupported(s, mk2driver.ValueBatInverterCurrent, p.inverterCurrent, s.BatInverterCurrent)
+		setSupported(s, mk2driver.ValueInverterPeriod, p.inverterPeriod, s.InverterPeriod)
+	}
+	if !s.Stale(mk2driver.SampleChargeState) {
+		p.batteryCharge.Set(newStatus.ChargeState * 100)
+		setSupported(s, mk2driver.ValueBatTemperature, p.batteryTemp, s.BatTemperature)
+	}
+	if !s.Stale(mk2driver.SampleAC) {
+		p.mainsCurrentIn.Set(s.InCurrent)
+		p.mainsCurrentOut.Set(s.OutCurrent)
+		p.mainsVoltageIn.Set(s.InVoltage)
+		p.mainsVoltageOut.Set(s.OutVoltage)
+		p.mainsPowerIn.Set(s.Derived.InPower)
+		p.mainsPowerOut.Set(s.Derived.OutPower)
+		if s.Derived.RealPower {
+			p.realPowerIn.Set(s.Derived.InRealPower)
+			p.realPowerOut.Set(s.Derived.OutRealPower)
+		}
+		setPower(p.gridPowerVA, p.gridPowerW, s.Derived.RealPower, s.Derived.GridPower)
+		setPower(p.lossesVA, p.lossesW, s.Derived.RealPower, s.Derived.Losses)
+		p.mainsFreqIn.Set(s.InFrequency)
+	}
+	if s.ESS.Enabled {
+		p.essTarget.Set(s.ESS.Target)
+		p.essApplied.Set(s.ESS.Applied)
+		p.essWatchdog.Set(boolToFloat(s.ESS.WatchdogTripped))
+	}
+	for name, v := range s.AssistantValues {
+		p.assistantValues.WithLabelValues(name).Set(v)
+	}
+	if s.ActiveInput != mk2driver.InputUnknown {
+		for input := mk2driver.InputAC1; input <= mk2driver.MaxACInputs; input++ {
+			p.activeInput.WithLabelValues(input.String()).Set(boolToFloat(input == s.ActiveInput))
+			reading := s.Input(input)
+			if !reading.Available {
+				// Inactive inputs are not measured.
+				p.inputVoltage.DeleteLabelValues(input.String())
+				p.inputCurrent.DeleteLabelValues(input.String())
+				p.inputFreq.DeleteLabelValues(input.String())
+				continue
+			}
+			p.inputVoltage.WithLabelValues(input.String()).Set(reading.Voltage)
+			p.inputCurrent.WithLabelValues(input.String()).Set(reading.Current)
+			p.inputFreq.WithLabelValues(input.String()).Set(reading.Frequency)
+		}
+	}
+}
+
+// setSupported leaves gauges of values the device does not report unset.
+func setSupported(s *mk2driver.Mk2Info, value string, gauge prometheus.Gauge, v float64) {
+	if s.Supported(value) {
+		gauge.Set(v)
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// setPower sets the series of the unit of a derived power, W when the real
+// powers are known and VA otherwise, and removes the series of the other unit.
+func setPower(va, w *prometheus.GaugeVec, realPower bool, power float64) {
+	set, unset := va, w
+	if realPower {
+		set, unset = w, va
+	}
+	unset.Reset()
+	set.WithLabelValues().Set(power)
 }
@@ -0,0 +1,162 @@
+package webui
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+
+	"github.com/diebietse/invertergui/mk2driver"
+)
+
+// SwitchHandler serves requests to set the virtual switch and relay. Requests
+// must carry the configured token as bearer token.
+type SwitchHandler struct {
+	controller mk2driver.SwitchController
+	token      string
+}
+
+// NewSwitchHandler returns a handler that only accepts requests authorized
+// with token, which must not be empty.
+func NewSwitchHandler(controller mk2driver.SwitchController, token string) *SwitchHandler {
+	return &SwitchHandler{controller: controller, token: token}
+}
+
+type switchRequest struct {
+	Switch string `json:"switch"`
+	On     bool   `json:"on"`
+}
+
+// ServeHTTP sets a switch from a JSON request like
+// {"switch": "relay", "on": true} and replies with the resulting state.
+func (h *SwitchHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !controlAllowed(rw, r, h.token) {
+		return
+	}
+	var req switchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(rw, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	sw, err := mk2driver.ParseSwitch(req.Switch)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	state, err := h.controller.SetSwitch(sw, req.On)
+	if err != nil {
+		log.Errorf("Could not set %v: %v", sw, err)
+		http.Error(rw, err.Error(), errorStatus(err))
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(state); err != nil {
+		log.Errorf("Could not send switch state: %v", err)
+	}
+}
+
+// ESSController sets the target of the ESS grid setpoint control.
+type ESSController interface {
+	State() mk2driver.ESSState
+	SetTarget(target float64) (mk2driver.ESSState, error)
+}
+
+// ESSHandler serves the ESS setpoint control state and sets its target.
+// Requests setting the target must carry the configured token as bearer
+// token.
+type ESSHandler struct {
+	controller ESSController
+	token      string
+}
+
+// NewESSHandler returns a handler that only sets the target for requests
+// authorized with token, it is read only when token is empty.
+func NewESSHandler(controller ESSController, token string) *ESSHandler {
+	return &ESSHandler{controller: controller, token: token}
+}
+
+type essRequest struct {
+	Setpoint *float64 `json:"setpoint"`
+}
+
+// ServeHTTP replies with the control state, after setting the target from a
+// POST JSON request like {"setpoint": -500}.
+func (h *ESSHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	var state mk2driver.ESSState
+	switch r.Method {
+	case http.MethodGet:
+		state = h.controller.State()
+	case http.MethodPost:
+		if !controlAllowed(rw, r, h.token) {
+			return
+		}
+		var req essRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(rw, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Setpoint == nil {
+			http.Error(rw, "invalid request: setpoint missing", http.StatusBadRequest)
+			return
+		}
+		var err error
+		state, err = h.controller.SetTarget(*req.Setpoint)
+		if err != nil {
+			log.Errorf("Could not set ESS setpoint: %v", err)
+			http.Error(rw, err.Error(), errorStatus(err))
+			return
+		}
+	default:
+		rw.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(state); err != nil {
+		log.Errorf("Could not send ESS state: %v", err)
+	}
+}
+
+// controlAllowed replies with an error and returns false unless r is
+// authorized with token and has a JSON body. Requiring a JSON content type
+// keeps cross-site forms from sending control requests.
+func controlAllowed(rw http.ResponseWriter, r *http.Request, token string) bool {
+	if token == "" {
+		http.Error(rw, "control disabled", http.StatusForbidden)
+		return false
+	}
+	if !bearerAuthorized(r, token) {
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(rw, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		http.Error(rw, "content type must be application/json", http.StatusUnsupportedMediaType)
+		return false
+	}
+	return true
+}
+
+// errorStatus returns the HTTP status code for a driver command error.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, mk2driver.ErrInvalidState):
+		return http.StatusConflict
+	case errors.Is(err, mk2driver.ErrOutOfRange), errors.Is(err, mk2driver.ErrInvalidCommand):
+		return http.StatusBadRequest
+	case errors.Is(err, mk2driver.ErrNotSupported):
+		return http.StatusNotImplemented
+	case errors.Is(err, mk2driver.ErrBusy), errors.Is(err, mk2driver.ErrClosed):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, mk2driver.ErrTimeout):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
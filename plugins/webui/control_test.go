@@ -0,0 +1,178 @@
+package webui
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/diebietse/invertergui/mk2driver"
+)
+
+type fakeSwitches struct {
+	sw  mk2driver.Switch
+	on  bool
+	err error
+}
+
+func (f *fakeSwitches) SetSwitch(sw mk2driver.Switch, on bool) (mk2driver.SwitchState, error) {
+	f.sw = sw
+	f.on = on
+	return mk2driver.SwitchState{Supported: true, Relay: on}, f.err
+}
+
+// Headers of an authorized control request.
+var controlHeaders = map[string]string{
+	"Authorization": "Bearer secret",
+	"Content-Type":  "application/json",
+}
+
+func controlRequest(method, path, body string, headers map[string]string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	return req
+}
+
+func TestSwitchHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		body    string
+		err     error
+		status  int
+	}{
+		{name: "set relay", method: http.MethodPost, headers: controlHeaders, body: `{"switch": "relay", "on": true}`, status: http.StatusOK},
+		{name: "wrong method", method: http.MethodGet, headers: controlHeaders, status: http.StatusMethodNotAllowed},
+		{name: "invalid body", method: http.MethodPost, headers: controlHeaders, body: `on`, status: http.StatusBadRequest},
+		{name: "unknown switch", method: http.MethodPost, headers: controlHeaders, body: `{"switch": "fan", "on": true}`, status: http.StatusBadRequest},
+		{
+			name:    "overridden by device",
+			method:  http.MethodPost,
+			headers: controlHeaders,
+			body:    `{"switch": "relay", "on": true}`,
+			err:     fmt.Errorf("%w: test", mk2driver.ErrInvalidState),
+			status:  http.StatusConflict,
+		},
+		{
+			name:    "no token",
+			method:  http.MethodPost,
+			headers: map[string]string{"Content-Type": "application/json"},
+			body:    `{"switch": "relay", "on": true}`,
+			status:  http.StatusUnauthorized,
+		},
+		{
+			name:    "wrong token",
+			method:  http.MethodPost,
+			headers: map[string]string{"Authorization": "Bearer guess", "Content-Type": "application/json"},
+			body:    `{"switch": "relay", "on": true}`,
+			status:  http.StatusUnauthorized,
+		},
+		{
+			name:    "form post",
+			method:  http.MethodPost,
+			headers: map[string]string{"Authorization": "Bearer secret", "Content-Type": "text/plain"},
+			body:    `{"switch": "relay", "on": true}`,
+			status:  http.StatusUnsupportedMediaType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := &fakeSwitches{err: tt.err}
+			rec := httptest.NewRecorder()
+			NewSwitchHandler(controller, "secret").ServeHTTP(rec, controlRequest(tt.method, "/api/switch", tt.body, tt.headers))
+
+			if rec.Code != tt.status {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status == http.StatusOK && (controller.sw != mk2driver.SwitchRelay || !controller.on) {
+				t.Errorf("switch not set: %+v", controller)
+			}
+			if (tt.status == http.StatusUnauthorized || tt.status == http.StatusUnsupportedMediaType) && controller.on {
+				t.Errorf("switch set by rejected request")
+			}
+		})
+	}
+}
+
+type fakeESS struct {
+	target float64
+}
+
+func (f *fakeESS) State() mk2driver.ESSState {
+	return mk2driver.ESSState{Enabled: true, Target: f.target}
+}
+
+func (f *fakeESS) SetTarget(target float64) (mk2driver.ESSState, error) {
+	if target > 1000 {
+		return f.State(), fmt.Errorf("%w: test", mk2driver.ErrOutOfRange)
+	}
+	f.target = target
+	return f.State(), nil
+}
+
+func TestESSHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		// Control is disabled, there is no token.
+		disabled bool
+		body     string
+		status   int
+		target   float64
+	}{
+		{name: "get state", method: http.MethodGet, status: http.StatusOK, target: 10},
+		{name: "get state without control", method: http.MethodGet, disabled: true, status: http.StatusOK, target: 10},
+		{name: "set target", method: http.MethodPost, headers: controlHeaders, body: `{"setpoint": -500}`, status: http.StatusOK, target: -500},
+		{name: "out of range", method: http.MethodPost, headers: controlHeaders, body: `{"setpoint": 2000}`, status: http.StatusBadRequest, target: 10},
+		{name: "missing setpoint", method: http.MethodPost, headers: controlHeaders, body: `{}`, status: http.StatusBadRequest, target: 10},
+		{name: "wrong method", method: http.MethodPut, headers: controlHeaders, status: http.StatusMethodNotAllowed, target: 10},
+		{
+			name:    "no token",
+			method:  http.MethodPost,
+			headers: map[string]string{"Content-Type": "application/json"},
+			body:    `{"setpoint": -500}`,
+			status:  http.StatusUnauthorized,
+			target:  10,
+		},
+		{
+			name:    "form post",
+			method:  http.MethodPost,
+			headers: map[string]string{"Authorization": "Bearer secret", "Content-Type": "application/x-www-form-urlencoded"},
+			body:    `{"setpoint": -500}`,
+			status:  http.StatusUnsupportedMediaType,
+			target:  10,
+		},
+		{
+			name:     "control disabled",
+			method:   http.MethodPost,
+			headers:  controlHeaders,
+			disabled: true,
+			body:     `{"setpoint": -500}`,
+			status:   http.StatusForbidden,
+			target:   10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := "secret"
+			if tt.disabled {
+				token = ""
+			}
+			controller := &fakeESS{target: 10}
+			rec := httptest.NewRecorder()
+			NewESSHandler(controller, token).ServeHTTP(rec, controlRequest(tt.method, "/api/ess", tt.body, tt.headers))
+
+			if rec.Code != tt.status {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if controller.target != tt.target {
+				t.Errorf("got target %v, want %v", controller.target, tt.target)
+			}
+		})
+	}
+}
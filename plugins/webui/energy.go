@@ -0,0 +1,60 @@
+package webui
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/diebietse/invertergui/mk2core"
+	"github.com/diebietse/invertergui/mk2driver"
+)
+
+// EnergyHandler serves the energy totals per day or month.
+type EnergyHandler struct {
+	source mk2core.EnergySource
+}
+
+func NewEnergyHandler(source mk2core.EnergySource) *EnergyHandler {
+	return &EnergyHandler{source: source}
+}
+
+type energyReply struct {
+	Total   mk2driver.EnergyCounters `json:"total"`
+	Period  string                   `json:"period"`
+	Periods []mk2core.EnergyPeriod   `json:"periods"`
+}
+
+// ServeHTTP replies with the energy of each day, or each month with
+// ?period=month, like {"total": {...}, "period": "day", "periods":
+// [{"period": "2024-06-01", "energy": {"GridImport": 1.5, ...}}]}. The optional
+// from and to parameters limit the periods to an inclusive range in the same
+// format, such as from=2024-06-01&to=2024-06-30.
+func (h *EnergyHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		rw.Header().Set("Allow", http.MethodGet)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	reply := energyReply{Total: h.source.Total(), Period: query.Get("period"), Periods: []mk2core.EnergyPeriod{}}
+	var periods []mk2core.EnergyPeriod
+	switch reply.Period {
+	case "", "day":
+		reply.Period = "day"
+		periods = h.source.Days()
+	case "month":
+		periods = h.source.Months()
+	default:
+		http.Error(rw, "period must be day or month", http.StatusBadRequest)
+		return
+	}
+	from, to := query.Get("from"), query.Get("to")
+	for _, p := range periods {
+		if (from == "" || p.Period >= from) && (to == "" || p.Period <= to) {
+			reply.Periods = append(reply.Periods, p)
+		}
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(reply); err != nil {
+		log.Errorf("Could not send energy totals: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package webui
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/diebietse/invertergui/mk2core"
+	"github.com/diebietse/invertergui/mk2driver"
+)
+
+type fakeEnergy struct {
+	days   []mk2core.EnergyPeriod
+	months []mk2core.EnergyPeriod
+}
+
+func (f *fakeEnergy) Total() mk2driver.EnergyCounters {
+	return mk2driver.EnergyCounters{GridImport: 3}
+}
+
+func (f *fakeEnergy) Days() []mk2core.EnergyPeriod {
+	return f.days
+}
+
+func (f *fakeEnergy) Months() []mk2core.EnergyPeriod {
+	return f.months
+}
+
+func TestEnergyHandler(t *testing.T) {
+	source := &fakeEnergy{
+		days: []mk2core.EnergyPeriod{
+			{Period: "2024-05-31", Energy: mk2driver.EnergyCounters{GridImport: 1}},
+			{Period: "2024-06-01", Energy: mk2driver.EnergyCounters{GridImport: 2}},
+		},
+		months: []mk2core.EnergyPeriod{
+			{Period: "2024-05", Energy: mk2driver.EnergyCounters{GridImport: 1}},
+			{Period: "2024-06", Energy: mk2driver.EnergyCounters{GridImport: 2}},
+		},
+	}
+	tests := []struct {
+		query   string
+		period  string
+		periods []mk2core.EnergyPeriod
+	}{
+		{"", "day", source.days},
+		{"?period=day&from=2024-06-01", "day", source.days[1:]},
+		{"?period=month", "month", source.months},
+		{"?period=month&to=2024-05", "month", source.months[:1]},
+		{"?from=2025-01-01", "day", []mk2core.EnergyPeriod{}},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		NewEnergyHandler(source).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/energy"+tt.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d: %s", tt.query, rec.Code, rec.Body.String())
+		}
+		var got energyReply
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Period != tt.period || !reflect.DeepEqual(got.Periods, tt.periods) || got.Total.GridImport != 3 {
+			t.Errorf("%s: got %+v", tt.query, got)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	NewEnergyHandler(source).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/energy?period=year", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	rec = httptest.NewRecorder()
+	NewEnergyHandler(source).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/energy", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
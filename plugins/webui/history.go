@@ -0,0 +1,103 @@
+package webui
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/diebietse/invertergui/history"
+)
+
+// Time range of history queries without a start.
+const defaultHistoryRange = time.Hour
+
+// HistoryHandler serves the history of fields by time range.
+type HistoryHandler struct {
+	source history.Querier
+}
+
+func NewHistoryHandler(source history.Querier) *HistoryHandler {
+	return &HistoryHandler{source: source}
+}
+
+type historyReply struct {
+	Series []historySeries `json:"series"`
+}
+
+type historySeries struct {
+	Field string `json:"field"`
+	// Seconds covered by each point, zero for raw samples.
+	Step   float64         `json:"step"`
+	Points []history.Point `json:"points"`
+}
+
+// ServeHTTP replies with the points of every field parameter between from and
+// to, like {"series": [{"field": "bat_voltage", "step": 60, "points": [{"time":
+// "2024-06-01T12:00:00Z", "min": 25.1, "avg": 25.2, "max": 25.4, "count":
+// 60}]}]}. The times are RFC 3339 or a duration before now such as 6h, by
+// default the last hour.
+func (h *HistoryHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		rw.Header().Set("Allow", http.MethodGet)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	fields := query["field"]
+	if len(fields) == 0 {
+		http.Error(rw, "field required, one of "+strings.Join(history.FieldNames(), ", "), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	to, err := parseHistoryTime(query.Get("to"), now, now)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, err := parseHistoryTime(query.Get("from"), now, to.Add(-defaultHistoryRange))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reply := historyReply{}
+	for _, field := range fields {
+		series, err := h.source.Query(field, from, to)
+		if errors.Is(err, history.ErrUnknownField) {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Errorf("Could not query history: %v", err)
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		reply.Series = append(reply.Series, historySeries{
+			Field:  series.Field,
+			Step:   series.Step.Seconds(),
+			Points: series.Points,
+		})
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(reply); err != nil {
+		log.Errorf("Could not send history: %v", err)
+	}
+}
+
+// parseHistoryTime parses an RFC 3339 time or a duration before now, returning
+// def for an empty value.
+func parseHistoryTime(value string, now, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 or a duration", value)
+	}
+	return now.Add(-d), nil
+}
@@ -0,0 +1,73 @@
+package webui
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/diebietse/invertergui/history"
+)
+
+type fakeHistory struct {
+	from, to time.Time
+}
+
+func (f *fakeHistory) Query(field string, from, to time.Time) (history.Series, error) {
+	if field != "bat_voltage" {
+		return history.Series{}, fmt.Errorf("%w %q", history.ErrUnknownField, field)
+	}
+	f.from, f.to = from, to
+	return history.Series{
+		Field:  field,
+		Step:   time.Minute,
+		Points: []history.Point{{Time: from, Min: 24, Avg: 25, Max: 26, Count: 60}},
+	}, nil
+}
+
+func TestHistoryHandler(t *testing.T) {
+	source := &fakeHistory{}
+	rec := httptest.NewRecorder()
+	NewHistoryHandler(source).ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+		"/api/history?field=bat_voltage&from=2024-06-01T12:00:00Z&to=2024-06-01T13:00:00Z", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+	var got historyReply
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	if len(got.Series) != 1 || got.Series[0].Field != "bat_voltage" || got.Series[0].Step != 60 ||
+		len(got.Series[0].Points) != 1 || got.Series[0].Points[0].Avg != 25 {
+		t.Errorf("got %+v", got)
+	}
+	if !source.from.Equal(from) || !source.to.Equal(from.Add(time.Hour)) {
+		t.Errorf("queried %v to %v", source.from, source.to)
+	}
+
+	// Durations are relative to now.
+	rec = httptest.NewRecorder()
+	NewHistoryHandler(source).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history?field=bat_voltage&from=6h", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+	if d := source.to.Sub(source.from); d < 6*time.Hour-time.Second || d > 6*time.Hour+time.Second {
+		t.Errorf("queried %v", d)
+	}
+
+	for _, query := range []string{"", "?field=nope", "?field=bat_voltage&from=yesterday"} {
+		rec = httptest.NewRecorder()
+		NewHistoryHandler(source).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+	rec = httptest.NewRecorder()
+	NewHistoryHandler(source).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/history", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
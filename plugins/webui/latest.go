@@ -0,0 +1,46 @@
+package webui
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/diebietse/invertergui/mk2core"
+	"github.com/diebietse/invertergui/mk2driver"
+)
+
+// LatestHandler serves the most recent valid update.
+type LatestHandler struct {
+	source mk2core.LatestSource
+}
+
+func NewLatestHandler(source mk2core.LatestSource) *LatestHandler {
+	return &LatestHandler{source: source}
+}
+
+// latestReply holds the fields of the MQTT updates and the age of the update
+// in seconds.
+type latestReply struct {
+	*mk2driver.Mk2Info
+	Stale []string
+	Age   float64
+}
+
+// ServeHTTP replies with the latest update without waiting for the next one,
+// or 503 if no valid update was received yet.
+func (h *LatestHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		rw.Header().Set("Allow", http.MethodGet)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	info, age := h.source.Latest()
+	if info == nil {
+		http.Error(rw, "no data received yet", http.StatusServiceUnavailable)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	reply := latestReply{Mk2Info: info, Stale: info.StaleGroups(), Age: age.Seconds()}
+	if err := json.NewEncoder(rw).Encode(reply); err != nil {
+		log.Errorf("Could not send latest update: %v", err)
+	}
+}
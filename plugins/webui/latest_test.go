@@ -0,0 +1,83 @@
+package webui
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diebietse/invertergui/mk2driver"
+	"github.com/gorilla/websocket"
+)
+
+type fakeLatest struct {
+	c    chan *mk2driver.Mk2Info
+	info *mk2driver.Mk2Info
+}
+
+func (f *fakeLatest) C() chan *mk2driver.Mk2Info {
+	return f.c
+}
+
+func (f *fakeLatest) Close() {}
+
+func (f *fakeLatest) Latest() (*mk2driver.Mk2Info, time.Duration) {
+	if f.info == nil {
+		return nil, 0
+	}
+	return f.info.Copy(), 2 * time.Second
+}
+
+func TestLatestHandler(t *testing.T) {
+	source := &fakeLatest{}
+	rec := httptest.NewRecorder()
+	NewLatestHandler(source).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/latest", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d without data, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	source.info = &mk2driver.Mk2Info{Valid: true, BatVoltage: 12.5}
+	rec = httptest.NewRecorder()
+	NewLatestHandler(source).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/latest", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+	var got struct {
+		BatVoltage float64
+		Stale      []string
+		Age        float64
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.BatVoltage != 12.5 || got.Age != 2 || len(got.Stale) != 4 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestWebGuiWelcome(t *testing.T) {
+	source := &fakeLatest{
+		c:    make(chan *mk2driver.Mk2Info),
+		info: &mk2driver.Mk2Info{Valid: true, BatVoltage: 12.5},
+	}
+	gui := NewWebGui(source)
+	defer gui.Stop()
+	server := httptest.NewServer(http.HandlerFunc(gui.ServeHub))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var got templateInput
+	if err := conn.ReadJSON(&got); err != nil {
+		t.Fatalf("no update sent to new client: %v", err)
+	}
+	if got.BatVoltage != "12.50" {
+		t.Errorf("got battery voltage %q, want %q", got.BatVoltage, "12.50")
+	}
+}
@@ -0,0 +1,88 @@
+package webui
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/diebietse/invertergui/mk2driver"
+)
+
+// RawHandler sends raw protocol commands for diagnostics. Requests must carry
+// the configured token as bearer token.
+type RawHandler struct {
+	transactor mk2driver.RawTransactor
+	token      string
+}
+
+// NewRawHandler returns a handler that only accepts requests authorized with
+// token, which must not be empty.
+func NewRawHandler(transactor mk2driver.RawTransactor, token string) *RawHandler {
+	return &RawHandler{transactor: transactor, token: token}
+}
+
+type rawRequest struct {
+	// Hex encoded command, spaces are ignored.
+	Command string `json:"command"`
+}
+
+type rawResponse struct {
+	Command string `json:"command"`
+	// Hex encoded reply frame from its header up to and including the
+	// checksum.
+	Reply string `json:"reply"`
+}
+
+// ServeHTTP sends the command of a JSON request like {"command": "57 30 0d 00"}
+// and replies with the reply frame.
+func (h *RawHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(rw, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req rawRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(rw, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	cmd, err := hex.DecodeString(strings.Join(strings.Fields(req.Command), ""))
+	if err != nil {
+		http.Error(rw, "invalid command: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Infof("Raw command %x requested by %s", cmd, r.RemoteAddr)
+	reply, err := h.transactor.RawTransaction(cmd)
+	if err != nil {
+		log.Errorf("Raw command %x failed: %v", cmd, err)
+		http.Error(rw, err.Error(), errorStatus(err))
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	resp := rawResponse{Command: hex.EncodeToString(cmd), Reply: hex.EncodeToString(reply)}
+	if err := json.NewEncoder(rw).Encode(resp); err != nil {
+		log.Errorf("Could not send raw reply: %v", err)
+	}
+}
+
+func (h *RawHandler) authorized(r *http.Request) bool {
+	return bearerAuthorized(r, h.token)
+}
+
+// bearerAuthorized reports whether r carries want as bearer token. No request
+// is authorized by an empty token.
+func bearerAuthorized(r *http.Request, want string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || want == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
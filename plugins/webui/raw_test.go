@@ -0,0 +1,96 @@
+package webui
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/diebietse/invertergui/mk2driver"
+)
+
+type fakeTransactor struct {
+	cmd []byte
+	err error
+}
+
+func (f *fakeTransactor) RawTransaction(cmd []byte) ([]byte, error) {
+	f.cmd = cmd
+	if f.err != nil {
+		return nil, f.err
+	}
+	return []byte{0xff, 0x57, 0x85, 0x10, 0x00, 0x10}, nil
+}
+
+func TestRawHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		auth   string
+		body   string
+		err    error
+		status int
+		reply  string
+	}{
+		{
+			name:   "read RAM variable",
+			method: http.MethodPost,
+			auth:   "Bearer secret",
+			body:   `{"command": "57 30 0d 00"}`,
+			status: http.StatusOK,
+			reply:  `{"command":"57300d00","reply":"ff5785100010"}`,
+		},
+		{name: "no token", method: http.MethodPost, body: `{"command": "57"}`, status: http.StatusUnauthorized},
+		{name: "wrong token", method: http.MethodPost, auth: "Bearer guess", body: `{"command": "57"}`, status: http.StatusUnauthorized},
+		{name: "wrong method", method: http.MethodGet, auth: "Bearer secret", status: http.StatusMethodNotAllowed},
+		{name: "invalid hex", method: http.MethodPost, auth: "Bearer secret", body: `{"command": "5g"}`, status: http.StatusBadRequest},
+		{
+			name:   "rejected by driver",
+			method: http.MethodPost,
+			auth:   "Bearer secret",
+			body:   `{"command": ""}`,
+			err:    fmt.Errorf("%w: test", mk2driver.ErrInvalidCommand),
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "no reply",
+			method: http.MethodPost,
+			auth:   "Bearer secret",
+			body:   `{"command": "46 00"}`,
+			err:    mk2driver.ErrTimeout,
+			status: http.StatusGatewayTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transactor := &fakeTransactor{err: tt.err}
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/api/raw", strings.NewReader(tt.body))
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			NewRawHandler(transactor, "secret").ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.reply != "" && strings.TrimSpace(rec.Body.String()) != tt.reply {
+				t.Errorf("got reply %s, want %s", rec.Body.String(), tt.reply)
+			}
+			if tt.status == http.StatusUnauthorized && transactor.cmd != nil {
+				t.Errorf("command %x sent without authorization", transactor.cmd)
+			}
+		})
+	}
+}
+
+func TestRawHandlerEmptyToken(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/raw", strings.NewReader(`{"command": "57"}`))
+	req.Header.Set("Authorization", "Bearer ")
+	NewRawHandler(&fakeTransactor{}, "").ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
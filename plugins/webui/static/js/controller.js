@@ -24,6 +24,10 @@ function loadContent() {
         battery_voltage: 0,
         battery_charge: 0,
         battery_power: 0,
+        battery_temperature: 0,
+        battery_charger_current: 0,
+        battery_inverter_current: 0,
+        inverter_period: 0,
         led_map: [
           { led_mains: "dot-off" },
           { led_absorb: "dot-off" },
@@ -33,8 +37,31 @@ function loadContent() {
           { led_overload: "dot-off" },
           { led_bat_low: "dot-off" },
           { led_over_temp: "dot-off" }
-        ]
-      }
+        ],
+        charger_stage: "",
+        ac_input: "",
+        inputs: [],
+        warnings: {},
+        stale: {},
+        device_interface: "",
+        device_product_code: "",
+        device_firmware: "",
+        switches_supported: false,
+        virtual_switch: false,
+        relay: false,
+        ess_enabled: false,
+        ess_target: "",
+        ess_applied: "",
+        ess_watchdog: false,
+        ess_error: "",
+        energy_today: {},
+        energy_total: {},
+        events: []
+      },
+      control_error: ""
+    },
+    methods: {
+      setSwitch: setSwitch
     }
   });
 
@@ -76,6 +103,39 @@ function connect() {
   }
 }
 
+function setSwitch(name, on) {
+  app.control_error = "";
+  var token = window.localStorage.getItem("control_token");
+  if (!token) {
+    token = window.prompt("Control token");
+    if (!token) {
+      return;
+    }
+    window.localStorage.setItem("control_token", token);
+  }
+  fetch(window.location.pathname + "api/switch", {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      Authorization: "Bearer " + token
+    },
+    body: JSON.stringify({ switch: name, on: on })
+  })
+    .then(function(response) {
+      if (response.status === 401) {
+        window.localStorage.removeItem("control_token");
+      }
+      if (!response.ok) {
+        return response.text().then(function(text) {
+          throw new Error(text || response.statusText);
+        });
+      }
+    })
+    .catch(function(err) {
+      app.control_error = "Could not set " + name + ": " + err.message;
+    });
+}
+
 function getURI() {
   var loc = window.location,
     new_uri;
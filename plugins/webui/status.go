@@ -0,0 +1,35 @@
+package webui
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/diebietse/invertergui/mk2core"
+)
+
+// StatusHandler serves the delivery statistics of the core subscriptions.
+type StatusHandler struct {
+	source mk2core.StatsSource
+}
+
+func NewStatusHandler(source mk2core.StatsSource) *StatusHandler {
+	return &StatusHandler{source: source}
+}
+
+type status struct {
+	Subscriptions []mk2core.SubscriptionStats `json:"subscriptions"`
+}
+
+// ServeHTTP replies with the statistics of every subscription like
+// {"subscriptions": [{"name": "mqtt", "policy": "drop_oldest", "dropped": 3, ...}]}.
+func (h *StatusHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		rw.Header().Set("Allow", http.MethodGet)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(status{Subscriptions: h.source.SubscriptionStats()}); err != nil {
+		log.Errorf("Could not send status: %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package webui
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/diebietse/invertergui/mk2core"
+)
+
+type fakeSubscriptions []mk2core.SubscriptionStats
+
+func (f fakeSubscriptions) SubscriptionStats() []mk2core.SubscriptionStats {
+	return f
+}
+
+func TestStatusHandler(t *testing.T) {
+	source := fakeSubscriptions{
+		{Name: "mqtt", Policy: "drop_oldest", Buffer: 16, Queued: 2, Delivered: 10, Dropped: 3},
+	}
+	rec := httptest.NewRecorder()
+	NewStatusHandler(source).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+	var got status
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Subscriptions, []mk2core.SubscriptionStats(source)) {
+		t.Errorf("got %+v, want %+v", got.Subscriptions, source)
+	}
+
+	rec = httptest.NewRecorder()
+	NewStatusHandler(source).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/status", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
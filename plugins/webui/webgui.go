@@ -36,6 +36,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/diebietse/invertergui/mk2core"
 	"github.com/diebietse/invertergui/mk2driver"
 	"github.com/diebietse/invertergui/websocket"
 	"github.com/sirupsen/logrus"
@@ -51,20 +52,34 @@ const (
 	BlinkGreen = "blink-green"
 )
 
+// Number of driver events shown in the web GUI.
+const maxEvents = 10
+
 type WebGui struct {
 	mk2driver.Mk2
 	stopChan chan struct{}
 
 	wg  sync.WaitGroup
 	hub *websocket.Hub
+
+	// Most recent driver events, newest first.
+	events     []eventInput
+	eventsLock sync.Mutex
 }
 
+// NewWebGui serves the updates of source to the websocket clients. When source
+// keeps the latest update new clients receive it as soon as they connect.
 func NewWebGui(source mk2driver.Mk2) *WebGui {
 	w := &WebGui{
 		stopChan: make(chan struct{}),
 		Mk2:      source,
 		hub:      websocket.NewHub(),
 	}
+	if latest, ok := source.(mk2core.LatestSource); ok {
+		w.hub.SetWelcome(func() interface{} {
+			return w.welcome(latest)
+		})
+	}
 	w.wg.Add(1)
 	go w.dataPoll()
 	return w
@@ -89,11 +104,69 @@ type templateInput struct {
 	BatCurrent string `json:"battery_current"`
 	BatPower   string `json:"battery_power"`
 	BatCharge  string `json:"battery_charge"`
+	// Values the device does not report are "n/a".
+	BatTemperature     string `json:"battery_temperature"`
+	BatChargerCurrent  string `json:"battery_charger_current"`
+	BatInverterCurrent string `json:"battery_inverter_current"`
+	InverterPeriod     string `json:"inverter_period"`
 
 	InFreq  string `json:"input_frequency"`
 	OutFreq string `json:"output_frequency"`
 
 	LedMap map[string]string `json:"led_map"`
+
+	ChargerStage string `json:"charger_stage"`
+	ACInput      string `json:"ac_input"`
+	// Per AC input readings, empty when the active input is unknown.
+	Inputs []inputInput `json:"inputs"`
+	// Active warnings by name with their severity.
+	Warnings map[string]string `json:"warnings"`
+
+	// Sample groups whose values are stale, keyed by group name.
+	Stale map[string]bool `json:"stale"`
+
+	DeviceInterface   string `json:"device_interface"`
+	DeviceProductCode string `json:"device_product_code"`
+	DeviceFirmware    string `json:"device_firmware"`
+
+	SwitchesSupported bool `json:"switches_supported"`
+	VirtualSwitch     bool `json:"virtual_switch"`
+	Relay             bool `json:"relay"`
+
+	ESSEnabled  bool   `json:"ess_enabled"`
+	ESSTarget   string `json:"ess_target"`
+	ESSApplied  string `json:"ess_applied"`
+	ESSWatchdog bool   `json:"ess_watchdog"`
+	ESSError    string `json:"ess_error"`
+
+	EnergyToday energyInput `json:"energy_today"`
+	EnergyTotal energyInput `json:"energy_total"`
+
+	Events []eventInput `json:"events"`
+}
+
+type inputInput struct {
+	Name      string `json:"name"`
+	Active    bool   `json:"active"`
+	Voltage   string `json:"voltage"`
+	Current   string `json:"current"`
+	Frequency string `json:"frequency"`
+	Date      string `json:"date"`
+}
+
+// energyInput holds energy counters in kWh.
+type energyInput struct {
+	GridImport   string `json:"grid_import"`
+	GridExport   string `json:"grid_export"`
+	ACOutput     string `json:"ac_output"`
+	BatCharge    string `json:"battery_charge"`
+	BatDischarge string `json:"battery_discharge"`
+}
+
+type eventInput struct {
+	Date   string `json:"date"`
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
 }
 
 func (w *WebGui) ServeHub(rw http.ResponseWriter, r *http.Request) {
@@ -109,8 +182,8 @@ func ledName(led mk2driver.Led) string {
 }
 
 func buildTemplateInput(status *mk2driver.Mk2Info) *templateInput {
-	outPower := status.OutVoltage * status.OutCurrent
-	inPower := status.InCurrent * status.InVoltage
+	outPower := status.Derived.OutPower
+	inPower := status.Derived.InPower
 
 	tmpInput := &templateInput{
 		Error:      status.Errors,
@@ -128,10 +201,53 @@ func buildTemplateInput(status *mk2driver.Mk2Info) *templateInput {
 
 		BatCurrent: fmt.Sprintf("%.2f", status.BatCurrent),
 		BatVoltage: fmt.Sprintf("%.2f", status.BatVoltage),
-		BatPower:   fmt.Sprintf("%.2f", status.BatVoltage*status.BatCurrent),
+		BatPower:   fmt.Sprintf("%.2f", status.Derived.BatPower),
 		BatCharge:  fmt.Sprintf("%.2f", status.ChargeState*100),
 
+		BatTemperature:     formatSupported(status, mk2driver.ValueBatTemperature, status.BatTemperature),
+		BatChargerCurrent:  formatSupported(status, mk2driver.ValueBatChargerCurrent, status.BatChargerCurrent),
+		BatInverterCurrent: formatSupported(status, mk2driver.ValueBatInverterCurrent, status.BatInverterCurrent),
+		InverterPeriod:     formatSupported(status, mk2driver.ValueInverterPeriod, status.InverterPeriod*1000),
+
 		LedMap: map[string]string{},
+
+		ChargerStage: status.Status.ChargerStage.String(),
+		ACInput:      status.Status.ACInput.String(),
+		Warnings:     map[string]string{},
+
+		Stale: map[string]bool{},
+
+		SwitchesSupported: status.Switches.Supported,
+		VirtualSwitch:     status.Switches.VirtualSwitch,
+		Relay:             status.Switches.Relay,
+
+		EnergyToday: buildEnergyInput(status.Energy.Today),
+		EnergyTotal: buildEnergyInput(status.Energy.Total),
+	}
+	if status.ESS.Enabled {
+		tmpInput.ESSEnabled = true
+		tmpInput.ESSTarget = fmt.Sprintf("%.0f", status.ESS.Target)
+		tmpInput.ESSApplied = fmt.Sprintf("%.0f", status.ESS.Applied)
+		tmpInput.ESSWatchdog = status.ESS.WatchdogTripped
+		tmpInput.ESSError = status.ESS.Error
+	}
+	addWarning(tmpInput.Warnings, "Overload", status.Status.Overload)
+	addWarning(tmpInput.Warnings, "Low battery", status.Status.LowBattery)
+	addWarning(tmpInput.Warnings, "Temperature", status.Status.Temperature)
+	if status.Device.InterfaceVersion != 0 {
+		tmpInput.DeviceInterface = fmt.Sprintf("%v %d", status.Device.Interface, status.Device.InterfaceVersion)
+	}
+	if status.Device.FirmwareVersion != 0 {
+		tmpInput.DeviceProductCode = fmt.Sprintf("%d", status.Device.ProductCode)
+		tmpInput.DeviceFirmware = fmt.Sprintf("%d", status.Device.FirmwareVersion)
+	}
+	if status.ActiveInput != mk2driver.InputUnknown {
+		for input := mk2driver.InputAC1; input <= mk2driver.MaxACInputs; input++ {
+			tmpInput.Inputs = append(tmpInput.Inputs, buildInputInput(status, input))
+		}
+	}
+	for _, name := range status.StaleGroups() {
+		tmpInput.Stale[name] = true
 	}
 	for k, v := range status.LEDs {
 		if k == mk2driver.LedOverload || k == mk2driver.LedTemperature || k == mk2driver.LedLowBattery {
@@ -157,6 +273,80 @@ func buildTemplateInput(status *mk2driver.Mk2Info) *templateInput {
 	return tmpInput
 }
 
+func formatSupported(status *mk2driver.Mk2Info, value string, v float64) string {
+	if !status.Supported(value) {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.2f", v)
+}
+
+func buildInputInput(status *mk2driver.Mk2Info, input mk2driver.InputSource) inputInput {
+	reading := status.Input(input)
+	in := inputInput{
+		Name:   fmt.Sprintf("AC in %d", input-mk2driver.InputAC1+1),
+		Active: input == status.ActiveInput,
+	}
+	if reading.Available {
+		in.Voltage = fmt.Sprintf("%.2f", reading.Voltage)
+		in.Current = fmt.Sprintf("%.2f", reading.Current)
+		in.Frequency = fmt.Sprintf("%.2f", reading.Frequency)
+	}
+	if !reading.Timestamp.IsZero() {
+		in.Date = reading.Timestamp.Format(time.RFC1123Z)
+	}
+	return in
+}
+
+func buildEnergyInput(energy mk2driver.EnergyCounters) energyInput {
+	return energyInput{
+		GridImport:   fmt.Sprintf("%.2f", energy.GridImport),
+		GridExport:   fmt.Sprintf("%.2f", energy.GridExport),
+		ACOutput:     fmt.Sprintf("%.2f", energy.ACOutput),
+		BatCharge:    fmt.Sprintf("%.2f", energy.BatCharge),
+		BatDischarge: fmt.Sprintf("%.2f", energy.BatDischarge),
+	}
+}
+
+// welcome returns the latest update for a new client, nil if there is none.
+func (w *WebGui) welcome(latest mk2core.LatestSource) interface{} {
+	info, _ := latest.Latest()
+	if info == nil {
+		return nil
+	}
+	tmpInput := buildTemplateInput(info)
+	tmpInput.Events = w.currentEvents()
+	return tmpInput
+}
+
+// addEvents keeps the most recent driver events to show to clients.
+func (w *WebGui) addEvents(events []mk2driver.Event) {
+	w.eventsLock.Lock()
+	defer w.eventsLock.Unlock()
+	for _, e := range events {
+		w.events = append([]eventInput{{
+			Date:   e.Timestamp.Format(time.RFC1123Z),
+			Type:   e.Type.String(),
+			Detail: e.Detail,
+		}}, w.events...)
+	}
+	if len(w.events) > maxEvents {
+		w.events = w.events[:maxEvents]
+	}
+}
+
+// currentEvents returns a copy of the most recent driver events.
+func (w *WebGui) currentEvents() []eventInput {
+	w.eventsLock.Lock()
+	defer w.eventsLock.Unlock()
+	return append([]eventInput(nil), w.events...)
+}
+
+func addWarning(warnings map[string]string, name string, severity mk2driver.Severity) {
+	if severity != mk2driver.SeverityNone {
+		warnings[name] = severity.String()
+	}
+}
+
 func (w *WebGui) Stop() {
 	close(w.stopChan)
 	w.wg.Wait()
@@ -167,9 +357,16 @@ func (w *WebGui) Stop() {
 func (w *WebGui) dataPoll() {
 	for {
 		select {
-		case s := <-w.C():
+		case s, ok := <-w.C():
+			if !ok {
+				w.wg.Done()
+				return
+			}
+			w.addEvents(s.Events)
 			if s.Valid {
-				err := w.hub.Broadcast(buildTemplateInput(s))
+				tmpInput := buildTemplateInput(s)
+				tmpInput.Events = w.currentEvents()
+				err := w.hub.Broadcast(tmpInput)
 				if err != nil {
 					log.Errorf("Could not send update to clients: %v", err)
 				}
@@ -36,6 +36,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/diebietse/invertergui/mk2core"
 	"github.com/diebietse/invertergui/mk2driver"
 )
 
@@ -58,8 +59,42 @@ var templateInputTests = []templateTest{
 			OutFrequency: 50,
 			ChargeState:  1,
 			LEDs:         map[mk2driver.Led]mk2driver.LEDstate{mk2driver.LedMain: mk2driver.LedOn},
-			Errors:       nil,
-			Timestamp:    fakenow,
+			Status: mk2driver.Status{
+				ChargerStage: mk2driver.ChargerFloat,
+				ACInput:      mk2driver.ACInputAccepted,
+				LowBattery:   mk2driver.SeverityWarning,
+			},
+			Errors:    nil,
+			Timestamp: fakenow,
+			Device: mk2driver.DeviceInfo{
+				Interface:        mk2driver.InterfaceMK3,
+				InterfaceVersion: 1170212,
+				FirmwareVersion:  2629467,
+				ProductCode:      2629,
+			},
+			ActiveInput: mk2driver.InputAC2,
+			Inputs: [mk2driver.MaxACInputs]mk2driver.ACReading{
+				{},
+				{Available: true, Voltage: 230.1, Current: 2.3, Frequency: 50, Timestamp: fakenow},
+			},
+			Switches: mk2driver.SwitchState{
+				Supported: true,
+				Relay:     true,
+			},
+			SampleTimes: mk2driver.SampleTimes{
+				DC:          fakenow,
+				AC:          fakenow.Add(-10 * time.Second),
+				LEDs:        fakenow,
+				ChargeState: fakenow,
+			},
+			BatInverterCurrent: 10,
+			InverterPeriod:     0.02,
+			Unsupported:        []string{mk2driver.ValueBatTemperature},
+			ESS:                mk2driver.ESSState{Enabled: true, Target: -500, Applied: 0, WatchdogTripped: true},
+			Energy: mk2driver.EnergyValues{
+				Total: mk2driver.EnergyCounters{GridImport: 12.345, ACOutput: 10, BatCharge: 1.5, BatDischarge: 1},
+				Today: mk2driver.EnergyCounters{GridImport: 2, GridExport: 0.25},
+			},
 		},
 		output: &templateInput{
 			Error:      nil,
@@ -78,12 +113,52 @@ var templateInputTests = []templateTest{
 			OutFreq:    "50.00",
 			BatCharge:  "100.00",
 			LedMap:     map[string]string{"led_mains": "dot-green"},
+
+			ChargerStage: "float",
+			ACInput:      "accepted",
+			Warnings:     map[string]string{"Low battery": "warning"},
+
+			Inputs: []inputInput{
+				{Name: "AC in 1"},
+				{
+					Name:      "AC in 2",
+					Active:    true,
+					Voltage:   "230.10",
+					Current:   "2.30",
+					Frequency: "50.00",
+					Date:      fakenow.Format(time.RFC1123Z),
+				},
+			},
+
+			Stale: map[string]bool{"ac": true},
+
+			DeviceInterface:   "MK3 1170212",
+			DeviceProductCode: "2629",
+			DeviceFirmware:    "2629467",
+
+			SwitchesSupported: true,
+			Relay:             true,
+
+			BatTemperature:     "n/a",
+			BatChargerCurrent:  "0.00",
+			BatInverterCurrent: "10.00",
+			InverterPeriod:     "20.00",
+
+			ESSEnabled:  true,
+			ESSTarget:   "-500",
+			ESSApplied:  "0",
+			ESSWatchdog: true,
+
+			EnergyToday: energyInput{GridImport: "2.00", GridExport: "0.25", ACOutput: "0.00", BatCharge: "0.00", BatDischarge: "0.00"},
+			EnergyTotal: energyInput{GridImport: "12.35", GridExport: "0.00", ACOutput: "10.00", BatCharge: "1.50", BatDischarge: "1.00"},
 		},
 	},
 }
 
 func TestTemplateInput(t *testing.T) {
 	for i := range templateInputTests {
+		// The core derives the powers from the measured values.
+		mk2core.Derive(templateInputTests[i].input)
 		templateInput := buildTemplateInput(templateInputTests[i].input)
 		if !reflect.DeepEqual(templateInput, templateInputTests[i].output) {
 			t.Errorf("buildTemplateInput not producing expected results")
@@ -91,3 +166,19 @@ func TestTemplateInput(t *testing.T) {
 		}
 	}
 }
+
+func TestAddEvents(t *testing.T) {
+	w := &WebGui{}
+	for i := 0; i < maxEvents; i++ {
+		w.addEvents([]mk2driver.Event{{Type: mk2driver.EventLockAcquired, Timestamp: fakenow}})
+	}
+	w.addEvents([]mk2driver.Event{{Type: mk2driver.EventBootup, Timestamp: fakenow, Detail: "test"}})
+
+	if len(w.events) != maxEvents {
+		t.Errorf("got %d events, want %d", len(w.events), maxEvents)
+	}
+	want := eventInput{Date: fakenow.Format(time.RFC1123Z), Type: "bootup", Detail: "test"}
+	if w.events[0] != want {
+		t.Errorf("got newest event %v, want %v", w.events[0], want)
+	}
+}
@@ -1,6 +1,7 @@
 package websocket
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
 	"time"
@@ -82,6 +83,16 @@ func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	client := &ClientHandler{hub: h, conn: conn, send: make(chan []byte, 256)}
+	if h.welcome != nil {
+		if message := h.welcome(); message != nil {
+			payload, err := json.Marshal(message)
+			if err != nil {
+				log.Println(err)
+			} else {
+				client.send <- payload
+			}
+		}
+	}
 	client.hub.register <- client
 
 	// Allow collection of memory referenced by the caller by doing all work in
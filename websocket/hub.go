@@ -18,6 +18,9 @@ type Hub struct {
 
 	// Unregister requests from clients.
 	unregister chan *ClientHandler
+
+	// Returns the message sent to new clients, nil if there is none.
+	welcome func() interface{}
 }
 
 func NewHub() *Hub {
@@ -31,6 +34,13 @@ func NewHub() *Hub {
 	return tmp
 }
 
+// SetWelcome sets the function returning the message sent to every new client
+// before the broadcasts, so that it does not have to wait for the next one. It
+// must be set before clients connect.
+func (h *Hub) SetWelcome(welcome func() interface{}) {
+	h.welcome = welcome
+}
+
 func (h *Hub) Broadcast(message interface{}) error {
 	payload, err := json.Marshal(message)
 	if err != nil {